@@ -0,0 +1,32 @@
+package app
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Audit events are append-only, even for direct queries
+func setupAuditEvents(database *gorm.DB) error {
+	if err := database.Exec(`CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_events is append-only';
+		END;
+		$$ LANGUAGE plpgsql`).Error; err != nil {
+		return fmt.Errorf("Could not create audit events function: %w", err)
+	}
+
+	if err := database.Exec(`CREATE OR REPLACE TRIGGER audit_events_append_only
+		BEFORE UPDATE OR DELETE ON audit_events
+		FOR EACH ROW EXECUTE FUNCTION audit_events_append_only()`).Error; err != nil {
+		return fmt.Errorf("Could not create audit events trigger: %w", err)
+	}
+
+	if err := database.Exec(`CREATE OR REPLACE TRIGGER audit_events_no_truncate
+		BEFORE TRUNCATE ON audit_events
+		FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only()`).Error; err != nil {
+		return fmt.Errorf("Could not create audit events truncate trigger: %w", err)
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/util"
 	"github.com/getsentry/sentry-go"
 )
 
@@ -34,21 +35,47 @@ func Auth() *casbin.SyncedEnforcer {
 			os.Exit(1)
 		}
 
-		e, err := casbin.NewSyncedEnforcer(modelFile, policyFile)
+		if err := seedPolicies(DB(), modelFile, policyFile); err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not seed policies: %v", err))
+			os.Exit(1)
+		}
+
+		e, err := casbin.NewSyncedEnforcer(modelFile, newPolicyAdapter(DB()))
 		if err != nil {
 			sentry.CaptureException(err)
 			slog.Error(fmt.Sprintf("Could not create enforcer: %v", err))
 			os.Exit(1)
 		}
 
-		if err := e.LoadPolicy(); err != nil {
+		watcher := newPolicyWatcher()
+		if err := e.SetWatcher(watcher); err != nil {
 			sentry.CaptureException(err)
-			slog.Error(fmt.Sprintf("Could not load policy: %v", err))
+			slog.Error(fmt.Sprintf("Could not set policy watcher: %v", err))
 			os.Exit(1)
 		}
 
+		// The default callback reloads the policies without locking the enforcer
+		if err := watcher.SetUpdateCallback(func(string) {
+			if err := e.LoadPolicy(); err != nil {
+				sentry.CaptureException(err)
+				slog.Error(fmt.Sprintf("Could not reload policy: %v", err))
+			}
+		}); err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not set policy watcher: %v", err))
+			os.Exit(1)
+		}
+
+		addDomainMatching(e.Enforcer)
+
 		auth = e
 	})
 
 	return auth
 }
+
+// Domains of the role inheritances are patterns, so the ones in * apply to every domain
+func addDomainMatching(e *casbin.Enforcer) {
+	e.AddNamedDomainMatchingFunc("g", "keyMatch", util.KeyMatch)
+}
@@ -60,20 +60,64 @@ func DB() *gorm.DB {
 			slog.Error(fmt.Sprintf("Could not load unaccent extension: %v", err))
 		}
 
+		if err := database.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not load pg_trgm extension: %v", err))
+		}
+
+		if err := setupReportPartitions(database); err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not setup reports partitions: %v", err))
+			os.Exit(1)
+		}
+
 		if err := database.AutoMigrate(
 			&models.User{},
 			&models.Role{},
 			&models.UserRole{},
+			&models.CasbinRule{},
 			&models.UserActivation{},
 			&models.AccountRecovery{},
+			&models.APIToken{},
+			&models.UserIdentity{},
 			&models.Report{},
+			&models.Organization{},
+			&models.OrganizationMember{},
+			&models.OrganizationInvitation{},
 			&models.Site{},
+			&models.SiteDomain{},
+			&models.HourlyReportRollup{},
+			&models.DailyReportRollup{},
+			&models.Export{},
+			&models.ViolationGroup{},
+			&models.ViolationGroupComment{},
+			&models.AlertRule{},
+			&models.AlertEvent{},
+			&models.Webhook{},
+			&models.WebhookDelivery{},
+			&models.NotificationPreference{},
+			&models.SiteSubscription{},
+			&models.Notification{},
+			&models.NotificationDigestItem{},
+			&models.AuditEvent{},
 		); err != nil {
 			sentry.CaptureException(err)
 			slog.Error(fmt.Sprintf("Could not migrate models: %v", err))
 			os.Exit(1)
 		}
 
+		if err := setupReportSearch(database); err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not setup reports search: %v", err))
+			os.Exit(1)
+		}
+
+		if err := setupAuditEvents(database); err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not setup audit events: %v", err))
+			os.Exit(1)
+		}
+
 		db = database
 	})
 
@@ -115,14 +159,80 @@ func setupSites() {
 		Title:  utils.ToStringPtr(os.Getenv("APP_NAME")),
 		Domain: domain,
 	}
-	if err := DB().Model(&models.Site{}).
+	// A deleted default site stays deleted
+	if err := DB().Unscoped().Model(&models.Site{}).
 		Where("unaccent(lower(domain)) = unaccent(lower(@domain))", sql.Named("domain", domain)).
 		FirstOrCreate(&defaultSite).Error; err != nil {
 		slog.Error(fmt.Sprintf("Could not create default site: %v", err))
+		return
+	}
+
+	// The domain of the app is trusted, it comes from the configuration
+	if err := DB().Model(&models.Site{}).
+		Where(&models.Site{ID: defaultSite.ID}).
+		Where("verified_at IS NULL").
+		Updates(map[string]interface{}{"verified_at": gorm.Expr("clock_timestamp()"), "verification_method": "config"}).Error; err != nil {
+		slog.Error(fmt.Sprintf("Could not verify default site: %v", err))
+	}
+
+	// Sites created before domain verification existed
+	if err := DB().Unscoped().Model(&models.Site{}).
+		Where("verification_token = ''").
+		Update("verification_token", gorm.Expr("replace(gen_random_uuid()::text, '-', '')")).Error; err != nil {
+		slog.Error(fmt.Sprintf("Could not create site verification tokens: %v", err))
+	}
+}
+
+// Existing users and sites are moved to a default organization the first time
+func setupOrganizations() {
+	var n int64
+	if err := DB().Unscoped().Model(&models.Organization{}).Count(&n).Error; err != nil {
+		slog.Error(fmt.Sprintf("Could not count organizations: %v", err))
+		return
+	}
+
+	defaultOrg := &models.Organization{Slug: "default"}
+
+	if n < 1 {
+		defaultOrg.Name = os.Getenv("APP_NAME")
+
+		if len(defaultOrg.Name) < 1 {
+			defaultOrg.Name = "Default"
+		}
+
+		if err := DB().Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&defaultOrg).Error; err != nil {
+				return err
+			}
+
+			return tx.Exec(`INSERT INTO organization_members (organization_id, user_id, role)
+				SELECT @org_id, u.id, CASE WHEN EXISTS (
+					SELECT 1 FROM user_roles ur
+					INNER JOIN roles r ON r.id = ur.role_id
+					WHERE ur.user_id = u.id AND ur.deleted_at IS NULL AND r.name IN ('superadmin', 'admin')
+				) THEN 'admin' ELSE 'member' END
+				FROM users u
+				WHERE u.deleted_at IS NULL AND u.active`,
+				sql.Named("org_id", defaultOrg.ID),
+			).Error
+		}); err != nil {
+			slog.Error(fmt.Sprintf("Could not create default organization: %v", err))
+			return
+		}
+	} else if err := DB().Where(&defaultOrg).First(&defaultOrg).Error; err != nil {
+		// The default organization was deleted, new sites have no owner
+		return
+	}
+
+	if err := DB().Unscoped().Model(&models.Site{}).
+		Where("organization_id IS NULL").
+		Update("organization_id", defaultOrg.ID).Error; err != nil {
+		slog.Error(fmt.Sprintf("Could not assign sites to the default organization: %v", err))
 	}
 }
 
 func SetupDefaultData() {
 	setupRoles()
 	setupSites()
+	setupOrganizations()
 }
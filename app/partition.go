@@ -0,0 +1,230 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"gorm.io/gorm"
+)
+
+const reportsTable string = "reports"
+
+type reportPartition struct {
+	Name  string
+	Start time.Time
+}
+
+func (p reportPartition) End() time.Time {
+	return p.Start.AddDate(0, 1, 0)
+}
+
+func newReportPartition(t time.Time) reportPartition {
+	t = t.In(time.UTC)
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	return reportPartition{
+		Name:  fmt.Sprintf("%s_y%04dm%02d", reportsTable, start.Year(), start.Month()),
+		Start: start,
+	}
+}
+
+func setupReportPartitions(database *gorm.DB) error {
+	kind := ""
+	if err := database.Raw(
+		"SELECT c.relkind::text FROM pg_class c INNER JOIN pg_namespace n ON c.relnamespace = n.oid WHERE c.relname = @table AND n.nspname = current_schema()",
+		sql.Named("table", reportsTable),
+	).Scan(&kind).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	switch kind {
+	case "":
+		if err := createPartitionedReportsTable(database); err != nil {
+			return fmt.Errorf("Could not create partitioned reports table: %w", err)
+		}
+	case "r":
+		slog.Warn("The reports table is not partitioned. Migrating existing reports, this may take a while.")
+
+		if err := migrateLegacyReportsTable(database, now); err != nil {
+			return fmt.Errorf("Could not migrate reports table: %w", err)
+		}
+	}
+
+	return createReportPartitions(database, now, utils.ReportsPartitionPremake())
+}
+
+func createPartitionedReportsTable(database *gorm.DB) error {
+	// The remaining columns are added by the auto migration
+	return database.Exec(`CREATE TABLE reports (
+		id uuid NOT NULL DEFAULT gen_random_uuid(),
+		created_at timestamptz NOT NULL DEFAULT clock_timestamp(),
+		CONSTRAINT reports_pkey PRIMARY KEY (id, created_at)
+	) PARTITION BY RANGE (created_at)`).Error
+}
+
+func migrateLegacyReportsTable(database *gorm.DB, now time.Time) error {
+	return database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE TABLE reports_partitioned (LIKE reports INCLUDING DEFAULTS INCLUDING CONSTRAINTS) PARTITION BY RANGE (created_at)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("ALTER TABLE reports_partitioned ADD CONSTRAINT reports_partitioned_pkey PRIMARY KEY (id, created_at)").Error; err != nil {
+			return err
+		}
+
+		oldest := sql.NullTime{}
+		if err := tx.Raw("SELECT min(created_at) FROM reports").Scan(&oldest).Error; err != nil {
+			return err
+		}
+
+		if oldest.Valid {
+			for p := newReportPartition(oldest.Time); p.Start.Before(now); p = newReportPartition(p.End()) {
+				if err := createReportPartition(tx, "reports_partitioned", p); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, p := range upcomingReportPartitions(now, utils.ReportsPartitionPremake()) {
+			if err := createReportPartition(tx, "reports_partitioned", p); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Exec("INSERT INTO reports_partitioned SELECT * FROM reports").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("DROP TABLE reports").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("ALTER TABLE reports_partitioned RENAME TO reports").Error; err != nil {
+			return err
+		}
+
+		return tx.Exec("ALTER TABLE reports RENAME CONSTRAINT reports_partitioned_pkey TO reports_pkey").Error
+	})
+}
+
+func upcomingReportPartitions(now time.Time, months int) []reportPartition {
+	partitions := []reportPartition{}
+
+	for p, i := newReportPartition(now), 0; i <= months; p, i = newReportPartition(p.End()), i+1 {
+		partitions = append(partitions, p)
+	}
+
+	return partitions
+}
+
+func createReportPartition(database *gorm.DB, parent string, p reportPartition) error {
+	//#nosec G201 -- Table names are generated from dates, not user input
+	return database.Exec(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+		p.Name,
+		parent,
+		p.Start.Format(time.RFC3339),
+		p.End().Format(time.RFC3339),
+	)).Error
+}
+
+func createReportPartitions(database *gorm.DB, now time.Time, months int) error {
+	for _, p := range upcomingReportPartitions(now, months) {
+		if err := createReportPartition(database, reportsTable, p); err != nil {
+			return fmt.Errorf("Could not create partition %s: %w", p.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func listReportPartitions(database *gorm.DB) ([]reportPartition, error) {
+	names := []string{}
+	if err := database.Raw(
+		`SELECT c.relname FROM pg_inherits i
+		INNER JOIN pg_class c ON i.inhrelid = c.oid
+		INNER JOIN pg_class p ON i.inhparent = p.oid
+		INNER JOIN pg_namespace n ON p.relnamespace = n.oid
+		WHERE p.relname = @table AND n.nspname = current_schema()`,
+		sql.Named("table", reportsTable),
+	).Scan(&names).Error; err != nil {
+		return nil, err
+	}
+
+	partitions := []reportPartition{}
+
+	for _, name := range names {
+		var year, month int
+
+		if _, err := fmt.Sscanf(name, reportsTable+"_y%dm%d", &year, &month); err != nil || month < 1 || month > 12 {
+			slog.Warn(fmt.Sprintf("Ignoring unknown reports partition: %s", name))
+			continue
+		}
+
+		p := newReportPartition(time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC))
+
+		if p.Name != name {
+			slog.Warn(fmt.Sprintf("Ignoring unknown reports partition: %s", name))
+			continue
+		}
+
+		partitions = append(partitions, p)
+	}
+
+	return partitions, nil
+}
+
+func MaintainReportPartitions(now time.Time) error {
+	if err := createReportPartitions(DB(), now, utils.ReportsPartitionPremake()); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	retention := utils.ReportsRetentionMonths()
+
+	if retention < 1 {
+		return nil
+	}
+
+	partitions, err := listReportPartitions(DB())
+	if err != nil {
+		sentry.CaptureException(err)
+		return fmt.Errorf("Could not list reports partitions: %w", err)
+	}
+
+	cutoff := newReportPartition(now).Start.AddDate(0, -retention, 0)
+
+	for _, p := range partitions {
+		if p.End().After(cutoff) {
+			continue
+		}
+
+		//#nosec G201 -- Partition names are validated when listed
+		if err := DB().Exec(fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", reportsTable, p.Name)).Error; err != nil {
+			sentry.CaptureException(err)
+			return fmt.Errorf("Could not detach partition %s: %w", p.Name, err)
+		}
+
+		slog.Info(fmt.Sprintf("Detached reports partition: %s", p.Name))
+
+		if !utils.DropDetachedPartitions() {
+			continue
+		}
+
+		//#nosec G201 -- Partition names are validated when listed
+		if err := DB().Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", p.Name)).Error; err != nil {
+			sentry.CaptureException(err)
+			return fmt.Errorf("Could not drop partition %s: %w", p.Name, err)
+		}
+
+		slog.Info(fmt.Sprintf("Dropped reports partition: %s", p.Name))
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/models"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/redis/rueidis"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const policyChannel string = "casbin:policy"
+
+// Casbin adapter storing the policies in the casbin_rules table
+type policyAdapter struct {
+	db *gorm.DB
+}
+
+func newPolicyAdapter(db *gorm.DB) *policyAdapter {
+	return &policyAdapter{db: db}
+}
+
+func casbinRuleConflict() clause.OnConflict {
+	return clause.OnConflict{
+		Columns: []clause.Column{
+			{Name: "ptype"}, {Name: "v0"}, {Name: "v1"}, {Name: "v2"}, {Name: "v3"}, {Name: "v4"}, {Name: "v5"},
+		},
+	}
+}
+
+func (a *policyAdapter) LoadPolicy(m model.Model) error {
+	rules := []models.CasbinRule{}
+	if err := a.db.Order("created_at ASC").Find(&rules).Error; err != nil {
+		return err
+	}
+
+	for _, r := range rules {
+		if err := persist.LoadPolicyArray(append([]string{r.Ptype}, r.Rule()...), m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *policyAdapter) SavePolicy(m model.Model) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&models.CasbinRule{}).Error; err != nil {
+			return err
+		}
+
+		for _, sec := range []string{"p", "g"} {
+			for ptype, ast := range m[sec] {
+				for _, rule := range ast.Policy {
+					if err := addCasbinRule(tx, ptype, rule); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func (a *policyAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	return addCasbinRule(a.db, ptype, rule)
+}
+
+func (a *policyAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	return a.db.Where(models.NewCasbinRule(ptype, rule).Fields()).Delete(&models.CasbinRule{}).Error
+}
+
+func (a *policyAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	query := a.db.Where("ptype = ?", ptype)
+
+	for i, v := range fieldValues {
+		if len(v) < 1 || fieldIndex+i > 5 {
+			continue
+		}
+
+		query = query.Where(fmt.Sprintf("v%d = ?", fieldIndex+i), v)
+	}
+
+	return query.Delete(&models.CasbinRule{}).Error
+}
+
+// Removed rules are soft deleted, adding them again restores them
+func addCasbinRule(tx *gorm.DB, ptype string, rule []string) error {
+	r := models.NewCasbinRule(ptype, rule)
+	conflict := casbinRuleConflict()
+	conflict.DoUpdates = clause.Assignments(map[string]any{"deleted_at": nil, "updated_at": time.Now()})
+
+	return tx.Clauses(conflict).Create(&r).Error
+}
+
+// Adds the rules of the policy file that were never stored, so new
+// default policies are added on upgrade but removed ones stay removed
+func seedPolicies(db *gorm.DB, modelFile string, policyFile string) error {
+	m, err := model.NewModelFromFile(modelFile)
+	if err != nil {
+		return err
+	}
+
+	if err := fileadapter.NewAdapter(policyFile).LoadPolicy(m); err != nil {
+		return err
+	}
+
+	rules := []models.CasbinRule{}
+
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range m[sec] {
+			for _, rule := range ast.Policy {
+				rules = append(rules, models.NewCasbinRule(ptype, rule))
+			}
+		}
+	}
+
+	if len(rules) < 1 {
+		return nil
+	}
+
+	conflict := casbinRuleConflict()
+	conflict.DoNothing = true
+
+	return db.Clauses(conflict).Create(&rules).Error
+}
+
+// Casbin watcher reloading the policies of every instance through Redis pub/sub
+type policyWatcher struct {
+	id       string
+	callback func(string)
+	cancel   context.CancelFunc
+	mu       sync.RWMutex
+}
+
+func newPolicyWatcher() *policyWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &policyWatcher{id: uuid.NewString(), cancel: cancel}
+
+	go w.listen(ctx)
+
+	return w
+}
+
+func (w *policyWatcher) listen(ctx context.Context) {
+	cmd := Cache().B().Subscribe().Channel(policyChannel).Build()
+	reconnected := false
+
+	for ctx.Err() == nil {
+		// Updates could have been missed while disconnected
+		if reconnected {
+			w.notify("")
+		}
+
+		err := Cache().Receive(ctx, cmd, func(msg rueidis.PubSubMessage) {
+			if msg.Message == w.id {
+				return
+			}
+
+			w.notify(msg.Message)
+		})
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Policy watcher error: %v", err))
+		}
+
+		reconnected = true
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (w *policyWatcher) notify(msg string) {
+	w.mu.RLock()
+	callback := w.callback
+	w.mu.RUnlock()
+
+	if callback != nil {
+		callback(msg)
+	}
+}
+
+func (w *policyWatcher) SetUpdateCallback(callback func(string)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.callback = callback
+
+	return nil
+}
+
+func (w *policyWatcher) Update() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return Cache().Do(ctx, Cache().B().Publish().Channel(policyChannel).Message(w.id).Build()).Error()
+}
+
+func (w *policyWatcher) Close() {
+	w.cancel()
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+)
+
+func newTestEnforcer(t *testing.T) *casbin.Enforcer {
+	t.Helper()
+
+	e, err := casbin.NewEnforcer("../casbin/model.conf", "../casbin/policy.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addDomainMatching(e)
+
+	return e
+}
+
+func TestDefaultPolicies(t *testing.T) {
+	e := newTestEnforcer(t)
+
+	tests := []struct {
+		role     string
+		endpoint string
+		method   string
+		allowed  bool
+	}{
+		{"viewer", "/api/v1/sites/:id", "GET", true},
+		{"viewer", "/api/v1/organizations/:id/members", "GET", true},
+		{"viewer", "/api/v1/sites", "POST", false},
+		{"viewer", "/api/v1/sites/:id", "PATCH", false},
+		{"viewer", "/api/v1/sites/:id/verify", "POST", false},
+		{"viewer", "/api/v1/organizations/:id", "PATCH", false},
+		{"viewer", "/api/v1/organizations/:id/invitations", "POST", false},
+		{"viewer", "/api/v1/activations/review/:id", "PATCH", false},
+		{"manager", "/api/v1/sites", "POST", true},
+		{"manager", "/api/v1/organizations/:id/invitations", "POST", true},
+		{"manager", "/api/v1/activations/review/:id", "PATCH", true},
+		{"admin", "/api/v1/sites/:id", "DELETE", true},
+	}
+
+	for _, tt := range tests {
+		allowed, err := e.Enforce(tt.role, "global", tt.endpoint, tt.method)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if allowed != tt.allowed {
+			t.Errorf("%s %s %s: got %v, want %v", tt.role, tt.method, tt.endpoint, allowed, tt.allowed)
+		}
+	}
+}
+
+// Site roles only grant the policies of every domain or their site, and inherit in every domain
+func TestSitePolicies(t *testing.T) {
+	e := newTestEnforcer(t)
+	site := "0b7c6f2e-3f0e-4a39-9a0e-7f5f3a8c2d11"
+
+	if _, err := e.AddPolicy("viewer", site, "/api/v1/csp/reports/:id/purge", "DELETE", "allow"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		role     string
+		domain   string
+		endpoint string
+		method   string
+		allowed  bool
+	}{
+		{"admin", site, "/api/v1/csp/reports/:id", "DELETE", true},
+		{"admin", site, "/api/v1/sites/:id", "PATCH", true},
+		{"admin", site, "/api/v1/csp/reports/all", "GET", true},
+		{"admin", site, "/api/v1/users/all", "GET", false},
+		{"admin", site, "/api/v1/users/:id/roles", "POST", false},
+		{"admin", site, "/api/v1/organizations/:id", "PATCH", false},
+		{"admin", site, "/api/v1/organizations/:id/invitations", "POST", false},
+		{"admin", site, "/api/v1/system/cache/purge", "POST", false},
+		{"superadmin", site, "/api/v1/organizations", "POST", false},
+		{"superadmin", site, "/api/v1/policies", "POST", false},
+		{"superadmin", site, "/api/v1/webhooks", "POST", false},
+		{"viewer", site, "/api/v1/csp/reports/:id/purge", "DELETE", true},
+		{"viewer", "global", "/api/v1/csp/reports/:id/purge", "DELETE", false},
+		{"viewer", "a7f1d7a0-1c2b-4d3e-8f4a-5b6c7d8e9f00", "/api/v1/csp/reports/:id/purge", "DELETE", false},
+	}
+
+	for _, tt := range tests {
+		allowed, err := e.Enforce(tt.role, tt.domain, tt.endpoint, tt.method)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if allowed != tt.allowed {
+			t.Errorf("%s on %s %s %s: got %v, want %v", tt.role, tt.domain, tt.method, tt.endpoint, allowed, tt.allowed)
+		}
+	}
+}
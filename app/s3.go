@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/getsentry/sentry-go"
+)
+
+var (
+	s3Client *s3.Client
+	onceS3   sync.Once
+)
+
+func S3() *s3.Client {
+	onceS3.Do(func() {
+		// Credentials are read from the AWS_* environment variables
+		cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(os.Getenv("AWS_REGION")))
+		if err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not load AWS configuration: %v", err))
+			os.Exit(1)
+		}
+
+		s3Client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+			// S3-compatible storage
+			if endpoint := os.Getenv("AWS_ENDPOINT"); len(endpoint) > 0 {
+				o.BaseEndpoint = aws.String(endpoint)
+				o.UsePathStyle = true
+			}
+		})
+	})
+
+	return s3Client
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Columns included in the reports search
+var reportSearchColumns = []string{
+	"blocked_uri",
+	"document_uri",
+	"source_file",
+	"script_sample",
+	"original_policy",
+}
+
+func setupReportSearch(database *gorm.DB) error {
+	// URLs are split on punctuation so their parts can be matched as words
+	if err := database.Exec(`ALTER TABLE reports ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (to_tsvector('simple', regexp_replace(
+			coalesce(blocked_uri, '') || ' ' ||
+			coalesce(document_uri, '') || ' ' ||
+			coalesce(source_file, '') || ' ' ||
+			coalesce(script_sample, '') || ' ' ||
+			coalesce(original_policy, ''),
+			'[^[:alnum:]]+', ' ', 'g'
+		))) STORED`).Error; err != nil {
+		return fmt.Errorf("Could not add search vector: %w", err)
+	}
+
+	if err := database.Exec("CREATE INDEX IF NOT EXISTS idx_reports_search_vector ON reports USING gin (search_vector)").Error; err != nil {
+		return fmt.Errorf("Could not create search vector index: %w", err)
+	}
+
+	for _, column := range reportSearchColumns {
+		//#nosec G201 -- Column names are not user input
+		if err := database.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_reports_%[1]s_trgm ON reports USING gin (%[1]s gin_trgm_ops)", column)).Error; err != nil {
+			return fmt.Errorf("Could not create trigram index on %s: %w", column, err)
+		}
+	}
+
+	return nil
+}
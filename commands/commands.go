@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type command struct {
+	Description string
+	Run         func(args []string) error
+}
+
+func availableCommands() map[string]command {
+	return map[string]command{
+		"groups:backfill": {
+			Description: "Group the reports received before violation groups existed",
+			Run:         groupsBackfill,
+		},
+		"policies:check": {
+			Description: "Check every named route is covered by a policy",
+			Run:         policiesCheck,
+		},
+		"rollups:backfill": {
+			Description: "Compute the report rollups for a date range",
+			Run:         rollupsBackfill,
+		},
+		"rollups:recompute": {
+			Description: "Compute the report rollups of a single bucket",
+			Run:         rollupsRecompute,
+		},
+	}
+}
+
+func Run(args []string) error {
+	commands := availableCommands()
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		names := []string{}
+
+		for name, c := range commands {
+			names = append(names, fmt.Sprintf("  %s\t%s", name, c.Description))
+		}
+
+		slices.Sort(names)
+
+		return fmt.Errorf("Unknown command '%s'. Available commands:\n%s", args[0], strings.Join(names, "\n"))
+	}
+
+	return cmd.Run(args[1:])
+}
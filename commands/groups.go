@@ -0,0 +1,20 @@
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+)
+
+func groupsBackfill(_ []string) error {
+	affected, err := helpers.BackfillViolationGroups(app.DB())
+	if err != nil {
+		return fmt.Errorf("Could not backfill violation groups: %w", err)
+	}
+
+	slog.Info(fmt.Sprintf("Assigned %d reports to violation groups", affected))
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/routes"
+	"github.com/gofiber/fiber/v2"
+)
+
+func policiesCheck(_ []string) error {
+	app := fiber.New(fiber.Config{StrictRouting: true})
+	routes.SetupRoutes(app)
+
+	check, err := helpers.CheckRoutePolicies(app.GetRoutes(true))
+	if err != nil {
+		return fmt.Errorf("Could not check policies: %w", err)
+	}
+
+	check.Log()
+
+	if !check.OK() {
+		return fmt.Errorf("Found %d routes without policy and %d policies without route", len(check.UncoveredRoutes), len(check.UnusedPolicies))
+	}
+
+	slog.Info("Every route is covered by a policy")
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/utils"
+)
+
+func parseGranularities(g string) ([]string, error) {
+	if g == "all" {
+		return helpers.RollupGranularities(), nil
+	}
+
+	if !slices.Contains(helpers.RollupGranularities(), g) {
+		return nil, fmt.Errorf("Invalid granularity '%s'.", g)
+	}
+
+	return []string{g}, nil
+}
+
+func rollupsBackfill(args []string) error {
+	now := time.Now().In(utils.DefaultLocation())
+
+	fs := flag.NewFlagSet("rollups:backfill", flag.ContinueOnError)
+	fromStr := fs.String("from", "", "First day to compute (YYYY-MM-DD)")
+	toStr := fs.String("to", now.Format(time.DateOnly), "Last day to compute (YYYY-MM-DD)")
+	granularity := fs.String("granularity", "all", "Rollup granularity: hour, day or all")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if len(*fromStr) < 1 {
+		return errors.New("The start date is required.")
+	}
+
+	from, err := time.ParseInLocation(time.DateOnly, *fromStr, utils.DefaultLocation())
+	if err != nil {
+		return fmt.Errorf("Invalid start date: %w", err)
+	}
+
+	to, err := time.ParseInLocation(time.DateOnly, *toStr, utils.DefaultLocation())
+	if err != nil {
+		return fmt.Errorf("Invalid end date: %w", err)
+	}
+
+	if to.Before(from) {
+		return errors.New("The end date must be after the start date.")
+	}
+
+	granularities, err := parseGranularities(*granularity)
+	if err != nil {
+		return err
+	}
+
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		_, dayEnd := helpers.RollupBucket(helpers.RollupDaily, day)
+
+		for _, g := range granularities {
+			if g == helpers.RollupDaily {
+				if err := helpers.RecomputeRollup(g, day); err != nil {
+					return fmt.Errorf("Could not compute daily rollup for %s: %w", day.Format(time.DateOnly), err)
+				}
+
+				continue
+			}
+
+			for hour := day; hour.Before(dayEnd); hour = hour.Add(time.Hour) {
+				if err := helpers.RecomputeRollup(g, hour); err != nil {
+					return fmt.Errorf("Could not compute hourly rollup for %s: %w", hour.Format(time.RFC3339), err)
+				}
+			}
+		}
+
+		slog.Info(fmt.Sprintf("Computed rollups for %s", day.Format(time.DateOnly)))
+	}
+
+	return nil
+}
+
+func rollupsRecompute(args []string) error {
+	fs := flag.NewFlagSet("rollups:recompute", flag.ContinueOnError)
+	bucketStr := fs.String("bucket", "", "Any date and time within the bucket (RFC 3339)")
+	granularity := fs.String("granularity", "all", "Rollup granularity: hour, day or all")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	bucket, err := time.Parse(time.RFC3339, *bucketStr)
+	if err != nil {
+		return fmt.Errorf("Invalid bucket: %w", err)
+	}
+
+	granularities, err := parseGranularities(*granularity)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range granularities {
+		if err := helpers.RecomputeRollup(g, bucket); err != nil {
+			return fmt.Errorf("Could not compute %s rollup: %w", g, err)
+		}
+
+		start, _ := helpers.RollupBucket(g, bucket)
+		slog.Info(fmt.Sprintf("Computed %s rollup for %s", g, start.Format(time.RFC3339)))
+	}
+
+	return nil
+}
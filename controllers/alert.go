@@ -0,0 +1,334 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type alertRuleInput struct {
+	SiteID             *string `json:"site_id"`
+	Name               *string `json:"name"`
+	Kind               *string `json:"kind"`
+	EffectiveDirective *string `json:"effective_directive"`
+	Threshold          *int64  `json:"threshold"`
+	WindowMinutes      *int    `json:"window_minutes"`
+	MinCount           *int64  `json:"min_count"`
+	CooldownMinutes    *int    `json:"cooldown_minutes"`
+	Enabled            *bool   `json:"enabled"`
+}
+
+// Applies the input to the rule, returning the validation errors of the result
+func (input *alertRuleInput) apply(rule *models.AlertRule, scope *helpers.SiteScope) fiber.Map {
+	errs := fiber.Map{}
+
+	if input.SiteID != nil {
+		id, err := uuid.Parse(strings.TrimSpace(*input.SiteID))
+		if err != nil || !utils.IsValidUuid(id) {
+			errs = utils.AddError(errs, "site_id", "The site is invalid.")
+		} else if !scope.Allows(id) {
+			errs = utils.AddError(errs, "site_id", "The site does not exist.")
+		} else if err := app.DB().Where(&models.Site{ID: id}).First(&models.Site{}).Error; err != nil {
+			errs = utils.AddError(errs, "site_id", "The site does not exist.")
+		} else {
+			rule.SiteID = id
+		}
+	}
+
+	if input.Name != nil {
+		rule.Name = strings.TrimSpace(*input.Name)
+	}
+
+	if input.Kind != nil {
+		rule.Kind = strings.ToLower(strings.TrimSpace(*input.Kind))
+	}
+
+	if input.EffectiveDirective != nil {
+		rule.EffectiveDirective = nil
+
+		if directive := strings.ToLower(strings.TrimSpace(*input.EffectiveDirective)); len(directive) > 0 {
+			rule.EffectiveDirective = &directive
+		}
+	}
+
+	if input.Threshold != nil {
+		rule.Threshold = *input.Threshold
+	}
+
+	if input.WindowMinutes != nil {
+		rule.WindowMinutes = *input.WindowMinutes
+	}
+
+	if input.MinCount != nil {
+		rule.MinCount = *input.MinCount
+	}
+
+	if input.CooldownMinutes != nil {
+		rule.CooldownMinutes = *input.CooldownMinutes
+	}
+
+	if input.Enabled != nil {
+		rule.Enabled = input.Enabled
+	}
+
+	if rule.SiteID == uuid.Nil && len(errs) < 1 {
+		errs = utils.AddError(errs, "site_id", "The site is required.")
+	}
+
+	if n := utf8.RuneCountInString(rule.Name); n < 1 || n > 255 {
+		errs = utils.AddError(errs, "name", "The name must be between 1 and 255 characters long.")
+	}
+
+	if !slices.Contains(helpers.AlertKinds(), rule.Kind) {
+		errs = utils.AddError(errs, "kind", "The kind must be one of: "+strings.Join(helpers.AlertKinds(), ", ")+".")
+	}
+
+	if rule.EffectiveDirective != nil && len(*rule.EffectiveDirective) > 100 {
+		errs = utils.AddError(errs, "effective_directive", "The effective directive must be at most 100 characters long.")
+	}
+
+	if rule.Kind != helpers.AlertNewHost && rule.Threshold < 1 {
+		errs = utils.AddError(errs, "threshold", "The threshold must be greater than zero.")
+	}
+
+	if rule.WindowMinutes < 1 || rule.WindowMinutes > helpers.MaxAlertWindowMinutes() {
+		errs = utils.AddError(errs, "window_minutes", fmt.Sprintf("The window must be between 1 and %d minutes.", helpers.MaxAlertWindowMinutes()))
+	}
+
+	if rule.MinCount < 0 {
+		errs = utils.AddError(errs, "min_count", "The minimum count cannot be negative.")
+	}
+
+	if rule.CooldownMinutes < 0 || rule.CooldownMinutes > helpers.MaxAlertWindowMinutes() {
+		errs = utils.AddError(errs, "cooldown_minutes", fmt.Sprintf("The cooldown must be between 0 and %d minutes.", helpers.MaxAlertWindowMinutes()))
+	}
+
+	return errs
+}
+
+func getAlertRule(c *fiber.Ctx) (*models.AlertRule, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested alert rule is invalid."},
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return nil, err
+	}
+
+	rule := &models.AlertRule{}
+	if err := scope.Apply(app.DB().Where(&models.AlertRule{ID: id}), "site_id").Preload("Site").First(&rule).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested alert rule does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting alert rule: %v", err))
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get alert rule."},
+		})
+	}
+
+	return rule, nil
+}
+
+func GetAllAlertRules(c *fiber.Ctx) error {
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	rules := []models.AlertRule{}
+	query := scope.Apply(app.DB().Model(&models.AlertRule{}).Preload("Site"), "site_id")
+
+	if siteID := c.Query("site_id"); len(siteID) > 0 {
+		id, err := uuid.Parse(siteID)
+		if err != nil || !utils.IsValidUuid(id) {
+			return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+				"error": []string{"The site is invalid."},
+			})
+		}
+
+		query = query.Where(&models.AlertRule{SiteID: id})
+	}
+
+	opts := helpers.PaginatedItemOpts{
+		RouteName:   "api.csp.alerts.rules.index",
+		Filters:     c.Query("site_id"),
+		SortColumns: []string{"name", "kind"},
+	}
+
+	return helpers.PaginateQuery(rules, query, c, opts)
+}
+
+func GetAlertRule(c *fiber.Ctx) error {
+	rule, err := getAlertRule(c)
+	if rule == nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": rule})
+}
+
+func PostAlertRule(c *fiber.Ctx) error {
+	input := &alertRuleInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid alert rule data."},
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	enabled := true
+	rule := &models.AlertRule{
+		WindowMinutes:   60,
+		CooldownMinutes: 60,
+		Enabled:         &enabled,
+		CreatedByID:     helpers.GetUserID(c),
+	}
+
+	if errs := input.apply(rule, scope); len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	if err := app.DB().Omit(clause.Associations).Create(&rule).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error creating alert rule: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create alert rule."},
+		})
+	}
+
+	if err := app.DB().Preload("Site").First(&rule).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting alert rule: %v", err))
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(&fiber.Map{
+		"message": "The alert rule has been created.",
+		"data":    rule,
+	})
+}
+
+func PatchAlertRule(c *fiber.Ctx) error {
+	rule, err := getAlertRule(c)
+	if rule == nil {
+		return err
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	input := &alertRuleInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid alert rule data."},
+		})
+	}
+
+	if errs := input.apply(rule, scope); len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	if err := app.DB().Omit(clause.Associations).Save(&rule).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error updating alert rule: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not update alert rule."},
+		})
+	}
+
+	if err := app.DB().Preload("Site").First(&rule).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting alert rule: %v", err))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The alert rule has been updated.",
+		"data":    rule,
+	})
+}
+
+func DeleteAlertRule(c *fiber.Ctx) error {
+	rule, err := getAlertRule(c)
+	if rule == nil {
+		return err
+	}
+
+	if err := app.DB().Delete(&rule).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error deleting alert rule: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not delete alert rule."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The alert rule has been deleted.",
+	})
+}
+
+func GetAllAlertEvents(c *fiber.Ctx) error {
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	events := []models.AlertEvent{}
+	query := scope.Apply(app.DB().Model(&models.AlertEvent{}), "site_id")
+	filters := []string{}
+
+	for _, param := range []string{"rule_id", "site_id"} {
+		value := c.Query(param)
+		if len(value) < 1 {
+			continue
+		}
+
+		id, err := uuid.Parse(value)
+		if err != nil || !utils.IsValidUuid(id) {
+			return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+				"error": []string{fmt.Sprintf("The %s filter is invalid.", param)},
+			})
+		}
+
+		query = query.Where(param+" = ?", id)
+		filters = append(filters, param+"="+id.String())
+	}
+
+	opts := helpers.PaginatedItemOpts{
+		RouteName: "api.csp.alerts.events.index",
+		Filters:   strings.Join(filters, "&"),
+	}
+
+	return helpers.PaginateQuery(events, query, c, opts)
+}
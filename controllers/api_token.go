@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type apiTokenInput struct {
+	Name      string     `json:"name"`
+	Roles     []string   `json:"roles"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// API tokens cannot create nor revoke tokens, only interactive sessions can
+func isAPITokenRequest(c *fiber.Ctx) (bool, error) {
+	if helpers.GetRequestAPIToken(c) == nil {
+		return false, nil
+	}
+
+	return true, c.Status(fiber.StatusForbidden).JSON(&fiber.Map{
+		"error": []string{"API tokens cannot be managed with an API token."},
+	})
+}
+
+func GetAllAPITokens(c *fiber.Ctx) error {
+	tokens := []models.APIToken{}
+	query := app.DB().Model(&models.APIToken{}).Where(&models.APIToken{UserID: helpers.GetUserID(c)})
+	filters := []string{}
+
+	if active := c.Query("active"); len(active) > 0 {
+		if c.QueryBool("active") {
+			query = query.Where("api_tokens.revoked_at IS NULL AND api_tokens.expires_at > ?", time.Now())
+		} else {
+			query = query.Where("api_tokens.revoked_at IS NOT NULL OR api_tokens.expires_at <= ?", time.Now())
+		}
+
+		filters = append(filters, "active="+active)
+	}
+
+	opts := helpers.PaginatedItemOpts{
+		RouteName:   "api.tokens.index",
+		TableAlias:  "api_tokens",
+		SortColumns: []string{"name", "expires_at"},
+		Filters:     strings.Join(filters, "&"),
+	}
+
+	return helpers.PaginateQuery(tokens, query, c, opts)
+}
+
+func PostAPIToken(c *fiber.Ctx) error {
+	if ok, err := isAPITokenRequest(c); ok {
+		return err
+	}
+
+	input := &apiTokenInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid API token data."},
+		})
+	}
+
+	userID := helpers.GetUserID(c)
+	input.Name = strings.TrimSpace(input.Name)
+	errs := fiber.Map{}
+
+	if len(input.Name) < 1 || len(input.Name) > 100 {
+		errs = utils.AddError(errs, "name", "The name must be between 1 and 100 characters long.")
+	}
+
+	userRoles, err := helpers.GetUserRoles(userID)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("User roles error: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create API token."},
+		})
+	}
+
+	// Tokens can be limited to inherited roles too, such as viewer for an admin
+	available, err := helpers.ExpandRoles(userRoles.Names())
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("User roles error: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create API token."},
+		})
+	}
+
+	roles := []string{}
+
+	for _, r := range input.Roles {
+		r = strings.ToLower(strings.TrimSpace(r))
+
+		if !slices.Contains(available, r) {
+			errs = utils.AddError(errs, "roles", fmt.Sprintf("You do not have the %s role.", r))
+			continue
+		}
+
+		if !slices.Contains(roles, r) {
+			roles = append(roles, r)
+		}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(helpers.APITokenDefaultExpiration)
+
+	if input.ExpiresAt != nil {
+		expiresAt = *input.ExpiresAt
+	}
+
+	if !expiresAt.After(now) || expiresAt.After(now.Add(helpers.APITokenMaxExpiration)) {
+		errs = utils.AddError(errs, "expires_at", fmt.Sprintf("The expiration date must be within the next %d days.", int(helpers.APITokenMaxExpiration.Hours()/24)))
+	}
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	token, plain, err := helpers.NewAPIToken(userID, input.Name, roles, expiresAt)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error creating API token: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create API token."},
+		})
+	}
+
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		Action:     helpers.AuditAPITokenCreated,
+		TargetType: "api_token",
+		TargetID:   token.ID.String(),
+		After:      token,
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(&fiber.Map{
+		"message": "The API token has been created, copy it now as it will not be shown again.",
+		"data":    token,
+		"token":   plain,
+	})
+}
+
+func DeleteAPIToken(c *fiber.Ctx) error {
+	if ok, err := isAPITokenRequest(c); ok {
+		return err
+	}
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested API token is invalid."},
+		})
+	}
+
+	token := &models.APIToken{}
+	if err := app.DB().Where(&models.APIToken{ID: id, UserID: helpers.GetUserID(c)}).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested API token does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting API token: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not revoke API token."},
+		})
+	}
+
+	if token.RevokedAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(&fiber.Map{
+			"error": []string{"The API token has already been revoked."},
+		})
+	}
+
+	now := time.Now()
+	if err := app.DB().Model(&token).Updates(&models.APIToken{RevokedAt: &now}).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error revoking API token: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not revoke API token."},
+		})
+	}
+
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		Action:     helpers.AuditAPITokenRevoked,
+		TargetType: "api_token",
+		TargetID:   token.ID.String(),
+		After:      fiber.Map{"revoked_at": now},
+	})
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The API token has been revoked.",
+	})
+}
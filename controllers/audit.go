@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func GetAllAuditEvents(c *fiber.Ctx) error {
+	events := []models.AuditEvent{}
+	query := app.DB().Model(&models.AuditEvent{})
+	filters := []string{}
+
+	if value := c.Query("actor_id"); len(value) > 0 {
+		id, err := uuid.Parse(value)
+		if err != nil || !utils.IsValidUuid(id) {
+			return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+				"error": []string{"The actor_id filter is invalid."},
+			})
+		}
+
+		query = query.Where("audit_events.actor_id = ?", id)
+		filters = append(filters, "actor_id="+id.String())
+	}
+
+	for _, param := range []string{"action", "target_type", "target_id", "request_id"} {
+		value := strings.TrimSpace(c.Query(param))
+		if len(value) < 1 {
+			continue
+		}
+
+		//#nosec G202 -- Column names are not user input
+		query = query.Where("audit_events."+param+" = ?", value)
+		filters = append(filters, param+"="+value)
+	}
+
+	query = query.Preload("Actor")
+	opts := helpers.PaginatedItemOpts{
+		RouteName:  "api.audit.events.index",
+		TableAlias: "audit_events",
+		Filters:    strings.Join(filters, "&"),
+	}
+
+	return helpers.PaginateQuery(events, query, c, opts)
+}
+
+func GetAuditEvent(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested audit event is invalid."},
+		})
+	}
+
+	event := &models.AuditEvent{}
+	if err := app.DB().Where(&models.AuditEvent{ID: id}).Preload("Actor").First(&event).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested audit event does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting audit event: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get audit event."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": event})
+}
+
+func VerifyAuditEvents(c *fiber.Ctx) error {
+	result, err := helpers.VerifyAuditChain(app.DB())
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error verifying audit events: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not verify audit events."},
+		})
+	}
+
+	if !result.Valid {
+		slog.Error(fmt.Sprintf("Audit chain broken at event %s: %s", result.EventID, result.Reason))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": result})
+}
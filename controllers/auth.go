@@ -34,6 +34,8 @@ type userRegisterInput struct {
 	Email           string  `json:"email"`
 	Password        string  `json:"password"`
 	ConfirmPassword string  `json:"confirm_password"`
+	// Token of an organization invitation sent to the email
+	Invitation string `json:"invitation,omitempty"`
 }
 
 type userRecoveryInput struct {
@@ -58,7 +60,11 @@ func AuthLogin(c *fiber.Ctx) error {
 		errs = utils.AddError(errs, "email", "Please, enter a valid email address.")
 	}
 
-	if len(input.Password) < utils.MinimumPasswordLength() {
+	// Users of the directory domains never fall back to their local password,
+	// their password follows the rules of the directory instead
+	ldapUser := helpers.IsLDAPUser(input.Email)
+
+	if !ldapUser && len(input.Password) < utils.MinimumPasswordLength() {
 		errs = utils.AddError(errs, "password", fmt.Sprintf("The password must be at least %d characters long.", utils.MinimumPasswordLength()))
 	}
 
@@ -68,14 +74,36 @@ func AuthLogin(c *fiber.Ctx) error {
 		})
 	}
 
+	if ldapUser {
+		return ldapLogin(c, input)
+	}
+
 	active := true
 	user := &models.User{Email: input.Email, Active: &active}
 	if err := app.DB().Where(&user).First(&user).Error; err != nil || !utils.ComparePasswordHash(input.Password, user.Password) {
+		helpers.RecordAudit(c, helpers.AuditEntry{
+			Action:     helpers.AuditLoginFailed,
+			TargetType: "user",
+			TargetID:   input.Email,
+		})
+
 		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
 			"error": []string{"The user credentials are invalid."},
 		})
 	}
 
+	// Set by an administrator, the password must be changed through account recovery
+	if user.MustChangePassword != nil && *user.MustChangePassword {
+		return c.Status(fiber.StatusForbidden).JSON(&fiber.Map{
+			"error": []string{"You must change your password, please check your email or recover your account."},
+		})
+	}
+
+	return startSession(c, user, "password")
+}
+
+// Issues the access and refresh tokens of the user, the way they authenticated is recorded
+func startSession(c *fiber.Ctx, user *models.User, method string) error {
 	accessToken, err := helpers.NewAccessToken(user)
 	if err != nil {
 		sentry.CaptureException(err)
@@ -116,6 +144,14 @@ func AuthLogin(c *fiber.Ctx) error {
 		SessionOnly: true,
 	})
 
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		ActorID:    &user.ID,
+		Action:     helpers.AuditLogin,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		After:      fiber.Map{"method": method},
+	})
+
 	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"access_token": accessToken})
 }
 
@@ -205,10 +241,6 @@ func AuthRefresh(c *fiber.Ctx) error {
 }
 
 func AuthRegister(c *fiber.Ctx) error {
-	if !utils.CanRegisterUsers() {
-		return c.Status(fiber.StatusUnauthorized).JSON(&fiber.Map{"error": []string{"User registration is disabled."}})
-	}
-
 	input := &userRegisterInput{}
 	if err := c.BodyParser(&input); err != nil {
 		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
@@ -218,7 +250,28 @@ func AuthRegister(c *fiber.Ctx) error {
 		})
 	}
 
+	// Invited users can register even when registration is disabled
+	if !utils.CanRegisterUsers() && len(input.Invitation) < 1 {
+		return c.Status(fiber.StatusUnauthorized).JSON(&fiber.Map{"error": []string{"User registration is disabled."}})
+	}
+
 	errs := fiber.Map{}
+	var invitation *models.OrganizationInvitation
+
+	if len(input.Invitation) > 0 {
+		inv, err := helpers.GetOrganizationInvitation(app.DB(), input.Invitation, input.Email)
+		if err != nil {
+			if !errors.Is(err, helpers.ErrInvitationInvalid) && !errors.Is(err, helpers.ErrInvitationEmail) {
+				sentry.CaptureException(err)
+				slog.Error(fmt.Sprintf("Error getting organization invitation: %v", err))
+				err = helpers.ErrInvitationInvalid
+			}
+
+			errs = utils.AddError(errs, "invitation", err.Error())
+		}
+
+		invitation = inv
+	}
 
 	if !utils.IsValidEmail(input.Email) {
 		errs = utils.AddError(errs, "email", "Please, enter a valid email address.")
@@ -282,6 +335,12 @@ func AuthRegister(c *fiber.Ctx) error {
 		}
 
 		userActivation := &models.UserActivation{UserID: user.ID}
+
+		// The administrators of the organization review the registration
+		if invitation != nil {
+			userActivation.InvitationID = &invitation.ID
+		}
+
 		if err := tx.Where(&userActivation).FirstOrCreate(&userActivation).Error; err != nil {
 			return err
 		}
@@ -295,6 +354,10 @@ func AuthRegister(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := tasks.DispatchWebhookEvent(helpers.WebhookUserRegistered, user); err != nil {
+		slog.Error(fmt.Sprintf("Error dispatching webhook event: %v", err))
+	}
+
 	userName := user.GetFullName()
 
 	time.AfterFunc(3*time.Second, func() {
@@ -376,6 +439,13 @@ func AuthLogout(c *fiber.Ctx) error {
 		})
 	}
 
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		ActorID:    &claims.User.ID,
+		Action:     helpers.AuditLogout,
+		TargetType: "access_token",
+		TargetID:   claims.ID,
+	})
+
 	return c.Status(fiber.StatusNoContent).JSON(&fiber.Map{})
 }
 
@@ -448,18 +518,9 @@ func AuthRecover(c *fiber.Ctx) error {
 			return err
 		}
 
-		randomString, err := utils.RandomString(35)
-		if err != nil || len(randomString) < 1 {
-			slog.Error(fmt.Sprintf("Error generating random string: %v", err))
-			return err
-		}
-
-		recovery := &models.AccountRecovery{
-			Hash:      randomString,
-			UserID:    user.ID,
-			ExpiresAt: now.Add(6 * time.Hour),
-		}
-		if err := tx.Create(&recovery).Error; err != nil {
+		recovery, err := helpers.NewAccountRecovery(tx, user.ID)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Error creating account recovery: %v", err))
 			return err
 		}
 
@@ -597,6 +658,18 @@ func AuthRecoverUpdate(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{"error": []string{"Could not update user password."}})
 	}
 
+	if err := helpers.InvalidateUserCache(recovery.UserID); err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Could not invalidate user cache: %v", err))
+	}
+
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		ActorID:    &recovery.UserID,
+		Action:     helpers.AuditPasswordRecovered,
+		TargetType: "user",
+		TargetID:   recovery.UserID.String(),
+	})
+
 	if err := tasks.NewEmail(
 		helpers.EmailOpts{
 			Subject:      "Password change confirmation",
@@ -1,7 +1,7 @@
 package controllers
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 	"slices"
@@ -15,6 +15,7 @@ import (
 	"alfredoramos.mx/csp-reporter/utils"
 	"github.com/getsentry/sentry-go"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -38,13 +39,62 @@ type cspReportInput struct {
 }
 
 func GetAllCSPReports(c *fiber.Ctx) error {
+	filters, errs := helpers.ParseReportFilters(c)
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	filters.SiteIDs = scope.Restrict(filters.SiteIDs)
 	reports := []models.Report{}
-	query := app.DB().Model(&models.Report{}).Preload("Site")
-	opts := helpers.PaginatedItemOpts{RouteName: "api.csp.reports.index"}
+	query := filters.Apply(app.DB().Model(&models.Report{}).Preload("Site"), "")
+	opts := filters.PaginatedItemOpts("api.csp.reports.index", "")
+	opts.SortColumns = []string{
+		"blocked_uri",
+		"document_uri",
+		"effective_directive",
+		"violated_directive",
+		"disposition",
+		"status_code",
+	}
 
 	return helpers.PaginateQuery(reports, query, c, opts)
 }
 
+func GetCSPReportStats(c *fiber.Ctx) error {
+	query, errs := helpers.ParseStatsQuery(c)
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	query.SiteIDs = scope.Restrict(query.SiteIDs)
+	stats, err := helpers.GetReportStats(query)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting CSP report statistics: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Could not get statistics."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": stats})
+}
+
 func PostCSPReport(c *fiber.Ctx) error {
 	allowedMimeTypes := []string{"application/csp-report", "application/json"}
 	accept := c.Accepts(allowedMimeTypes...)
@@ -89,12 +139,12 @@ func PostCSPReport(c *fiber.Ctx) error {
 	slog.Warn(fmt.Sprintf("CSP violation report: %#v", input))
 
 	now := time.Now().In(utils.DefaultLocation())
+	var created *models.Report
+	newGroupID := uuid.Nil
 
 	if err := app.DB().Transaction(func(tx *gorm.DB) error {
-		site := &models.Site{}
-		if err := tx.Model(&models.Site{}).
-			Where("unaccent(lower(domain)) = unaccent(lower(@domain))", sql.Named("domain", domain)).
-			First(&site).Error; err != nil {
+		site, err := helpers.GetSiteByDomain(tx, domain)
+		if err != nil {
 			slog.Error(fmt.Sprintf("Error getting site: %v", err))
 			return err
 		}
@@ -102,8 +152,10 @@ func PostCSPReport(c *fiber.Ctx) error {
 		report := &models.Report{
 			SiteID:             site.ID,
 			BlockedURI:         input.Report.BlockedURI,
+			BlockedHost:        utils.GetURIHost(input.Report.BlockedURI),
 			Disposition:        input.Report.Disposition,
 			DocumentURI:        input.Report.DocumentURI,
+			DocumentPath:       utils.GetURIPath(input.Report.DocumentURI),
 			EffectiveDirective: input.Report.EffectiveDirective,
 			OriginalPolicy:     input.Report.OriginalPolicy,
 			Referrer:           input.Report.Referrer,
@@ -113,46 +165,393 @@ func PostCSPReport(c *fiber.Ctx) error {
 			SourceFile:         input.Report.SourceFile,
 			LineNumber:         input.Report.LineNumber,
 			ColumnNumber:       input.Report.ColumnNumber,
+			UserAgent:          utils.ToStringPtr(c.Get(fiber.HeaderUserAgent)),
+			Browser:            utils.GetBrowserName(c.Get(fiber.HeaderUserAgent)),
+		}
+
+		if helpers.IsIgnoredReport(site, report) {
+			slog.Info(fmt.Sprintf("CSP report ignored by the settings of site %s", site.ID))
+			return nil
 		}
-		if err := tx.Where(&report).Preload("Site").FirstOrCreate(&report).Error; err != nil {
+
+		if site.VerifiedAt == nil {
+			if utils.SiteUnverifiedPolicy() != utils.SiteUnverifiedQuarantine {
+				return helpers.ErrSiteUnverified
+			}
+
+			report.Quarantined = true
+		}
+
+		result := tx.Where(&report).Preload("Site").FirstOrCreate(&report)
+		if err := result.Error; err != nil {
 			slog.Error(fmt.Sprintf("Error saving CSP Report: %v", err))
 			return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{"error": []string{"Could not regisger CSP report."}})
 		}
 
-		if err := tasks.NewEmail(
-			helpers.EmailOpts{
+		// Quarantined reports are grouped and notified once the site is verified
+		if result.RowsAffected > 0 && !report.Quarantined {
+			groupID, inserted, err := helpers.UpsertViolationGroup(tx, report)
+			if err != nil {
+				sentry.CaptureException(err)
+				slog.Error(fmt.Sprintf("Error saving violation group: %v", err))
+				return err
+			}
+
+			if err := tx.Model(&report).Update("group_id", groupID).Error; err != nil {
+				sentry.CaptureException(err)
+				slog.Error(fmt.Sprintf("Error assigning violation group: %v", err))
+				return err
+			}
+
+			report.Site = *site
+			created = report
+
+			if inserted {
+				newGroupID = groupID
+			}
+		}
+
+		return nil
+	}); err != nil {
+		if errors.Is(err, helpers.ErrSiteUnverified) {
+			slog.Warn(fmt.Sprintf("CSP report rejected, the site of '%s' has not been verified.", domain))
+			return c.Status(fiber.StatusForbidden).JSON(&fiber.Map{"error": []string{err.Error()}})
+		}
+
+		slog.Error(fmt.Sprintf("Error saving CSP Report: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{"error": []string{"Could not regisger CSP report."}})
+	}
+
+	if created != nil {
+		if err := tasks.Notify(tasks.Notification{
+			Content: helpers.NotificationContent{
+				Event:  helpers.WebhookReportCreated,
+				SiteID: created.SiteID,
+				Title:  "New CSP violation report",
+				Body:   fmt.Sprintf("A '%s' violation blocking '%s' has been reported on %s.", created.EffectiveDirective, created.BlockedURI, created.Site.Domain),
+				URL:    helpers.AppURL("/reports/" + created.ID.String()),
+			},
+			Email: helpers.EmailOpts{
 				Subject:      "Content Security Policy violation report",
 				TemplateName: "csp_report",
-				IsInternal:   true,
-				ToList:       []string{utils.InternalStaffEmail()},
 			},
-			map[string]interface{}{
-				"SiteTitle":          report.Site.Title,
-				"SiteDomain":         report.Site.Domain,
+			EmailData: map[string]interface{}{
+				"SiteTitle":          created.Site.Title,
+				"SiteDomain":         created.Site.Domain,
 				"ReportDateTime":     now.Format("2006-01-02 15:04:05 -07:00"),
-				"BlockedURI":         report.BlockedURI,
-				"Disposition":        report.Disposition,
-				"DocumentURI":        report.DocumentURI,
-				"EffectiveDirective": report.EffectiveDirective,
-				"OriginalPolicy":     report.OriginalPolicy,
-				"Referrer":           report.Referrer,
-				"StatusCode":         report.StatusCode,
-				"ViolatedDirective":  report.ViolatedDirective,
-				"ScriptSample":       report.ScriptSample,
-				"SourceFile":         report.SourceFile,
-				"LineNumber":         report.LineNumber,
-				"ColumnNumber":       report.ColumnNumber,
+				"BlockedURI":         created.BlockedURI,
+				"Disposition":        created.Disposition,
+				"DocumentURI":        created.DocumentURI,
+				"EffectiveDirective": created.EffectiveDirective,
+				"OriginalPolicy":     created.OriginalPolicy,
+				"Referrer":           created.Referrer,
+				"StatusCode":         created.StatusCode,
+				"ViolatedDirective":  created.ViolatedDirective,
+				"ScriptSample":       created.ScriptSample,
+				"SourceFile":         created.SourceFile,
+				"LineNumber":         created.LineNumber,
+				"ColumnNumber":       created.ColumnNumber,
 			},
-		); err != nil {
-			sentry.CaptureException(err)
-			slog.Error(fmt.Sprintf("Error sending email: %v", err))
+			WebhookData: created,
+			Fallback:    true,
+		}); err != nil {
+			slog.Error(fmt.Sprintf("Error sending notifications: %v", err))
 		}
 
-		return nil
-	}); err != nil {
-		slog.Error(fmt.Sprintf("Error saving CSP Report: %v", err))
-		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{"error": []string{"Could not regisger CSP report."}})
+		if err := tasks.DispatchWebhookEvent(helpers.WebhookReportCreated, created); err != nil {
+			slog.Error(fmt.Sprintf("Error dispatching webhook event: %v", err))
+		}
+	}
+
+	if newGroupID != uuid.Nil {
+		group := &models.ViolationGroup{}
+		if err := app.DB().Where(&models.ViolationGroup{ID: newGroupID}).Preload("Site").First(&group).Error; err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Error getting violation group: %v", err))
+		} else {
+			if err := tasks.Notify(tasks.Notification{
+				Content: helpers.NotificationContent{
+					Event:  helpers.WebhookGroupNew,
+					SiteID: group.SiteID,
+					Title:  "New violation group",
+					Body:   fmt.Sprintf("The first '%s' violation from '%s' has been reported on %s.", group.EffectiveDirective, group.BlockedHost, group.Site.Domain),
+					URL:    helpers.AppURL("/groups/" + group.ID.String()),
+				},
+				Email: helpers.EmailOpts{
+					Subject:      "New violation group",
+					TemplateName: "notification",
+				},
+				WebhookData: group,
+			}); err != nil {
+				slog.Error(fmt.Sprintf("Error sending notifications: %v", err))
+			}
+
+			if err := tasks.DispatchWebhookEvent(helpers.WebhookGroupNew, group); err != nil {
+				slog.Error(fmt.Sprintf("Error dispatching webhook event: %v", err))
+			}
+		}
 	}
 
 	return c.Status(fiber.StatusNoContent).JSON(&fiber.Map{})
 }
+
+func getReportID(c *fiber.Ctx) (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if !utils.IsValidUuid(id) {
+		return uuid.Nil, errors.New("Invalid report ID.")
+	}
+
+	return id, nil
+}
+
+func recomputeReportRollups(buckets ...time.Time) {
+	for _, bucket := range buckets {
+		if err := tasks.NewRollupRecompute(bucket); err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Error queueing rollup recompute: %v", err))
+		}
+	}
+}
+
+func GetCSPReport(c *fiber.Ctx) error {
+	id, err := getReportID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested report is invalid."},
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	report := &models.Report{}
+	if err := scope.Apply(app.DB().Unscoped().Where(&models.Report{ID: id}), "site_id").Preload("Site").First(&report).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested report does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting CSP report: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get report."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": report})
+}
+
+func DeleteCSPReport(c *fiber.Ctx) error {
+	id, err := getReportID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested report is invalid."},
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	report := &models.Report{}
+	if err := scope.Apply(app.DB().Where(&models.Report{ID: id}), "site_id").First(&report).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+			"error": []string{"The requested report does not exist or has already been deleted."},
+		})
+	}
+
+	userID := helpers.GetUserID(c)
+
+	if err := app.DB().Model(&report).Updates(map[string]interface{}{
+		"deleted_at":    time.Now().In(utils.DefaultLocation()),
+		"deleted_by_id": userID,
+	}).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error deleting CSP report: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not delete report."},
+		})
+	}
+
+	slog.Info(fmt.Sprintf("CSP report %s deleted by %s", report.ID, userID))
+	recomputeReportRollups(report.CreatedAt)
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The report has been deleted.",
+	})
+}
+
+func RestoreCSPReport(c *fiber.Ctx) error {
+	id, err := getReportID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested report is invalid."},
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	report := &models.Report{}
+	if err := scope.Apply(app.DB().Unscoped().Where(&models.Report{ID: id}), "site_id").Where("deleted_at IS NOT NULL").First(&report).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+			"error": []string{"The requested report does not exist or is not deleted."},
+		})
+	}
+
+	userID := helpers.GetUserID(c)
+
+	if err := app.DB().Unscoped().Model(&report).Updates(map[string]interface{}{
+		"deleted_at":     nil,
+		"restored_by_id": userID,
+	}).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error restoring CSP report: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not restore report."},
+		})
+	}
+
+	slog.Info(fmt.Sprintf("CSP report %s restored by %s", report.ID, userID))
+	recomputeReportRollups(report.CreatedAt)
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The report has been restored.",
+	})
+}
+
+func PurgeCSPReport(c *fiber.Ctx) error {
+	id, err := getReportID(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested report is invalid."},
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	report := &models.Report{}
+	if err := scope.Apply(app.DB().Unscoped().Where(&models.Report{ID: id}), "site_id").First(&report).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+			"error": []string{"The requested report does not exist."},
+		})
+	}
+
+	if err := app.DB().Unscoped().Where(&models.Report{ID: report.ID, CreatedAt: report.CreatedAt}).Delete(&models.Report{}).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error purging CSP report: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not purge report."},
+		})
+	}
+
+	slog.Info(fmt.Sprintf("CSP report %s purged by %s", report.ID, helpers.GetUserID(c)))
+	recomputeReportRollups(report.CreatedAt)
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The report has been permanently deleted.",
+	})
+}
+
+type bulkReportInput struct {
+	Action string `json:"action"`
+	// Required to apply the action without filters
+	All bool `json:"all"`
+}
+
+func BulkCSPReports(c *fiber.Ctx) error {
+	filters, errs := helpers.ParseReportFilters(c)
+
+	input := &bulkReportInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid bulk action data."},
+		})
+	}
+
+	input.Action = strings.ToLower(strings.TrimSpace(input.Action))
+
+	switch input.Action {
+	case "delete":
+		filters.Deleted = helpers.DeletedExclude
+	case "restore":
+		filters.Deleted = helpers.DeletedOnly
+	default:
+		errs = utils.AddError(errs, "action", "The action must be one of: delete, restore.")
+	}
+
+	if filters.IsEmpty() && !input.All {
+		errs = utils.AddError(errs, "all", "Please, provide at least one filter or confirm the action applies to all reports.")
+	}
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	filters.SiteIDs = scope.Restrict(filters.SiteIDs)
+	query := func() *gorm.DB {
+		return filters.Apply(app.DB().Session(&gorm.Session{AllowGlobalUpdate: true}).Model(&models.Report{}), "")
+	}
+
+	buckets, err := helpers.GetReportBuckets(query())
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting affected reports: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not apply bulk action."},
+		})
+	}
+
+	userID := helpers.GetUserID(c)
+	values := map[string]interface{}{
+		"deleted_at":    time.Now().In(utils.DefaultLocation()),
+		"deleted_by_id": userID,
+	}
+
+	if input.Action == "restore" {
+		values = map[string]interface{}{
+			"deleted_at":     nil,
+			"restored_by_id": userID,
+		}
+	}
+
+	result := query().Updates(values)
+	if result.Error != nil {
+		sentry.CaptureException(result.Error)
+		slog.Error(fmt.Sprintf("Error applying bulk action: %v", result.Error))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not apply bulk action."},
+		})
+	}
+
+	slog.Info(fmt.Sprintf("Bulk %s of %d CSP reports by %s", input.Action, result.RowsAffected, userID))
+	recomputeReportRollups(buckets...)
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"data": fiber.Map{
+			"action":   input.Action,
+			"affected": result.RowsAffected,
+		},
+	})
+}
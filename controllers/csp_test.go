@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/testutil"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Deletes and restores a report, recording who did it
+func TestDeleteRestoreCSPReport(t *testing.T) {
+	db := testutil.DB(t)
+
+	user := &models.User{Email: fmt.Sprintf("%s@example.com", uuid.NewString()), Password: "-"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Members only see the reports of the sites of their organizations
+	org := &models.Organization{Name: "Test", Slug: uuid.NewString()}
+	if err := db.Create(&org).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := helpers.AddOrganizationMember(db, org.ID, user.ID, helpers.OrganizationMember); err != nil {
+		t.Fatal(err)
+	}
+
+	site := &models.Site{Domain: fmt.Sprintf("%s.example.com", uuid.NewString()), OrganizationID: &org.ID}
+	if err := db.Create(&site).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	report := &models.Report{
+		SiteID:             site.ID,
+		BlockedURI:         "inline",
+		Disposition:        "enforce",
+		DocumentURI:        "https://" + site.Domain,
+		EffectiveDirective: "script-src-elem",
+		OriginalPolicy:     "script-src 'self'",
+		ViolatedDirective:  "script-src-elem",
+	}
+	if err := db.Create(&report).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Unscoped().Where(&models.Report{ID: report.ID}).Delete(&models.Report{})
+		db.Unscoped().Delete(&site)
+		db.Where(&models.OrganizationMember{OrganizationID: org.ID}).Delete(&models.OrganizationMember{})
+		db.Unscoped().Delete(&org)
+		db.Unscoped().Delete(&user)
+	})
+
+	token, err := helpers.NewAccessToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals(utils.AccessTokenContextKey(), token)
+		return c.Next()
+	})
+	app.Delete("/reports/:id", DeleteCSPReport)
+	app.Post("/reports/:id/restore", RestoreCSPReport)
+
+	res, err := app.Test(httptest.NewRequest(fiber.MethodDelete, "/reports/"+report.ID.String(), nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != fiber.StatusOK {
+		t.Fatalf("Deleting: status %d", res.StatusCode)
+	}
+
+	deleted := &models.Report{}
+	if err := db.Unscoped().Where(&models.Report{ID: report.ID}).First(&deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if !deleted.DeletedAt.Valid || deleted.DeletedByID == nil || *deleted.DeletedByID != user.ID {
+		t.Fatalf("Deleting: got deleted_at %v and deleted_by_id %v", deleted.DeletedAt, deleted.DeletedByID)
+	}
+
+	res, err = app.Test(httptest.NewRequest(fiber.MethodPost, "/reports/"+report.ID.String()+"/restore", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != fiber.StatusOK {
+		t.Fatalf("Restoring: status %d", res.StatusCode)
+	}
+
+	restored := &models.Report{}
+	if err := db.Where(&models.Report{ID: report.ID}).First(&restored).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.RestoredByID == nil || *restored.RestoredByID != user.ID {
+		t.Fatalf("Restoring: got restored_by_id %v", restored.RestoredByID)
+	}
+}
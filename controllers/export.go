@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/tasks"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type exportInput struct {
+	Format string `json:"format"`
+}
+
+func PostCSPReportExport(c *fiber.Ctx) error {
+	filters, errs := helpers.ParseReportFilters(c)
+
+	input := &exportInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid export data."},
+		})
+	}
+
+	input.Format = strings.ToLower(strings.TrimSpace(input.Format))
+
+	if len(input.Format) < 1 {
+		input.Format = helpers.ExportCSV
+	}
+
+	if !slices.Contains(helpers.ExportFormats(), input.Format) {
+		errs = utils.AddError(errs, "format", fmt.Sprintf("The format must be one of: %s.", strings.Join(helpers.ExportFormats(), ", ")))
+	}
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	// The export keeps the scope of its creator
+	filters.SiteIDs = scope.Restrict(filters.SiteIDs)
+	rawFilters, err := json.Marshal(filters)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error encoding export filters: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create export."},
+		})
+	}
+
+	export := &models.Export{
+		UserID:  helpers.GetUserID(c),
+		Format:  input.Format,
+		Filters: rawFilters,
+		Status:  helpers.ExportPending,
+		Storage: utils.ExportStorage(),
+	}
+
+	if err := app.DB().Create(&export).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error creating export: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create export."},
+		})
+	}
+
+	downloadPath, err := c.GetRouteURL("api.csp.exports.download", fiber.Map{"id": export.ID.String()})
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting export download route: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create export."},
+		})
+	}
+
+	if err := tasks.NewReportExport(export.ID, c.BaseURL()+downloadPath); err != nil {
+		msg := "Could not queue export."
+		if err := app.DB().Model(&export).Updates(&models.Export{Status: helpers.ExportFailed, Error: &msg}).Error; err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Error updating export status: %v", err))
+		}
+
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{msg},
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(&fiber.Map{
+		"message": "The export has been queued. You will receive an email when it's ready.",
+		"data":    export,
+	})
+}
+
+func GetAllCSPExports(c *fiber.Ctx) error {
+	exports := []models.Export{}
+	query := app.DB().Model(&models.Export{}).Where(&models.Export{UserID: helpers.GetUserID(c)})
+	opts := helpers.PaginatedItemOpts{RouteName: "api.csp.exports.index", SortColumns: []string{"status"}}
+
+	return helpers.PaginateQuery(exports, query, c, opts)
+}
+
+func GetCSPExport(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested export is invalid."},
+		})
+	}
+
+	export := &models.Export{}
+	if err := app.DB().Where(&models.Export{ID: id, UserID: helpers.GetUserID(c)}).First(&export).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested export does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting export: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get export."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": export})
+}
+
+func DownloadCSPExport(c *fiber.Ctx) error {
+	defaultErr := func() error {
+		return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+			"error": []string{"The requested export does not exist or has expired."},
+		})
+	}
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return defaultErr()
+	}
+
+	if err := helpers.ValidateExportDownloadToken(id, c.Query("token")); err != nil {
+		slog.Error(fmt.Sprintf("Invalid export download token: %v", err))
+		return defaultErr()
+	}
+
+	export := &models.Export{}
+	if err := app.DB().Where(&models.Export{ID: id, Status: helpers.ExportCompleted}).First(&export).Error; err != nil {
+		return defaultErr()
+	}
+
+	if export.Path == nil || export.ExpiresAt == nil || export.ExpiresAt.Before(time.Now()) {
+		return defaultErr()
+	}
+
+	storage, err := helpers.GetStorage(export.Storage)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting export storage: %v", err))
+		return defaultErr()
+	}
+
+	file, err := storage.Open(c.Context(), *export.Path)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error opening export file: %v", err))
+		return defaultErr()
+	}
+
+	c.Attachment(fmt.Sprintf("reports-%s.%s", export.CreatedAt.Format("20060102-150405"), export.Format))
+	c.Set(fiber.HeaderContentType, helpers.ExportContentType(export.Format))
+
+	// The stream is closed by fasthttp once it has been sent
+	return c.Status(fiber.StatusOK).SendStream(file, int(export.Size))
+}
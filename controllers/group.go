@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const maxCommentLength int = 5000
+
+type violationGroupInput struct {
+	Status     *string `json:"status"`
+	AssigneeID *string `json:"assignee_id"`
+}
+
+type violationGroupCommentInput struct {
+	Body string `json:"body"`
+}
+
+func getViolationGroup(c *fiber.Ctx, preload bool) (*models.ViolationGroup, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested violation group is invalid."},
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return nil, err
+	}
+
+	query := scope.Apply(app.DB().Where(&models.ViolationGroup{ID: id}), "site_id")
+
+	if preload {
+		query = query.Preload("Site").Preload("Assignee")
+	}
+
+	group := &models.ViolationGroup{}
+	if err := query.First(&group).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested violation group does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting violation group: %v", err))
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get violation group."},
+		})
+	}
+
+	return group, nil
+}
+
+func GetAllViolationGroups(c *fiber.Ctx) error {
+	filters, errs := helpers.ParseGroupFilters(c, helpers.GetUserID(c))
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	filters.SiteIDs = scope.Restrict(filters.SiteIDs)
+	groups := []models.ViolationGroup{}
+	query := filters.Apply(app.DB().Model(&models.ViolationGroup{}).Preload("Site").Preload("Assignee"), "")
+	opts := helpers.PaginatedItemOpts{
+		RouteName:   "api.csp.groups.index",
+		Filters:     filters.Fingerprint(),
+		SortColumns: []string{"last_seen_at", "first_seen_at", "count", "status"},
+	}
+
+	return helpers.PaginateQuery(groups, query, c, opts)
+}
+
+func GetViolationGroup(c *fiber.Ctx) error {
+	group, err := getViolationGroup(c, true)
+	if group == nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": group})
+}
+
+func PatchViolationGroup(c *fiber.Ctx) error {
+	group, err := getViolationGroup(c, false)
+	if group == nil {
+		return err
+	}
+
+	input := &violationGroupInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid violation group data."},
+		})
+	}
+
+	errs := fiber.Map{}
+	changes := map[string]interface{}{}
+	userID := helpers.GetUserID(c)
+
+	if input.Status != nil {
+		status := strings.ToLower(strings.TrimSpace(*input.Status))
+
+		if !slices.Contains(helpers.GroupStatuses(), status) {
+			errs = utils.AddError(errs, "status", "The status must be one of: "+strings.Join(helpers.GroupStatuses(), ", ")+".")
+		} else if status != group.Status {
+			changes["status"] = status
+
+			if status == helpers.GroupResolved {
+				changes["resolved_at"] = time.Now().In(utils.DefaultLocation())
+				changes["resolved_by_id"] = userID
+			} else {
+				changes["resolved_at"] = nil
+				changes["resolved_by_id"] = nil
+			}
+		}
+	}
+
+	if input.AssigneeID != nil {
+		assignee := strings.TrimSpace(*input.AssigneeID)
+
+		if len(assignee) < 1 {
+			changes["assignee_id"] = nil
+		} else {
+			id, err := uuid.Parse(assignee)
+			active := true
+
+			if err != nil || !utils.IsValidUuid(id) {
+				errs = utils.AddError(errs, "assignee_id", "The assignee is invalid.")
+			} else if err := app.DB().Where(&models.User{ID: id, Active: &active}).First(&models.User{}).Error; err != nil {
+				errs = utils.AddError(errs, "assignee_id", "The assignee does not exist or is not active.")
+			} else {
+				changes["assignee_id"] = id
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	if len(changes) > 0 {
+		if err := app.DB().Model(&group).Updates(changes).Error; err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Error updating violation group: %v", err))
+			return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+				"error": []string{"Could not update violation group."},
+			})
+		}
+
+		slog.Info(fmt.Sprintf("Violation group %s updated by %s", group.ID, userID))
+	}
+
+	if err := app.DB().Preload("Site").Preload("Assignee").First(&group).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting violation group: %v", err))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The violation group has been updated.",
+		"data":    group,
+	})
+}
+
+func GetAllViolationGroupComments(c *fiber.Ctx) error {
+	group, err := getViolationGroup(c, false)
+	if group == nil {
+		return err
+	}
+
+	comments := []models.ViolationGroupComment{}
+	query := app.DB().Model(&models.ViolationGroupComment{}).
+		Where(&models.ViolationGroupComment{GroupID: group.ID}).
+		Preload("Author")
+	opts := helpers.PaginatedItemOpts{RouteName: "api.csp.groups.comments.index"}
+
+	return helpers.PaginateQuery(comments, query, c, opts)
+}
+
+func PostViolationGroupComment(c *fiber.Ctx) error {
+	group, err := getViolationGroup(c, false)
+	if group == nil {
+		return err
+	}
+
+	input := &violationGroupCommentInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid comment data."},
+		})
+	}
+
+	input.Body = strings.TrimSpace(input.Body)
+	errs := fiber.Map{}
+
+	if len(input.Body) < 1 {
+		errs = utils.AddError(errs, "body", "The comment cannot be empty.")
+	}
+
+	if utf8.RuneCountInString(input.Body) > maxCommentLength {
+		errs = utils.AddError(errs, "body", fmt.Sprintf("The comment must be at most %d characters long.", maxCommentLength))
+	}
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	comment := &models.ViolationGroupComment{
+		GroupID:  group.ID,
+		AuthorID: helpers.GetUserID(c),
+		Body:     input.Body,
+	}
+
+	if err := app.DB().Create(&comment).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error creating violation group comment: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not add comment."},
+		})
+	}
+
+	if err := app.DB().Preload("Author").First(&comment).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting violation group comment: %v", err))
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(&fiber.Map{
+		"message": "The comment has been added.",
+		"data":    comment,
+	})
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"alfredoramos.mx/csp-reporter/helpers"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+)
+
+func ldapLogin(c *fiber.Ctx, input *userLoginInput) error {
+	identity, err := helpers.AuthenticateLDAP(input.Email, input.Password)
+	if errors.Is(err, helpers.ErrLDAPInvalidCredentials) {
+		helpers.RecordAudit(c, helpers.AuditEntry{
+			Action:     helpers.AuditLoginFailed,
+			TargetType: "user",
+			TargetID:   input.Email,
+			After:      fiber.Map{"method": "ldap"},
+		})
+
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{err.Error()},
+		})
+	}
+
+	if errors.Is(err, helpers.ErrIdentityEmailInvalid) {
+		helpers.RecordAudit(c, helpers.AuditEntry{
+			Action:     helpers.AuditLoginFailed,
+			TargetType: "user",
+			TargetID:   input.Email,
+			After:      fiber.Map{"method": "ldap"},
+		})
+
+		return c.Status(fiber.StatusForbidden).JSON(&fiber.Map{
+			"error": []string{err.Error()},
+		})
+	}
+
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error authenticating LDAP user: %v", err))
+
+		return c.Status(fiber.StatusBadGateway).JSON(&fiber.Map{
+			"error": []string{"Could not reach the directory server, please try again later."},
+		})
+	}
+
+	user, err := helpers.GetLDAPUser(identity)
+	if errors.Is(err, helpers.ErrIdentityUserNotFound) || errors.Is(err, helpers.ErrIdentityUserInactive) || errors.Is(err, helpers.ErrIdentityEmailInvalid) {
+		helpers.RecordAudit(c, helpers.AuditEntry{
+			Action:     helpers.AuditLoginFailed,
+			TargetType: "user",
+			TargetID:   input.Email,
+			After:      fiber.Map{"method": "ldap", "subject": identity.Subject},
+		})
+
+		return c.Status(fiber.StatusForbidden).JSON(&fiber.Map{
+			"error": []string{err.Error()},
+		})
+	}
+
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting LDAP user: %v", err))
+
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not log in."},
+		})
+	}
+
+	return startSession(c, user, "ldap")
+}
@@ -0,0 +1,12 @@
+package controllers
+
+import (
+	"os"
+	"testing"
+
+	"alfredoramos.mx/csp-reporter/testutil"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(testutil.Main(m))
+}
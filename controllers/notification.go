@@ -0,0 +1,331 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func GetAllNotifications(c *fiber.Ctx) error {
+	notifications := []models.Notification{}
+	query := app.DB().Model(&models.Notification{}).Where(&models.Notification{UserID: helpers.GetUserID(c)})
+	filters := ""
+
+	if c.QueryBool("unread") {
+		query = query.Where("read_at IS NULL")
+		filters = "unread=true"
+	}
+
+	opts := helpers.PaginatedItemOpts{RouteName: "api.notifications.index", Filters: filters}
+
+	return helpers.PaginateQuery(notifications, query, c, opts)
+}
+
+func ReadNotification(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested notification is invalid."},
+		})
+	}
+
+	notification := &models.Notification{}
+	if err := app.DB().Where(&models.Notification{ID: id, UserID: helpers.GetUserID(c)}).First(&notification).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested notification does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting notification: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get notification."},
+		})
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		notification.ReadAt = &now
+
+		if err := app.DB().Model(&notification).Update("read_at", now).Error; err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Error updating notification: %v", err))
+			return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+				"error": []string{"Could not update notification."},
+			})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": notification})
+}
+
+func ReadAllNotifications(c *fiber.Ctx) error {
+	result := app.DB().Model(&models.Notification{}).
+		Where(&models.Notification{UserID: helpers.GetUserID(c)}).
+		Where("read_at IS NULL").
+		Update("read_at", time.Now())
+	if err := result.Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error updating notifications: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not update notifications."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": fmt.Sprintf("%d notifications have been marked as read.", result.RowsAffected),
+		"count":   result.RowsAffected,
+	})
+}
+
+func GetNotificationPreferences(c *fiber.Ctx) error {
+	prefs, err := helpers.GetNotificationPreferences(helpers.GetUserID(c))
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting notification preferences: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get notification preferences."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": prefs})
+}
+
+func PutNotificationPreferences(c *fiber.Ctx) error {
+	input := struct {
+		Preferences []models.NotificationPreference `json:"preferences"`
+	}{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid notification preferences."},
+		})
+	}
+
+	errs := fiber.Map{}
+
+	for i, p := range input.Preferences {
+		p.EventType = strings.ToLower(strings.TrimSpace(p.EventType))
+		p.Channel = strings.ToLower(strings.TrimSpace(p.Channel))
+		p.Frequency = strings.ToLower(strings.TrimSpace(p.Frequency))
+		input.Preferences[i] = p
+
+		if !slices.Contains(helpers.NotificationEventTypes(), p.EventType) {
+			errs = utils.AddError(errs, "event", fmt.Sprintf("The event '%s' is invalid. It must be one of: %s.", p.EventType, strings.Join(helpers.NotificationEventTypes(), ", ")))
+		}
+
+		if !slices.Contains(helpers.NotificationChannels(), p.Channel) {
+			errs = utils.AddError(errs, "channel", fmt.Sprintf("The channel '%s' is invalid. It must be one of: %s.", p.Channel, strings.Join(helpers.NotificationChannels(), ", ")))
+		}
+
+		if !slices.Contains(helpers.NotificationFrequencies(), p.Frequency) {
+			errs = utils.AddError(errs, "frequency", fmt.Sprintf("The frequency '%s' is invalid. It must be one of: %s.", p.Frequency, strings.Join(helpers.NotificationFrequencies(), ", ")))
+		}
+	}
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	userID := helpers.GetUserID(c)
+
+	if err := helpers.SetNotificationPreferences(userID, input.Preferences); err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error saving notification preferences: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not save notification preferences."},
+		})
+	}
+
+	prefs, err := helpers.GetNotificationPreferences(userID)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting notification preferences: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get notification preferences."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The notification preferences have been saved.",
+		"data":    prefs,
+	})
+}
+
+func GetAllSiteSubscriptions(c *fiber.Ctx) error {
+	subscriptions := []models.SiteSubscription{}
+	query := app.DB().Model(&models.SiteSubscription{}).Where(&models.SiteSubscription{UserID: helpers.GetUserID(c)}).Preload("Site")
+	opts := helpers.PaginatedItemOpts{RouteName: "api.notifications.subscriptions.index"}
+
+	return helpers.PaginateQuery(subscriptions, query, c, opts)
+}
+
+func PutSiteSubscription(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested site is invalid."},
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	site := &models.Site{}
+	if err := scope.Apply(app.DB().Where(&models.Site{ID: id}), "id").First(&site).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested site does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting site: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get site."},
+		})
+	}
+
+	subscription := &models.SiteSubscription{UserID: helpers.GetUserID(c), SiteID: site.ID}
+	if err := app.DB().Clauses(clause.OnConflict{DoNothing: true}).Omit(clause.Associations).Create(&subscription).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error saving site subscription: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not subscribe to the site."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": fmt.Sprintf("You are now subscribed to %s.", site.Domain),
+	})
+}
+
+func DeleteSiteSubscription(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested site is invalid."},
+		})
+	}
+
+	if err := app.DB().Where(&models.SiteSubscription{UserID: helpers.GetUserID(c), SiteID: id}).Delete(&models.SiteSubscription{}).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error deleting site subscription: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not unsubscribe from the site."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "You are no longer subscribed to the site.",
+	})
+}
+
+func GetAllPersonalWebhooks(c *fiber.Ctx) error {
+	userID := helpers.GetUserID(c)
+	webhooks := []models.Webhook{}
+	query := app.DB().Model(&models.Webhook{}).Where(&models.Webhook{UserID: &userID})
+	opts := helpers.PaginatedItemOpts{RouteName: "api.notifications.webhooks.index", SortColumns: []string{"name"}}
+
+	return helpers.PaginateQuery(webhooks, query, c, opts)
+}
+
+// Webhook that receives the notifications of the user through the webhook channel
+func PostPersonalWebhook(c *fiber.Ctx) error {
+	input := &webhookInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid webhook data."},
+		})
+	}
+
+	secret, err := helpers.NewWebhookSecret()
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error generating webhook secret: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create webhook."},
+		})
+	}
+
+	userID := helpers.GetUserID(c)
+	enabled := true
+	webhook := &models.Webhook{
+		UserID:      &userID,
+		Kind:        helpers.WebhookJSON,
+		Secret:      secret,
+		Enabled:     &enabled,
+		CreatedByID: userID,
+	}
+
+	// The events are chosen in the notification preferences
+	input.Events = nil
+
+	if errs := input.apply(webhook); len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	if err := app.DB().Omit(clause.Associations).Create(&webhook).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error creating webhook: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create webhook."},
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(&fiber.Map{
+		"message": "The webhook has been created. Store the secret in a safe place, it will not be shown again.",
+		"data":    webhook,
+		"secret":  webhook.Secret,
+	})
+}
+
+func DeletePersonalWebhook(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested webhook is invalid."},
+		})
+	}
+
+	userID := helpers.GetUserID(c)
+	result := app.DB().Where(&models.Webhook{ID: id, UserID: &userID}).Delete(&models.Webhook{})
+	if err := result.Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error deleting webhook: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not delete webhook."},
+		})
+	}
+
+	if result.RowsAffected < 1 {
+		return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+			"error": []string{"The requested webhook does not exist."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The webhook has been deleted.",
+	})
+}
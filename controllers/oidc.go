@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+)
+
+type oidcCallbackInput struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+func AuthOIDCLogin(c *fiber.Ctx) error {
+	if !utils.OIDCEnabled() {
+		return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+			"error": []string{helpers.ErrOIDCDisabled.Error()},
+		})
+	}
+
+	url, err := helpers.NewOIDCAuthorizationURL(c)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error generating OIDC authorization URL: %v", err))
+
+		return c.Status(fiber.StatusBadGateway).JSON(&fiber.Map{
+			"error": []string{"Could not start single sign-on."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"url": url})
+}
+
+func AuthOIDCCallback(c *fiber.Ctx) error {
+	if !utils.OIDCEnabled() {
+		return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+			"error": []string{helpers.ErrOIDCDisabled.Error()},
+		})
+	}
+
+	input := &oidcCallbackInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The single sign-on data is invalid."},
+		})
+	}
+
+	claims, err := helpers.ExchangeOIDCCode(c, input.State, input.Code)
+	if errors.Is(err, helpers.ErrOIDCStateInvalid) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{err.Error()},
+		})
+	}
+
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error validating OIDC login: %v", err))
+
+		return c.Status(fiber.StatusBadGateway).JSON(&fiber.Map{
+			"error": []string{"Could not complete single sign-on."},
+		})
+	}
+
+	user, err := helpers.GetOIDCUser(claims)
+	if errors.Is(err, helpers.ErrIdentityUserNotFound) || errors.Is(err, helpers.ErrIdentityUserInactive) || errors.Is(err, helpers.ErrIdentityEmailInvalid) {
+		helpers.RecordAudit(c, helpers.AuditEntry{
+			Action:     helpers.AuditLoginFailed,
+			TargetType: "user",
+			TargetID:   claims.Email,
+			After:      fiber.Map{"method": "oidc", "subject": claims.Subject},
+		})
+
+		return c.Status(fiber.StatusForbidden).JSON(&fiber.Map{
+			"error": []string{err.Error()},
+		})
+	}
+
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting OIDC user: %v", err))
+
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not complete single sign-on."},
+		})
+	}
+
+	return startSession(c, user, "oidc")
+}
@@ -0,0 +1,624 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/tasks"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type organizationInput struct {
+	Name *string `json:"name"`
+	Slug *string `json:"slug"`
+}
+
+type organizationMemberInput struct {
+	Role string `json:"role"`
+}
+
+type organizationInvitationInput struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type acceptInvitationInput struct {
+	Token string `json:"token"`
+}
+
+// Applies the input to the organization, returning the validation errors of the result
+func (input *organizationInput) apply(org *models.Organization) fiber.Map {
+	errs := fiber.Map{}
+
+	if input.Name != nil {
+		org.Name = strings.TrimSpace(*input.Name)
+
+		// The slug follows the name unless it's given
+		if input.Slug == nil && len(org.Slug) < 1 {
+			org.Slug = helpers.OrganizationSlug(org.Name)
+		}
+	}
+
+	if input.Slug != nil {
+		org.Slug = helpers.OrganizationSlug(*input.Slug)
+	}
+
+	if n := utf8.RuneCountInString(org.Name); n < 1 || n > 255 {
+		errs = utils.AddError(errs, "name", "The name must be between 1 and 255 characters long.")
+	}
+
+	if len(org.Slug) < 1 || len(org.Slug) > 100 {
+		errs = utils.AddError(errs, "slug", "The slug must be between 1 and 100 letters, numbers or dashes long.")
+	} else if err := app.DB().Unscoped().Where(&models.Organization{Slug: org.Slug}).Not(&models.Organization{ID: org.ID}).First(&models.Organization{}).Error; err == nil {
+		errs = utils.AddError(errs, "slug", "The slug is already used by another organization, including deleted ones.")
+	}
+
+	return errs
+}
+
+// Sites the endpoint may be used on, writes the error response on failure
+func getSiteScope(c *fiber.Ctx) (*helpers.SiteScope, error) {
+	if scope := helpers.GetRequestSiteScope(c); scope != nil {
+		return scope, nil
+	}
+
+	scope, err := helpers.GetSiteScope(c)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting accessible sites: %v", err))
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get the accessible sites."},
+		})
+	}
+
+	return scope, nil
+}
+
+// Writes a forbidden response unless the user administers the organization.
+// Resources without an organization can only be managed by superadmins.
+func canManageOrganization(c *fiber.Ctx, organizationID *uuid.UUID) (bool, error) {
+	id := uuid.Nil
+
+	if organizationID != nil {
+		id = *organizationID
+	}
+
+	if helpers.IsOrganizationAdmin(c, id) {
+		return true, nil
+	}
+
+	return false, c.Status(fiber.StatusForbidden).JSON(&fiber.Map{
+		"error": []string{"Only the administrators of the organization can do this."},
+	})
+}
+
+// Organizations are only visible to their members, administrators can manage them
+func getOrganization(c *fiber.Ctx, manage bool) (*models.Organization, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested organization is invalid."},
+		})
+	}
+
+	org := &models.Organization{}
+	if err := app.DB().Where(&models.Organization{ID: id}).First(&org).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting organization: %v", err))
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get organization."},
+		})
+	}
+
+	if !utils.IsValidUuid(org.ID) || !helpers.IsOrganizationMember(c, org.ID) {
+		return nil, c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+			"error": []string{"The requested organization does not exist."},
+		})
+	}
+
+	if manage {
+		if ok, err := canManageOrganization(c, &org.ID); !ok {
+			return nil, err
+		}
+	}
+
+	return org, nil
+}
+
+// Organizations must always keep at least one administrator
+func isLastOrganizationAdmin(member *models.OrganizationMember) (bool, error) {
+	if member.Role != helpers.OrganizationAdmin {
+		return false, nil
+	}
+
+	var n int64
+	if err := app.DB().Model(&models.OrganizationMember{}).
+		Where(&models.OrganizationMember{OrganizationID: member.OrganizationID, Role: helpers.OrganizationAdmin}).
+		Count(&n).Error; err != nil {
+		return false, err
+	}
+
+	return n < 2, nil
+}
+
+func getOrganizationMember(c *fiber.Ctx, org *models.Organization) (*models.OrganizationMember, error) {
+	userID, err := uuid.Parse(c.Params("user_id"))
+	if err != nil || !utils.IsValidUuid(userID) {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested member is invalid."},
+		})
+	}
+
+	member := &models.OrganizationMember{}
+	if err := app.DB().Where(&models.OrganizationMember{OrganizationID: org.ID, UserID: userID}).Preload("User").First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested member does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting organization member: %v", err))
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get organization member."},
+		})
+	}
+
+	return member, nil
+}
+
+func GetAllOrganizations(c *fiber.Ctx) error {
+	orgs := []models.Organization{}
+	query := app.DB().Model(&models.Organization{})
+	userID := helpers.GetUserID(c)
+
+	if !helpers.IsSuperAdmin(c) {
+		query = query.Where("organizations.id IN (?)", app.DB().Model(&models.OrganizationMember{}).
+			Select("organization_id").
+			Where(&models.OrganizationMember{UserID: userID}))
+	}
+
+	opts := helpers.PaginatedItemOpts{
+		RouteName:   "api.organizations.index",
+		TableAlias:  "organizations",
+		SortColumns: []string{"name", "slug"},
+	}
+
+	return helpers.PaginateQuery(orgs, query, c, opts)
+}
+
+func GetOrganization(c *fiber.Ctx) error {
+	org, err := getOrganization(c, false)
+	if org == nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": org})
+}
+
+func PostOrganization(c *fiber.Ctx) error {
+	input := &organizationInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid organization data."},
+		})
+	}
+
+	org := &models.Organization{}
+
+	if errs := input.apply(org); len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	userID := helpers.GetUserID(c)
+
+	if err := app.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&org).Error; err != nil {
+			return err
+		}
+
+		// The creator administers it until other administrators are invited
+		return helpers.AddOrganizationMember(tx, org.ID, userID, helpers.OrganizationAdmin)
+	}); err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error creating organization: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create organization."},
+		})
+	}
+
+	slog.Info(fmt.Sprintf("Organization %s (%s) created by %s", org.ID, org.Slug, userID))
+
+	return c.Status(fiber.StatusCreated).JSON(&fiber.Map{
+		"message": "The organization has been created.",
+		"data":    org,
+	})
+}
+
+func PatchOrganization(c *fiber.Ctx) error {
+	org, err := getOrganization(c, true)
+	if org == nil {
+		return err
+	}
+
+	input := &organizationInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid organization data."},
+		})
+	}
+
+	if errs := input.apply(org); len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	if err := app.DB().Save(&org).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error updating organization: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not update organization."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The organization has been updated.",
+		"data":    org,
+	})
+}
+
+// The sites of a deleted organization are only accessible to superadmins
+func DeleteOrganization(c *fiber.Ctx) error {
+	org, err := getOrganization(c, true)
+	if org == nil {
+		return err
+	}
+
+	if err := app.DB().Delete(&org).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error deleting organization: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not delete organization."},
+		})
+	}
+
+	slog.Info(fmt.Sprintf("Organization %s (%s) deleted by %s", org.ID, org.Slug, helpers.GetUserID(c)))
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The organization has been deleted.",
+	})
+}
+
+func GetAllOrganizationMembers(c *fiber.Ctx) error {
+	org, err := getOrganization(c, false)
+	if org == nil {
+		return err
+	}
+
+	members := []models.OrganizationMember{}
+	query := app.DB().Model(&models.OrganizationMember{}).
+		Where(&models.OrganizationMember{OrganizationID: org.ID}).
+		Preload("User")
+	opts := helpers.PaginatedItemOpts{
+		RouteName:   "api.organizations.members.index",
+		TableAlias:  "organization_members",
+		SortColumns: []string{"role"},
+	}
+
+	return helpers.PaginateQuery(members, query, c, opts)
+}
+
+func PatchOrganizationMember(c *fiber.Ctx) error {
+	org, err := getOrganization(c, true)
+	if org == nil {
+		return err
+	}
+
+	member, err := getOrganizationMember(c, org)
+	if member == nil {
+		return err
+	}
+
+	input := &organizationMemberInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid organization member data."},
+		})
+	}
+
+	input.Role = strings.ToLower(strings.TrimSpace(input.Role))
+
+	if !slices.Contains(helpers.OrganizationRoles(), input.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": utils.AddError(fiber.Map{}, "role", "The role must be one of: "+strings.Join(helpers.OrganizationRoles(), ", ")+"."),
+		})
+	}
+
+	if input.Role != member.Role {
+		last, err := isLastOrganizationAdmin(member)
+		if err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Error counting organization administrators: %v", err))
+			return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+				"error": []string{"Could not update organization member."},
+			})
+		}
+
+		if last {
+			return c.Status(fiber.StatusConflict).JSON(&fiber.Map{
+				"error": []string{"The organization must have at least one administrator."},
+			})
+		}
+	}
+
+	if err := app.DB().Model(&member).Update("role", input.Role).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error updating organization member: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not update organization member."},
+		})
+	}
+
+	slog.Info(fmt.Sprintf("Member %s of organization %s changed to %s by %s", member.UserID, org.ID, input.Role, helpers.GetUserID(c)))
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The organization member has been updated.",
+		"data":    member,
+	})
+}
+
+func DeleteOrganizationMember(c *fiber.Ctx) error {
+	org, err := getOrganization(c, true)
+	if org == nil {
+		return err
+	}
+
+	member, err := getOrganizationMember(c, org)
+	if member == nil {
+		return err
+	}
+
+	last, err := isLastOrganizationAdmin(member)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error counting organization administrators: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not remove organization member."},
+		})
+	}
+
+	if last {
+		return c.Status(fiber.StatusConflict).JSON(&fiber.Map{
+			"error": []string{"The organization must have at least one administrator."},
+		})
+	}
+
+	if err := app.DB().Delete(&member).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error removing organization member: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not remove organization member."},
+		})
+	}
+
+	slog.Info(fmt.Sprintf("Member %s removed from organization %s by %s", member.UserID, org.ID, helpers.GetUserID(c)))
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The member has been removed from the organization.",
+	})
+}
+
+func GetAllOrganizationInvitations(c *fiber.Ctx) error {
+	org, err := getOrganization(c, true)
+	if org == nil {
+		return err
+	}
+
+	invitations := []models.OrganizationInvitation{}
+	query := app.DB().Model(&models.OrganizationInvitation{}).
+		Where(&models.OrganizationInvitation{OrganizationID: org.ID}).
+		Where("accepted_at IS NULL AND expires_at > ?", time.Now())
+	opts := helpers.PaginatedItemOpts{
+		RouteName:   "api.organizations.invitations.index",
+		TableAlias:  "organization_invitations",
+		SortColumns: []string{"email", "expires_at"},
+	}
+
+	return helpers.PaginateQuery(invitations, query, c, opts)
+}
+
+// Invites someone by email, they can accept it with their account or register with it
+func PostOrganizationInvitation(c *fiber.Ctx) error {
+	org, err := getOrganization(c, true)
+	if org == nil {
+		return err
+	}
+
+	input := &organizationInvitationInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid invitation data."},
+		})
+	}
+
+	input.Email = strings.TrimSpace(input.Email)
+	input.Role = strings.ToLower(strings.TrimSpace(input.Role))
+	errs := fiber.Map{}
+
+	if len(input.Role) < 1 {
+		input.Role = helpers.OrganizationMember
+	}
+
+	if !utils.IsValidEmail(input.Email) {
+		errs = utils.AddError(errs, "email", "Please, enter a valid email address.")
+	} else if err := app.DB().Model(&models.OrganizationMember{}).
+		Joins("INNER JOIN users u ON u.id = organization_members.user_id").
+		Where("organization_members.organization_id = ? AND lower(u.email) = lower(?)", org.ID, input.Email).
+		First(&models.OrganizationMember{}).Error; err == nil {
+		errs = utils.AddError(errs, "email", "The user is already a member of the organization.")
+	}
+
+	if !slices.Contains(helpers.OrganizationRoles(), input.Role) {
+		errs = utils.AddError(errs, "role", "The role must be one of: "+strings.Join(helpers.OrganizationRoles(), ", ")+".")
+	}
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	userID := helpers.GetUserID(c)
+	invitation := &models.OrganizationInvitation{
+		OrganizationID: org.ID,
+		Email:          input.Email,
+		Role:           input.Role,
+		InvitedByID:    userID,
+	}
+
+	token, err := helpers.NewOrganizationInvitation(app.DB(), invitation)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error creating organization invitation: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create invitation."},
+		})
+	}
+
+	invitedBy := &models.User{ID: userID}
+	if err := app.DB().Where(&invitedBy).First(&invitedBy).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting user: %v", err))
+	}
+
+	if err := tasks.NewEmail(
+		helpers.EmailOpts{
+			Subject:      fmt.Sprintf("Invitation to join %s", org.Name),
+			TemplateName: "organization_invitation",
+			ToList:       []string{invitation.Email},
+		},
+		map[string]interface{}{
+			"InvitedBy":        invitedBy.GetFullName(),
+			"OrganizationName": org.Name,
+			"Role":             invitation.Role,
+			"URL":              helpers.AppURL("/invitations/" + token),
+			"ExpiresAt":        invitation.ExpiresAt.In(utils.DefaultLocation()).Format(time.RFC1123),
+		},
+	); err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error sending email: %v", err))
+	}
+
+	slog.Info(fmt.Sprintf("Invitation %s to organization %s created by %s", invitation.ID, org.ID, userID))
+
+	return c.Status(fiber.StatusCreated).JSON(&fiber.Map{
+		"message": "The invitation has been sent.",
+		"data":    invitation,
+	})
+}
+
+func DeleteOrganizationInvitation(c *fiber.Ctx) error {
+	org, err := getOrganization(c, true)
+	if org == nil {
+		return err
+	}
+
+	id, err := uuid.Parse(c.Params("invitation_id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested invitation is invalid."},
+		})
+	}
+
+	result := app.DB().Where(&models.OrganizationInvitation{ID: id, OrganizationID: org.ID}).
+		Where("accepted_at IS NULL").
+		Delete(&models.OrganizationInvitation{})
+	if result.Error != nil {
+		sentry.CaptureException(result.Error)
+		slog.Error(fmt.Sprintf("Error deleting organization invitation: %v", result.Error))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not delete invitation."},
+		})
+	}
+
+	if result.RowsAffected < 1 {
+		return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+			"error": []string{"The requested invitation does not exist or has already been accepted."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The invitation has been deleted.",
+	})
+}
+
+// Existing users join the organization right away
+func AcceptOrganizationInvitation(c *fiber.Ctx) error {
+	input := &acceptInvitationInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid invitation data."},
+		})
+	}
+
+	user := &models.User{ID: helpers.GetUserID(c)}
+	if err := app.DB().Where(&user).First(&user).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting user: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not accept invitation."},
+		})
+	}
+
+	invitation := &models.OrganizationInvitation{}
+
+	if err := app.DB().Transaction(func(tx *gorm.DB) error {
+		inv, err := helpers.GetOrganizationInvitation(tx, input.Token, user.Email)
+		if err != nil {
+			return err
+		}
+
+		invitation = inv
+
+		return helpers.AcceptOrganizationInvitation(tx, invitation, user.ID)
+	}); err != nil {
+		if errors.Is(err, helpers.ErrInvitationInvalid) || errors.Is(err, helpers.ErrInvitationEmail) {
+			return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+				"error": []string{err.Error()},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error accepting organization invitation: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not accept invitation."},
+		})
+	}
+
+	slog.Info(fmt.Sprintf("User %s joined organization %s", user.ID, invitation.OrganizationID))
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": fmt.Sprintf("You have joined the %s organization.", invitation.Organization.Name),
+		"data":    invitation.Organization,
+	})
+}
@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type policyInput struct {
+	Role     string `json:"role"`
+	Domain   string `json:"domain"`
+	Endpoint string `json:"endpoint"`
+	Method   string `json:"method"`
+}
+
+type groupingPolicyInput struct {
+	Role   string `json:"role"`
+	Parent string `json:"parent"`
+}
+
+// Policies to manage policies cannot be removed, or nobody could manage them again
+const protectedPolicyEndpoint string = "/api/v1/policies"
+
+func isPolicyRole(name string) bool {
+	return name == "guest" || helpers.RoleRank(name) >= 0
+}
+
+func getPolicyRule(ptype string, rule []string) (*models.CasbinRule, error) {
+	r := &models.CasbinRule{}
+	if err := app.DB().Where(models.NewCasbinRule(ptype, rule).Fields()).First(&r).Error; err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func policyTargetID(policy *models.CasbinRule) string {
+	if policy == nil {
+		return ""
+	}
+
+	return policy.ID.String()
+}
+
+func GetAllPolicies(c *fiber.Ctx) error {
+	query := app.DB().Model(&models.CasbinRule{})
+	filters := []string{}
+
+	if ptype := strings.ToLower(strings.TrimSpace(c.Query("ptype"))); len(ptype) > 0 {
+		query = query.Where("casbin_rules.ptype = ?", ptype)
+		filters = append(filters, "ptype="+ptype)
+	}
+
+	if role := strings.ToLower(strings.TrimSpace(c.Query("role"))); len(role) > 0 {
+		query = query.Where("casbin_rules.v0 = ?", role)
+		filters = append(filters, "role="+role)
+	}
+
+	rules := []models.CasbinRule{}
+	opts := helpers.PaginatedItemOpts{
+		RouteName:   "api.policies.index",
+		TableAlias:  "casbin_rules",
+		SortColumns: []string{"ptype", "v0", "v2"},
+		Filters:     strings.Join(filters, "&"),
+	}
+
+	return helpers.PaginateQuery(rules, query, c, opts)
+}
+
+func PostPolicy(c *fiber.Ctx) error {
+	input := &policyInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid policy data."},
+		})
+	}
+
+	input.Role = strings.ToLower(strings.TrimSpace(input.Role))
+	input.Domain = strings.ToLower(strings.TrimSpace(input.Domain))
+	input.Endpoint = strings.TrimSpace(input.Endpoint)
+	input.Method = strings.ToUpper(strings.TrimSpace(input.Method))
+	errs := fiber.Map{}
+
+	if !isPolicyRole(input.Role) {
+		errs = utils.AddError(errs, "role", "The role does not exist.")
+	}
+
+	if len(input.Domain) < 1 {
+		input.Domain = helpers.GlobalDomain
+	}
+
+	if input.Domain != helpers.GlobalDomain && input.Domain != helpers.AnyDomain {
+		id, err := uuid.Parse(input.Domain)
+		if err != nil || !utils.IsValidUuid(id) || app.DB().Where(&models.Site{ID: id}).First(&models.Site{}).Error != nil {
+			errs = utils.AddError(errs, "domain", "The domain must be global, * or the ID of a site.")
+		}
+	}
+
+	if !strings.HasPrefix(input.Endpoint, "/api/") {
+		errs = utils.AddError(errs, "endpoint", "The endpoint must be an API route.")
+	}
+
+	if !slices.Contains([]string{fiber.MethodGet, fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete}, input.Method) {
+		errs = utils.AddError(errs, "method", "The method is invalid.")
+	}
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	rule := []string{input.Role, input.Domain, input.Endpoint, input.Method, "allow"}
+
+	added, err := app.Auth().AddPolicy(rule)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error adding policy: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not add policy."},
+		})
+	}
+
+	if !added {
+		return c.Status(fiber.StatusConflict).JSON(&fiber.Map{
+			"error": []string{"The policy already exists."},
+		})
+	}
+
+	policy, err := getPolicyRule("p", rule)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting policy: %v", err))
+	}
+
+	slog.Info(fmt.Sprintf("Policy %s added by %s", strings.Join(rule, ", "), helpers.GetUserID(c)))
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		Action:     helpers.AuditPolicyAdded,
+		TargetType: "policy",
+		TargetID:   policyTargetID(policy),
+		After:      append([]string{"p"}, rule...),
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(&fiber.Map{
+		"message": "The policy has been added.",
+		"data":    policy,
+	})
+}
+
+func PostGroupingPolicy(c *fiber.Ctx) error {
+	input := &groupingPolicyInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid role inheritance data."},
+		})
+	}
+
+	input.Role = strings.ToLower(strings.TrimSpace(input.Role))
+	input.Parent = strings.ToLower(strings.TrimSpace(input.Parent))
+	errs := fiber.Map{}
+
+	if !isPolicyRole(input.Role) {
+		errs = utils.AddError(errs, "role", "The role does not exist.")
+	}
+
+	if !isPolicyRole(input.Parent) {
+		errs = utils.AddError(errs, "parent", "The role does not exist.")
+	}
+
+	if input.Role == input.Parent {
+		errs = utils.AddError(errs, "parent", "A role cannot inherit from itself.")
+	}
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	// The parent role must not inherit from the role already
+	inherited, err := app.Auth().GetImplicitRolesForUser(input.Parent, helpers.AnyDomain)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting inherited roles: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not add role inheritance."},
+		})
+	}
+
+	if slices.Contains(inherited, input.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": fiber.Map{"parent": []string{"The role inheritance would be circular."}},
+		})
+	}
+
+	// Inherited in every domain
+	rule := []string{input.Role, input.Parent, helpers.AnyDomain}
+
+	added, err := app.Auth().AddGroupingPolicy(rule)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error adding role inheritance: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not add role inheritance."},
+		})
+	}
+
+	if !added {
+		return c.Status(fiber.StatusConflict).JSON(&fiber.Map{
+			"error": []string{"The role inheritance already exists."},
+		})
+	}
+
+	policy, err := getPolicyRule("g", rule)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting role inheritance: %v", err))
+	}
+
+	slog.Info(fmt.Sprintf("Role %s now inherits from %s by %s", input.Role, input.Parent, helpers.GetUserID(c)))
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		Action:     helpers.AuditGroupingPolicyAdded,
+		TargetType: "policy",
+		TargetID:   policyTargetID(policy),
+		After:      append([]string{"g"}, rule...),
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(&fiber.Map{
+		"message": "The role inheritance has been added.",
+		"data":    policy,
+	})
+}
+
+func DeletePolicy(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested policy is invalid."},
+		})
+	}
+
+	policy := &models.CasbinRule{}
+	if err := app.DB().Where(&models.CasbinRule{ID: id}).First(&policy).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested policy does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting policy: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get policy."},
+		})
+	}
+
+	if policy.Ptype == "p" && policy.V0 == "superadmin" && strings.HasPrefix(policy.V2, protectedPolicyEndpoint) {
+		return c.Status(fiber.StatusConflict).JSON(&fiber.Map{
+			"error": []string{"The policies to manage policies cannot be removed."},
+		})
+	}
+
+	var removed bool
+
+	switch policy.Ptype {
+	case "g":
+		removed, err = app.Auth().RemoveGroupingPolicy(policy.Rule())
+	default:
+		removed, err = app.Auth().RemoveNamedPolicy(policy.Ptype, policy.Rule())
+	}
+
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error removing policy: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not remove policy."},
+		})
+	}
+
+	// Stored but not loaded, the enforcer does not know about it
+	if !removed {
+		if err := app.DB().Delete(&policy).Error; err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Error removing policy: %v", err))
+			return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+				"error": []string{"Could not remove policy."},
+			})
+		}
+	}
+
+	slog.Info(fmt.Sprintf("Policy %s, %s removed by %s", policy.Ptype, strings.Join(policy.Rule(), ", "), helpers.GetUserID(c)))
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		Action:     helpers.AuditPolicyRemoved,
+		TargetType: "policy",
+		TargetID:   policy.ID.String(),
+		Before:     append([]string{policy.Ptype}, policy.Rule()...),
+	})
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The policy has been removed.",
+	})
+}
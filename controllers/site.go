@@ -0,0 +1,471 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxSiteDomains         int = 20
+	maxSiteIgnoredSettings int = 100
+)
+
+type siteInput struct {
+	OrganizationID *string              `json:"organization_id"`
+	Title          *string              `json:"title"`
+	Domain         *string              `json:"domain"`
+	Domains        *[]string            `json:"domains"`
+	Settings       *models.SiteSettings `json:"settings"`
+}
+
+// Applies the input to the site, returning the validation errors of the result
+func (input *siteInput) apply(c *fiber.Ctx, site *models.Site) fiber.Map {
+	errs := fiber.Map{}
+	before := siteDomainNames(site)
+
+	if input.OrganizationID != nil {
+		id, err := uuid.Parse(strings.TrimSpace(*input.OrganizationID))
+
+		switch {
+		case err != nil || !utils.IsValidUuid(id):
+			errs = utils.AddError(errs, "organization_id", "The organization is invalid.")
+		case site.OrganizationID != nil && *site.OrganizationID != id && !helpers.IsSuperAdmin(c):
+			errs = utils.AddError(errs, "organization_id", "Only superadmins can move a site to another organization.")
+		case !helpers.IsOrganizationAdmin(c, id):
+			errs = utils.AddError(errs, "organization_id", "The organization does not exist or you do not administer it.")
+		case app.DB().Where(&models.Organization{ID: id}).First(&models.Organization{}).Error != nil:
+			errs = utils.AddError(errs, "organization_id", "The organization does not exist.")
+		default:
+			site.OrganizationID = &id
+		}
+	}
+
+	if input.Title != nil {
+		site.Title = utils.ToStringPtr(*input.Title)
+	}
+
+	if input.Domain != nil {
+		domain, err := helpers.NormalizeSiteDomain(*input.Domain)
+		if err != nil {
+			errs = utils.AddError(errs, "domain", "The domain is invalid.")
+		}
+
+		site.Domain = domain
+	}
+
+	if input.Domains != nil {
+		if len(*input.Domains) > maxSiteDomains {
+			errs = utils.AddError(errs, "domains", fmt.Sprintf("A site can have at most %d additional domains.", maxSiteDomains))
+		}
+
+		site.Domains = []models.SiteDomain{}
+		seen := []string{}
+
+		for _, d := range *input.Domains {
+			domain, err := helpers.NormalizeSiteDomain(d)
+			if err != nil {
+				errs = utils.AddError(errs, "domains", fmt.Sprintf("The domain '%s' is invalid.", d))
+				continue
+			}
+
+			if slices.Contains(seen, domain) {
+				continue
+			}
+
+			seen = append(seen, domain)
+			site.Domains = append(site.Domains, models.SiteDomain{SiteID: site.ID, Domain: domain})
+		}
+	}
+
+	if input.Settings != nil {
+		site.Settings = models.SiteSettings{
+			IgnoredDirectives:   normalizeSiteSetting(input.Settings.IgnoredDirectives),
+			IgnoredBlockedHosts: normalizeSiteSetting(input.Settings.IgnoredBlockedHosts),
+		}
+	}
+
+	if site.OrganizationID == nil && input.OrganizationID == nil {
+		errs = utils.AddError(errs, "organization_id", "The organization is required.")
+	}
+
+	if site.Title != nil && utf8.RuneCountInString(*site.Title) > 255 {
+		errs = utils.AddError(errs, "title", "The title must be at most 255 characters long.")
+	}
+
+	if len(site.Domain) < 1 || len(site.Domain) > 255 {
+		errs = utils.AddError(errs, "domain", "The domain must be between 1 and 255 characters long.")
+	}
+
+	if len(site.Settings.IgnoredDirectives) > maxSiteIgnoredSettings || len(site.Settings.IgnoredBlockedHosts) > maxSiteIgnoredSettings {
+		errs = utils.AddError(errs, "settings", fmt.Sprintf("Each ignored list can have at most %d values.", maxSiteIgnoredSettings))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	domains := siteDomainNames(site)
+
+	for _, d := range site.Domains {
+		if d.Domain == site.Domain {
+			errs = utils.AddError(errs, "domains", fmt.Sprintf("The domain '%s' is already the main domain.", d.Domain))
+		}
+	}
+
+	// New domains must be verified again
+	if !slices.Equal(before, domains) {
+		site.VerifiedAt = nil
+		site.VerificationMethod = nil
+		site.VerificationFailures = 0
+	}
+
+	for _, d := range domains {
+		taken, err := helpers.IsSiteDomainTaken(app.DB(), d, site.ID)
+		if err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Error checking site domain: %v", err))
+			errs = utils.AddError(errs, "domain", "Could not check the domain.")
+		} else if taken {
+			errs = utils.AddError(errs, "domain", fmt.Sprintf("The domain '%s' is already used by another site, including deleted ones.", d))
+		}
+	}
+
+	return errs
+}
+
+func siteDomainNames(site *models.Site) []string {
+	domains := []string{site.Domain}
+
+	for _, d := range site.Domains {
+		domains = append(domains, d.Domain)
+	}
+
+	slices.Sort(domains[1:])
+
+	return domains
+}
+
+func normalizeSiteSetting(values []string) []string {
+	result := []string{}
+
+	for _, v := range values {
+		v = strings.ToLower(strings.TrimSpace(v))
+
+		if len(v) > 0 && !slices.Contains(result, v) {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+// Sites are only visible to the members of their organization, administrators can manage them
+func getSite(c *fiber.Ctx, deleted bool, manage bool) (*models.Site, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested site is invalid."},
+		})
+	}
+
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return nil, err
+	}
+
+	query := scope.Apply(app.DB().Model(&models.Site{}), "sites.id")
+
+	if deleted {
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+
+	site := &models.Site{}
+	if err := query.Where(&models.Site{ID: id}).Preload("Domains").First(&site).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested site does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting site: %v", err))
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get site."},
+		})
+	}
+
+	if manage {
+		if ok, err := canManageOrganization(c, site.OrganizationID); !ok {
+			return nil, err
+		}
+	}
+
+	return site, nil
+}
+
+// Saves the site, replacing its additional domains if they were changed
+func saveSite(site *models.Site, replaceDomains bool) error {
+	return app.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Save(&site).Error; err != nil {
+			return err
+		}
+
+		if !replaceDomains {
+			return nil
+		}
+
+		if err := tx.Where(&models.SiteDomain{SiteID: site.ID}).Delete(&models.SiteDomain{}).Error; err != nil {
+			return err
+		}
+
+		if len(site.Domains) < 1 {
+			return nil
+		}
+
+		for i := range site.Domains {
+			site.Domains[i].SiteID = site.ID
+		}
+
+		return tx.Omit(clause.Associations).Create(&site.Domains).Error
+	})
+}
+
+func GetAllSites(c *fiber.Ctx) error {
+	scope, err := getSiteScope(c)
+	if scope == nil {
+		return err
+	}
+
+	sites := []models.Site{}
+	query, err := helpers.SelectSiteReportStats(app.DB().Model(&models.Site{}).Preload("Domains"))
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting site statistics: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get sites."},
+		})
+	}
+	query = scope.Apply(query, "sites.id")
+
+	filters := ""
+
+	if c.QueryBool("deleted") {
+		query = query.Unscoped().Where("sites.deleted_at IS NOT NULL")
+		filters = "deleted=true"
+	}
+
+	opts := helpers.PaginatedItemOpts{
+		RouteName:   "api.sites.index",
+		TableAlias:  "sites",
+		SortColumns: []string{"domain"},
+		Filters:     filters,
+	}
+
+	return helpers.PaginateQuery(sites, query, c, opts)
+}
+
+func GetSite(c *fiber.Ctx) error {
+	site, err := getSite(c, false, false)
+	if site == nil {
+		return err
+	}
+
+	query, err := helpers.SelectSiteReportStats(app.DB().Model(&models.Site{}))
+	if err == nil {
+		err = query.Where("sites.id = ?", site.ID).Take(&site).Error
+	}
+
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting site statistics: %v", err))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": site})
+}
+
+func PostSite(c *fiber.Ctx) error {
+	input := &siteInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid site data."},
+		})
+	}
+
+	token, err := helpers.NewSiteVerificationToken()
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error generating site verification token: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create site."},
+		})
+	}
+
+	// New sites start unverified
+	site := &models.Site{VerificationToken: token}
+
+	if errs := input.apply(c, site); len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	if err := saveSite(site, true); err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error creating site: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create site."},
+		})
+	}
+
+	slog.Info(fmt.Sprintf("Site %s (%s) created by %s", site.ID, site.Domain, helpers.GetUserID(c)))
+
+	return c.Status(fiber.StatusCreated).JSON(&fiber.Map{
+		"message":      "The site has been created. Verify the ownership of its domains to start receiving reports.",
+		"data":         site,
+		"verification": helpers.GetSiteVerificationInstructions(site),
+	})
+}
+
+func PatchSite(c *fiber.Ctx) error {
+	site, err := getSite(c, false, true)
+	if site == nil {
+		return err
+	}
+
+	input := &siteInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid site data."},
+		})
+	}
+
+	if errs := input.apply(c, site); len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	if err := saveSite(site, input.Domains != nil); err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error updating site: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not update site."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The site has been updated.",
+		"data":    site,
+	})
+}
+
+// Deleted sites stop accepting reports, their existing reports are kept
+func DeleteSite(c *fiber.Ctx) error {
+	site, err := getSite(c, false, true)
+	if site == nil {
+		return err
+	}
+
+	if err := app.DB().Delete(&site).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error deleting site: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not delete site."},
+		})
+	}
+
+	slog.Info(fmt.Sprintf("Site %s (%s) deleted by %s", site.ID, site.Domain, helpers.GetUserID(c)))
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The site has been deleted.",
+	})
+}
+
+func RestoreSite(c *fiber.Ctx) error {
+	site, err := getSite(c, true, true)
+	if site == nil {
+		return err
+	}
+
+	if err := app.DB().Unscoped().Model(&site).Update("deleted_at", nil).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error restoring site: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not restore site."},
+		})
+	}
+
+	slog.Info(fmt.Sprintf("Site %s (%s) restored by %s", site.ID, site.Domain, helpers.GetUserID(c)))
+	site.DeletedAt = gorm.DeletedAt{}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The site has been restored.",
+		"data":    site,
+	})
+}
+
+func GetSiteVerification(c *fiber.Ctx) error {
+	site, err := getSite(c, false, true)
+	if site == nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"data": &fiber.Map{
+			"verified_at":           site.VerifiedAt,
+			"verification_method":   site.VerificationMethod,
+			"last_verification_at":  site.LastVerificationAt,
+			"verification_error":    site.VerificationError,
+			"verification_failures": site.VerificationFailures,
+			"instructions":          helpers.GetSiteVerificationInstructions(site),
+		},
+	})
+}
+
+// Checks the ownership of the domains of the site right away
+func VerifySite(c *fiber.Ctx) error {
+	site, err := getSite(c, false, true)
+	if site == nil {
+		return err
+	}
+
+	result, err := helpers.VerifySite(c.Context(), site)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error verifying site: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not verify site."},
+		})
+	}
+
+	if !result.Verified {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(&fiber.Map{
+			"error":        []string{result.Error},
+			"instructions": helpers.GetSiteVerificationInstructions(site),
+		})
+	}
+
+	slog.Info(fmt.Sprintf("Site %s (%s) verified by %s", site.ID, site.Domain, helpers.GetUserID(c)))
+	recomputeReportRollups(result.Buckets...)
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The site has been verified.",
+		"data":    site,
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
 	"github.com/getsentry/sentry-go"
 	"github.com/gofiber/fiber/v2"
 )
@@ -14,6 +15,11 @@ func PurgeCache(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{"error": []string{"Could not purge cache."}})
 	}
 
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		Action:     helpers.AuditCachePurged,
+		TargetType: "cache",
+	})
+
 	return c.Status(fiber.StatusNoContent).JSON(&fiber.Map{})
 }
 
@@ -0,0 +1,537 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/tasks"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type userRoleInput struct {
+	Role   string  `json:"role"`
+	SiteID *string `json:"site_id"`
+}
+
+// Superadmins manage every user, the rest only the members of their organizations
+func managedUsers(c *fiber.Ctx) (*gorm.DB, error) {
+	query := app.DB().Model(&models.User{})
+	userID := helpers.GetUserID(c)
+
+	if helpers.IsSuperAdmin(c) {
+		return query, nil
+	}
+
+	orgIDs, err := helpers.GetUserOrganizationIDs(userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(orgIDs) < 1 {
+		return query.Where("users.id = ?", userID), nil
+	}
+
+	return query.Where("users.id IN (?)", app.DB().Model(&models.OrganizationMember{}).
+		Select("user_id").
+		Where("organization_id IN ?", orgIDs)), nil
+}
+
+// Users with a higher role than the current one cannot be managed by them
+func getManagedUser(c *fiber.Ctx) (*models.User, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested user is invalid."},
+		})
+	}
+
+	query, err := managedUsers(c)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting managed users: %v", err))
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get user."},
+		})
+	}
+
+	user := &models.User{}
+	if err := query.Where(&models.User{ID: id}).Preload("Roles.Role").Preload("Roles.Site").First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested user does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting user: %v", err))
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get user."},
+		})
+	}
+
+	outranked, err := isOutrankedBy(c, user.ID)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("User roles error: %v", err))
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get user."},
+		})
+	}
+
+	if outranked {
+		return nil, c.Status(fiber.StatusForbidden).JSON(&fiber.Map{
+			"error": []string{"You cannot manage users with a higher role than yours."},
+		})
+	}
+
+	return user, nil
+}
+
+// Whether the target has a higher role than the request globally or on any site,
+// site roles of the target are compared with the rank of the request on their site
+func isOutrankedBy(c *fiber.Ctx, targetID uuid.UUID) (bool, error) {
+	roles, err := helpers.GetUserRoles(targetID)
+	if err != nil {
+		return false, err
+	}
+
+	sites := []*uuid.UUID{nil}
+
+	for _, r := range roles.Scoped() {
+		sites = append(sites, r.SiteID)
+	}
+
+	for _, siteID := range sites {
+		rank, err := helpers.GetRequestRank(c, siteID)
+		if err != nil {
+			return false, err
+		}
+
+		targetRank, err := helpers.GetUserRank(targetID, siteID)
+		if err != nil {
+			return false, err
+		}
+
+		if targetRank > rank {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+var errLastSuperAdmin = errors.New("The last superadmin cannot be removed.")
+
+// The last superadmin cannot lose the role or be deactivated, it must be called
+// in the transaction of the change as the superadmins stay locked until its end
+func isLastSuperAdmin(tx *gorm.DB, userID uuid.UUID) (bool, error) {
+	n, err := helpers.CountSuperAdmins(tx)
+	if err != nil || n > 1 {
+		return false, err
+	}
+
+	return helpers.IsActiveSuperAdmin(tx, userID)
+}
+
+func invalidateUserCache(userID uuid.UUID) {
+	if err := helpers.InvalidateUserCache(userID); err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Could not invalidate user cache: %v", err))
+	}
+}
+
+func GetAllUsers(c *fiber.Ctx) error {
+	query, err := managedUsers(c)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting managed users: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get users."},
+		})
+	}
+
+	filters := []string{}
+
+	if active := c.Query("active"); len(active) > 0 {
+		query = query.Where("users.active = ?", c.QueryBool("active"))
+		filters = append(filters, "active="+active)
+	}
+
+	if role := strings.ToLower(strings.TrimSpace(c.Query("role"))); len(role) > 0 {
+		query = query.Where("users.id IN (?)", app.DB().Model(&models.UserRole{}).
+			Select("user_roles.user_id").
+			Joins("INNER JOIN roles r ON r.id = user_roles.role_id").
+			Where("r.name = ?", role))
+		filters = append(filters, "role="+role)
+	}
+
+	if email := strings.TrimSpace(c.Query("email")); len(email) > 0 {
+		query = query.Where("users.email ILIKE ?", "%"+helpers.EscapeLike(email)+"%")
+		filters = append(filters, "email="+email)
+	}
+
+	users := []models.User{}
+	query = query.Preload("Roles.Role").Preload("Roles.Site")
+	opts := helpers.PaginatedItemOpts{
+		RouteName:   "api.users.index",
+		TableAlias:  "users",
+		SortColumns: []string{"email"},
+		Filters:     strings.Join(filters, "&"),
+	}
+
+	return helpers.PaginateQuery(users, query, c, opts)
+}
+
+func GetUser(c *fiber.Ctx) error {
+	user, err := getManagedUser(c)
+	if user == nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": user})
+}
+
+// Roles can be granted globally or on a single site, up to the role of the current user
+func PostUserRole(c *fiber.Ctx) error {
+	user, err := getManagedUser(c)
+	if user == nil {
+		return err
+	}
+
+	input := &userRoleInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid user role data."},
+		})
+	}
+
+	input.Role = strings.ToLower(strings.TrimSpace(input.Role))
+	errs := fiber.Map{}
+	userID := helpers.GetUserID(c)
+
+	role := &models.Role{}
+	if err := app.DB().Where(&models.Role{Name: input.Role}).First(&role).Error; err != nil {
+		errs = utils.AddError(errs, "role", "The role does not exist.")
+	}
+
+	var siteID *uuid.UUID
+
+	if input.SiteID != nil && len(strings.TrimSpace(*input.SiteID)) > 0 {
+		id, err := uuid.Parse(strings.TrimSpace(*input.SiteID))
+
+		switch {
+		case err != nil || !utils.IsValidUuid(id):
+			errs = utils.AddError(errs, "site_id", "The site is invalid.")
+		case helpers.RoleRank(input.Role) == helpers.RoleRank("superadmin"):
+			errs = utils.AddError(errs, "site_id", "The superadmin role cannot be limited to a site.")
+		default:
+			scope, err := helpers.GetSiteScope(c)
+			if err != nil || !scope.Allows(id) || app.DB().Where(&models.Site{ID: id}).First(&models.Site{}).Error != nil {
+				errs = utils.AddError(errs, "site_id", "The site does not exist.")
+			}
+
+			siteID = &id
+		}
+	}
+
+	if len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	rank, err := helpers.GetRequestRank(c, siteID)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("User roles error: %v", err))
+	}
+
+	if helpers.RoleRank(role.Name) > rank {
+		return c.Status(fiber.StatusForbidden).JSON(&fiber.Map{
+			"error": []string{"You cannot grant a role higher than yours."},
+		})
+	}
+
+	userRole := &models.UserRole{
+		UserID:      user.ID,
+		RoleID:      role.ID,
+		SiteID:      siteID,
+		CreatedByID: userID,
+		UpdatedByID: userID,
+	}
+	query := app.DB().Where(&models.UserRole{UserID: user.ID, RoleID: role.ID})
+
+	if siteID == nil {
+		query = query.Where("site_id IS NULL")
+	} else {
+		query = query.Where("site_id = ?", siteID)
+	}
+
+	if err := query.Omit(clause.Associations).FirstOrCreate(&userRole).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error assigning user role: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not assign role."},
+		})
+	}
+
+	invalidateUserCache(user.ID)
+	slog.Info(fmt.Sprintf("Role %s granted to %s by %s", role.Name, user.ID, userID))
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		Action:     helpers.AuditRoleGranted,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		After:      fiber.Map{"role": role.Name, "site_id": siteID},
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(&fiber.Map{
+		"message": "The role has been granted.",
+		"data":    userRole,
+	})
+}
+
+func DeleteUserRole(c *fiber.Ctx) error {
+	user, err := getManagedUser(c)
+	if user == nil {
+		return err
+	}
+
+	id, err := uuid.Parse(c.Params("role_id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested role is invalid."},
+		})
+	}
+
+	userRole := &models.UserRole{}
+	if err := app.DB().Where(&models.UserRole{ID: id, UserID: user.ID}).Preload("Role").First(&userRole).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+			"error": []string{"The requested role does not exist."},
+		})
+	}
+
+	userID := helpers.GetUserID(c)
+
+	rank, err := helpers.GetRequestRank(c, userRole.SiteID)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("User roles error: %v", err))
+	}
+
+	if helpers.RoleRank(userRole.Role.Name) > rank {
+		return c.Status(fiber.StatusForbidden).JSON(&fiber.Map{
+			"error": []string{"You cannot remove a role higher than yours."},
+		})
+	}
+
+	if err := app.DB().Transaction(func(tx *gorm.DB) error {
+		if userRole.SiteID == nil && helpers.RoleRank(userRole.Role.Name) == helpers.RoleRank("superadmin") {
+			last, err := isLastSuperAdmin(tx, user.ID)
+			if err != nil {
+				return err
+			}
+
+			if last {
+				return errLastSuperAdmin
+			}
+		}
+
+		if err := tx.Model(&userRole).Updates(&models.UserRole{UpdatedByID: userID, DeletedByID: &userID}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&userRole).Error
+	}); err != nil {
+		if errors.Is(err, errLastSuperAdmin) {
+			return c.Status(fiber.StatusConflict).JSON(&fiber.Map{
+				"error": []string{"The last superadmin cannot lose the role."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error removing user role: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not remove role."},
+		})
+	}
+
+	invalidateUserCache(user.ID)
+	slog.Info(fmt.Sprintf("Role %s removed from %s by %s", userRole.Role.Name, user.ID, userID))
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		Action:     helpers.AuditRoleRemoved,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		Before:     fiber.Map{"role": userRole.Role.Name, "site_id": userRole.SiteID},
+	})
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The role has been removed.",
+	})
+}
+
+// Deactivated users cannot log in and their sessions end right away
+func DeactivateUser(c *fiber.Ctx) error {
+	user, err := getManagedUser(c)
+	if user == nil {
+		return err
+	}
+
+	userID := helpers.GetUserID(c)
+
+	if user.ID == userID {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"You cannot deactivate your own account."},
+		})
+	}
+
+	active := false
+
+	if err := app.DB().Transaction(func(tx *gorm.DB) error {
+		last, err := isLastSuperAdmin(tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		if last {
+			return errLastSuperAdmin
+		}
+
+		return tx.Model(&user).Select("active").Updates(&models.User{Active: &active}).Error
+	}); err != nil {
+		if errors.Is(err, errLastSuperAdmin) {
+			return c.Status(fiber.StatusConflict).JSON(&fiber.Map{
+				"error": []string{"The last superadmin cannot be deactivated."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error deactivating user: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not deactivate user."},
+		})
+	}
+
+	user.Active = &active
+	invalidateUserCache(user.ID)
+	slog.Info(fmt.Sprintf("User %s deactivated by %s", user.ID, userID))
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		Action:     helpers.AuditUserDeactivated,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		Before:     fiber.Map{"active": true},
+		After:      fiber.Map{"active": false},
+	})
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The user has been deactivated.",
+		"data":    user,
+	})
+}
+
+func ReactivateUser(c *fiber.Ctx) error {
+	user, err := getManagedUser(c)
+	if user == nil {
+		return err
+	}
+
+	// Registrations are activated by reviewing them instead
+	if err := app.DB().Where(&models.UserActivation{UserID: user.ID}).Where("reviewed_by_id IS NULL").First(&models.UserActivation{}).Error; err == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The registration of the user has not been reviewed yet."},
+		})
+	}
+
+	active := true
+
+	if err := app.DB().Model(&user).Select("active").Updates(&models.User{Active: &active}).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error reactivating user: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not reactivate user."},
+		})
+	}
+
+	user.Active = &active
+	invalidateUserCache(user.ID)
+	slog.Info(fmt.Sprintf("User %s reactivated by %s", user.ID, helpers.GetUserID(c)))
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		Action:     helpers.AuditUserReactivated,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		Before:     fiber.Map{"active": false},
+		After:      fiber.Map{"active": true},
+	})
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The user has been reactivated.",
+		"data":    user,
+	})
+}
+
+// The user must set a new password through the link sent by email before logging in again
+func ResetUserPassword(c *fiber.Ctx) error {
+	user, err := getManagedUser(c)
+	if user == nil {
+		return err
+	}
+
+	mustChangePass := true
+	recovery := &models.AccountRecovery{}
+
+	if err := app.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Select("must_change_password").Updates(&models.User{MustChangePassword: &mustChangePass}).Error; err != nil {
+			return err
+		}
+
+		r, err := helpers.NewAccountRecovery(tx, user.ID)
+		recovery = r
+
+		return err
+	}); err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error forcing password reset: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not reset the password of the user."},
+		})
+	}
+
+	invalidateUserCache(user.ID)
+
+	if err := tasks.NewEmail(
+		helpers.EmailOpts{
+			Subject:      "Password change request",
+			TemplateName: "user_password_change_request",
+			ToList:       []string{user.Email},
+		},
+		map[string]interface{}{
+			"UserName":    user.GetFullName(),
+			"RecoveryURL": recovery.URL(),
+		},
+	); err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error sending email: %v", err))
+	}
+
+	slog.Info(fmt.Sprintf("Password reset of %s forced by %s", user.ID, helpers.GetUserID(c)))
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		Action:     helpers.AuditPasswordReset,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		After:      fiber.Map{"must_change_password": true},
+	})
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The user must change their password before logging in again.",
+	})
+}
@@ -21,12 +21,42 @@ type userActivationInput struct {
 	Reason   *string `json:"reason"`
 }
 
-func GetAllInactiveUsers(c *fiber.Ctx) error {
-	users := []models.UserActivation{}
+// Organization administrators review the registrations invited to their organizations
+func reviewableActivations(c *fiber.Ctx) (*gorm.DB, error) {
 	query := app.DB().Model(&models.UserActivation{}).
 		Joins("INNER JOIN users u ON user_activations.user_id = u.id").
-		Where("u.deleted_at IS NULL").
-		Preload("User").Preload("ReviewedBy")
+		Where("u.deleted_at IS NULL")
+
+	if helpers.IsSuperAdmin(c) {
+		return query, nil
+	}
+
+	orgIDs, err := helpers.GetUserOrganizationIDs(helpers.GetUserID(c), helpers.OrganizationAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(orgIDs) < 1 {
+		return query.Where("1 = 0"), nil
+	}
+
+	return query.Where("user_activations.invitation_id IN (?)", app.DB().Model(&models.OrganizationInvitation{}).
+		Select("id").
+		Where("organization_id IN ?", orgIDs)), nil
+}
+
+func GetAllInactiveUsers(c *fiber.Ctx) error {
+	query, err := reviewableActivations(c)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting user activations: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get user activations."},
+		})
+	}
+
+	users := []models.UserActivation{}
+	query = query.Preload("User").Preload("ReviewedBy").Preload("Invitation.Organization")
 	opts := helpers.PaginatedItemOpts{RouteName: "api.activations.users.index", TableAlias: helpers.GetModelSchema(&models.UserActivation{}).Table}
 
 	return helpers.PaginateQuery(users, query, c, opts)
@@ -57,6 +87,23 @@ func UpdateUserActivation(c *fiber.Ctx) error {
 		})
 	}
 
+	userID := helpers.GetUserID(c)
+
+	reviewable, err := reviewableActivations(c)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting user activations: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not activate user account."},
+		})
+	}
+
+	if err := reviewable.Where("user_activations.user_id = ?", user.ID).First(&models.UserActivation{}).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+			"error": []string{"The requested user does not exist or you cannot review their registration."},
+		})
+	}
+
 	if utils.IsValidUuid(user.ID) && (user.Active != nil && *user.Active) {
 		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
 			"error": []string{"The requested user account is already active."},
@@ -76,11 +123,10 @@ func UpdateUserActivation(c *fiber.Ctx) error {
 		})
 	}
 
-	userID := helpers.GetUserID(c)
 	userActivation := &models.UserActivation{UserID: user.ID}
 
 	if err := app.DB().Transaction(func(tx *gorm.DB) error {
-		if err := tx.Where(&userActivation).Preload("User").First(&userActivation).Error; err != nil {
+		if err := tx.Where(&userActivation).Preload("User").Preload("Invitation").First(&userActivation).Error; err != nil {
 			slog.Error(fmt.Sprintf("Error getting user account pending activation: %v", err))
 			return err
 		}
@@ -112,6 +158,13 @@ func UpdateUserActivation(c *fiber.Ctx) error {
 				slog.Error(fmt.Sprintf("Error assigning user role: %v", err))
 				return err
 			}
+
+			if userActivation.Invitation != nil && userActivation.Invitation.AcceptedAt == nil {
+				if err := helpers.AcceptOrganizationInvitation(tx, userActivation.Invitation, userActivation.User.ID); err != nil {
+					slog.Error(fmt.Sprintf("Error joining organization: %v", err))
+					return err
+				}
+			}
 		} else {
 			if err := tx.Delete(&userActivation.User).Error; err != nil {
 				slog.Error(fmt.Sprintf("Error deleting user account: %v", err))
@@ -133,6 +186,28 @@ func UpdateUserActivation(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := helpers.InvalidateUserCache(user.ID); err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Could not invalidate user cache: %v", err))
+	}
+
+	helpers.RecordAudit(c, helpers.AuditEntry{
+		Action:     helpers.AuditActivationReviewed,
+		TargetType: "user",
+		TargetID:   user.ID.String(),
+		Before:     fiber.Map{"active": false},
+		After:      fiber.Map{"active": approved, "approved": approved, "reason": input.Reason},
+	})
+
+	if approved {
+		active := true
+		user.Active = &active
+
+		if err := tasks.DispatchWebhookEvent(helpers.WebhookUserActivated, user); err != nil {
+			slog.Error(fmt.Sprintf("Error dispatching webhook event: %v", err))
+		}
+	}
+
 	userName := user.GetFullName()
 	opts := helpers.EmailOpts{
 		Subject:      "User account registration status",
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/testutil"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/valyala/fasthttp"
+	"gorm.io/gorm"
+)
+
+type testUserRole struct {
+	Name   string
+	SiteID *uuid.UUID
+}
+
+func createTestUser(t *testing.T, db *gorm.DB, roles ...testUserRole) *models.User {
+	t.Helper()
+
+	active := true
+	user := &models.User{Email: fmt.Sprintf("%s@example.com", uuid.NewString()), Password: "-", Active: &active}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range roles {
+		role := &models.Role{}
+		if err := db.Where(&models.Role{Name: r.Name}).First(&role).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		userRole := &models.UserRole{UserID: user.ID, RoleID: role.ID, SiteID: r.SiteID, CreatedByID: user.ID, UpdatedByID: user.ID}
+		if err := db.Omit("User", "Role", "Site", "CreatedBy", "UpdatedBy", "DeletedBy").Create(&userRole).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Cleanup(func() {
+		db.Unscoped().Where(&models.UserRole{UserID: user.ID}).Delete(&models.UserRole{})
+		db.Unscoped().Delete(&user)
+		_ = helpers.InvalidateUserCache(user.ID)
+	})
+
+	return user
+}
+
+// Request of the user through an API token limited to the given roles, if any
+func newTestUserCtx(t *testing.T, user *models.User, roles ...string) *fiber.Ctx {
+	t.Helper()
+
+	app := fiber.New()
+	c := app.AcquireCtx(&fasthttp.RequestCtx{})
+	t.Cleanup(func() {
+		app.ReleaseCtx(c)
+	})
+
+	helpers.SetRequestAPIToken(c, &models.APIToken{UserID: user.ID, Roles: roles})
+
+	return c
+}
+
+// Site roles of the target count on their site, not only the global ones
+func TestIsOutrankedBy(t *testing.T) {
+	db := testutil.DB(t)
+
+	site := &models.Site{Domain: fmt.Sprintf("%s.example.com", uuid.NewString())}
+	if err := db.Create(&site).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Unscoped().Delete(&site)
+	})
+
+	target := createTestUser(t, db, testUserRole{Name: "viewer"}, testUserRole{Name: "admin", SiteID: &site.ID})
+
+	tests := []struct {
+		name       string
+		roles      []testUserRole
+		tokenRoles []string
+		outranked  bool
+	}{
+		{"global manager", []testUserRole{{Name: "manager"}}, nil, true},
+		{"global admin", []testUserRole{{Name: "admin"}}, nil, false},
+		{"manager and site admin", []testUserRole{{Name: "manager"}, {Name: "admin", SiteID: &site.ID}}, nil, false},
+		{"global viewer", []testUserRole{{Name: "viewer"}}, nil, true},
+		{"global admin with a viewer token", []testUserRole{{Name: "admin"}}, []string{"viewer"}, true},
+		{"global admin with an admin token", []testUserRole{{Name: "admin"}}, []string{"admin"}, false},
+	}
+
+	for _, tt := range tests {
+		user := createTestUser(t, db, tt.roles...)
+
+		outranked, err := isOutrankedBy(newTestUserCtx(t, user, tt.tokenRoles...), target.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if outranked != tt.outranked {
+			t.Errorf("%s: got outranked %v, want %v", tt.name, outranked, tt.outranked)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type webhookInput struct {
+	Name    *string   `json:"name"`
+	URL     *string   `json:"url"`
+	Kind    *string   `json:"kind"`
+	Events  *[]string `json:"events"`
+	Enabled *bool     `json:"enabled"`
+}
+
+// Applies the input to the webhook, returning the validation errors of the result
+func (input *webhookInput) apply(webhook *models.Webhook) fiber.Map {
+	errs := fiber.Map{}
+
+	if input.Name != nil {
+		webhook.Name = strings.TrimSpace(*input.Name)
+	}
+
+	if input.URL != nil {
+		webhook.URL = strings.TrimSpace(*input.URL)
+	}
+
+	if input.Kind != nil {
+		webhook.Kind = strings.ToLower(strings.TrimSpace(*input.Kind))
+	}
+
+	if input.Events != nil {
+		webhook.Events = models.WebhookEvents{}
+
+		for _, e := range *input.Events {
+			e = strings.ToLower(strings.TrimSpace(e))
+
+			if !slices.Contains(helpers.WebhookEventTypes(), e) {
+				errs = utils.AddError(errs, "events", fmt.Sprintf("The event '%s' is invalid. It must be one of: %s.", e, strings.Join(helpers.WebhookEventTypes(), ", ")))
+				continue
+			}
+
+			if !slices.Contains(webhook.Events, e) {
+				webhook.Events = append(webhook.Events, e)
+			}
+		}
+	}
+
+	if input.Enabled != nil {
+		// Enabling a webhook again gives it a clean slate
+		if *input.Enabled && (webhook.Enabled == nil || !*webhook.Enabled) {
+			webhook.FailureCount = 0
+			webhook.DisabledAt = nil
+		}
+
+		webhook.Enabled = input.Enabled
+	}
+
+	if n := utf8.RuneCountInString(webhook.Name); n < 1 || n > 255 {
+		errs = utils.AddError(errs, "name", "The name must be between 1 and 255 characters long.")
+	}
+
+	if len(webhook.URL) > 2048 {
+		errs = utils.AddError(errs, "url", "The URL must be at most 2048 characters long.")
+	} else if err := helpers.ValidateWebhookURL(webhook.URL); err != nil {
+		errs = utils.AddError(errs, "url", "The URL is invalid.")
+	}
+
+	if !slices.Contains(helpers.WebhookKinds(), webhook.Kind) {
+		errs = utils.AddError(errs, "kind", fmt.Sprintf("The kind must be one of: %s.", strings.Join(helpers.WebhookKinds(), ", ")))
+	}
+
+	// Personal webhooks receive the events chosen in the notification preferences
+	if webhook.UserID == nil && len(webhook.Events) < 1 {
+		errs = utils.AddError(errs, "events", "At least one event is required.")
+	}
+
+	return errs
+}
+
+func getWebhook(c *fiber.Ctx) (*models.Webhook, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil || !utils.IsValidUuid(id) {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The requested webhook is invalid."},
+		})
+	}
+
+	webhook := &models.Webhook{}
+	if err := app.DB().Where(&models.Webhook{ID: id}).Where("user_id IS NULL").First(&webhook).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, c.Status(fiber.StatusNotFound).JSON(&fiber.Map{
+				"error": []string{"The requested webhook does not exist."},
+			})
+		}
+
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error getting webhook: %v", err))
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not get webhook."},
+		})
+	}
+
+	return webhook, nil
+}
+
+func GetAllWebhooks(c *fiber.Ctx) error {
+	webhooks := []models.Webhook{}
+	query := app.DB().Model(&models.Webhook{}).Where("user_id IS NULL")
+	opts := helpers.PaginatedItemOpts{RouteName: "api.webhooks.index", SortColumns: []string{"name"}}
+
+	return helpers.PaginateQuery(webhooks, query, c, opts)
+}
+
+func GetWebhook(c *fiber.Ctx) error {
+	webhook, err := getWebhook(c)
+	if webhook == nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{"data": webhook})
+}
+
+func PostWebhook(c *fiber.Ctx) error {
+	input := &webhookInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid webhook data."},
+		})
+	}
+
+	secret, err := helpers.NewWebhookSecret()
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error generating webhook secret: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create webhook."},
+		})
+	}
+
+	enabled := true
+	webhook := &models.Webhook{
+		Kind:        helpers.WebhookJSON,
+		Secret:      secret,
+		Enabled:     &enabled,
+		CreatedByID: helpers.GetUserID(c),
+	}
+
+	if errs := input.apply(webhook); len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	if err := app.DB().Omit(clause.Associations).Create(&webhook).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error creating webhook: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not create webhook."},
+		})
+	}
+
+	// The secret is only shown once
+	return c.Status(fiber.StatusCreated).JSON(&fiber.Map{
+		"message": "The webhook has been created. Store the secret in a safe place, it will not be shown again.",
+		"data":    webhook,
+		"secret":  webhook.Secret,
+	})
+}
+
+func PatchWebhook(c *fiber.Ctx) error {
+	webhook, err := getWebhook(c)
+	if webhook == nil {
+		return err
+	}
+
+	input := &webhookInput{}
+	if err := c.BodyParser(&input); err != nil {
+		slog.Error(fmt.Sprintf("Error parsing input data: %v", err))
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"Invalid webhook data."},
+		})
+	}
+
+	if errs := input.apply(webhook); len(errs) > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": errs,
+		})
+	}
+
+	if err := app.DB().Omit(clause.Associations).Save(&webhook).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error updating webhook: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not update webhook."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The webhook has been updated.",
+		"data":    webhook,
+	})
+}
+
+func DeleteWebhook(c *fiber.Ctx) error {
+	webhook, err := getWebhook(c)
+	if webhook == nil {
+		return err
+	}
+
+	if err := app.DB().Delete(&webhook).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error deleting webhook: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not delete webhook."},
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": "The webhook has been deleted.",
+	})
+}
+
+// Sends a ping event right away, without retries
+func TestWebhook(c *fiber.Ctx) error {
+	webhook, err := getWebhook(c)
+	if webhook == nil {
+		return err
+	}
+
+	event, err := helpers.NewWebhookEvent(helpers.WebhookPing, &fiber.Map{
+		"webhook_id": webhook.ID,
+		"message":    "This is a test event.",
+	})
+	if err != nil {
+		sentry.CaptureException(err)
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not send test event."},
+		})
+	}
+
+	delivery, err := helpers.DeliverWebhook(c.Context(), webhook, event, 1)
+	if delivery == nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error sending test event: %v", err))
+		return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+			"error": []string{"Could not send test event."},
+		})
+	}
+
+	msg := "The test event has been delivered."
+
+	if !delivery.Success {
+		msg = "The test event could not be delivered."
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+		"message": msg,
+		"data":    delivery,
+	})
+}
+
+func GetAllWebhookDeliveries(c *fiber.Ctx) error {
+	webhook, err := getWebhook(c)
+	if webhook == nil {
+		return err
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	query := app.DB().Model(&models.WebhookDelivery{}).Where(&models.WebhookDelivery{WebhookID: webhook.ID})
+	opts := helpers.PaginatedItemOpts{RouteName: "api.webhooks.deliveries.index"}
+
+	return helpers.PaginateQuery(deliveries, query, c, opts)
+}
@@ -0,0 +1,242 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/models"
+	"github.com/getsentry/sentry-go"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	AlertThreshold string = "threshold"
+	AlertSpike     string = "spike"
+	AlertNewHost   string = "new_host"
+)
+
+const (
+	maxAlertWindowMinutes int = 7 * 24 * 60
+	maxAlertHostsListed   int = 10
+)
+
+func AlertKinds() []string {
+	return []string{AlertThreshold, AlertSpike, AlertNewHost}
+}
+
+func MaxAlertWindowMinutes() int {
+	return maxAlertWindowMinutes
+}
+
+// Evaluates the enabled alert rules, returning the events that were fired
+func EvaluateAlertRules(ctx context.Context, now time.Time) ([]models.AlertEvent, error) {
+	ids := []string{}
+	enabled := true
+
+	if err := app.DB().WithContext(ctx).Model(&models.AlertRule{}).
+		Where(&models.AlertRule{Enabled: &enabled}).
+		Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("Could not get alert rules: %w", err)
+	}
+
+	events := []models.AlertEvent{}
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return events, err
+		}
+
+		event, err := evaluateAlertRule(ctx, id, now)
+		if err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not evaluate alert rule %s: %v", id, err))
+			continue
+		}
+
+		if event != nil {
+			events = append(events, *event)
+		}
+	}
+
+	return events, nil
+}
+
+func evaluateAlertRule(ctx context.Context, id string, now time.Time) (*models.AlertEvent, error) {
+	var event *models.AlertEvent
+
+	err := app.DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rule := &models.AlertRule{}
+
+		// Rules being evaluated by another worker are skipped
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: clause.CurrentTable}, Options: "SKIP LOCKED"}).
+			Preload("Site").
+			Where("id = ?", id).
+			First(&rule).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+
+			return err
+		}
+
+		if rule.LastFiredAt != nil && now.Before(rule.LastFiredAt.Add(time.Duration(rule.CooldownMinutes)*time.Minute)) {
+			return nil
+		}
+
+		var err error
+
+		switch rule.Kind {
+		case AlertThreshold:
+			event, err = evaluateThresholdRule(tx, rule, now)
+		case AlertSpike:
+			event, err = evaluateSpikeRule(tx, rule, now)
+		case AlertNewHost:
+			event, err = evaluateNewHostRule(tx, rule, now)
+		default:
+			err = fmt.Errorf("Invalid alert rule kind '%s'.", rule.Kind)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		changes := map[string]interface{}{"last_evaluated_at": now}
+
+		if event != nil {
+			event.RuleID = rule.ID
+			event.SiteID = rule.SiteID
+			event.Kind = rule.Kind
+
+			if err := tx.Omit(clause.Associations).Create(&event).Error; err != nil {
+				return err
+			}
+
+			event.Rule = *rule
+			event.Site = rule.Site
+			changes["last_fired_at"] = now
+		}
+
+		return tx.Model(&rule).Omit(clause.Associations).Updates(changes).Error
+	})
+
+	return event, err
+}
+
+func countAlertReports(tx *gorm.DB, rule *models.AlertRule, from time.Time, to time.Time) (int64, error) {
+	var count int64
+
+	query := tx.Model(&models.Report{}).Where("site_id = ? AND NOT quarantined AND created_at >= ? AND created_at < ?", rule.SiteID, from, to)
+
+	if rule.EffectiveDirective != nil {
+		query = query.Where("effective_directive = ?", *rule.EffectiveDirective)
+	}
+
+	return count, query.Count(&count).Error
+}
+
+func alertDirective(rule *models.AlertRule) string {
+	if rule.EffectiveDirective == nil {
+		return "violations"
+	}
+
+	return fmt.Sprintf("%s violations", *rule.EffectiveDirective)
+}
+
+func evaluateThresholdRule(tx *gorm.DB, rule *models.AlertRule, now time.Time) (*models.AlertEvent, error) {
+	window := time.Duration(rule.WindowMinutes) * time.Minute
+
+	count, err := countAlertReports(tx, rule, now.Add(-window), now)
+	if err != nil {
+		return nil, err
+	}
+
+	if count <= rule.Threshold {
+		return nil, nil
+	}
+
+	return &models.AlertEvent{
+		Value:   float64(count),
+		Message: fmt.Sprintf("%d %s in the last %d minutes, above the threshold of %d.", count, alertDirective(rule), rule.WindowMinutes, rule.Threshold),
+	}, nil
+}
+
+// Compares the window against the same window one week before
+func evaluateSpikeRule(tx *gorm.DB, rule *models.AlertRule, now time.Time) (*models.AlertEvent, error) {
+	window := time.Duration(rule.WindowMinutes) * time.Minute
+	lastWeek := now.AddDate(0, 0, -7)
+
+	current, err := countAlertReports(tx, rule, now.Add(-window), now)
+	if err != nil {
+		return nil, err
+	}
+
+	if current < max(rule.MinCount, 1) {
+		return nil, nil
+	}
+
+	baseline, err := countAlertReports(tx, rule, lastWeek.Add(-window), lastWeek)
+	if err != nil {
+		return nil, err
+	}
+
+	b := float64(baseline)
+	event := &models.AlertEvent{Value: float64(current), Baseline: &b}
+
+	if baseline == 0 {
+		event.Message = fmt.Sprintf("%d %s in the last %d minutes, none in the same period last week.", current, alertDirective(rule), rule.WindowMinutes)
+		return event, nil
+	}
+
+	increase := (current - baseline) * 100 / baseline
+	if increase < rule.Threshold {
+		return nil, nil
+	}
+
+	event.Message = fmt.Sprintf("%d %s in the last %d minutes, up %d%% from %d in the same period last week.", current, alertDirective(rule), rule.WindowMinutes, increase, baseline)
+
+	return event, nil
+}
+
+// Looks for blocked hosts whose first violation happened since the last evaluation
+func evaluateNewHostRule(tx *gorm.DB, rule *models.AlertRule, now time.Time) (*models.AlertEvent, error) {
+	since := rule.CreatedAt
+
+	if rule.LastEvaluatedAt != nil {
+		since = *rule.LastEvaluatedAt
+	}
+
+	hosts := []string{}
+	query := tx.Model(&models.ViolationGroup{}).
+		Select("blocked_host").
+		Where("site_id = ? AND blocked_host <> ''", rule.SiteID)
+
+	if rule.EffectiveDirective != nil {
+		query = query.Where("effective_directive = ?", *rule.EffectiveDirective)
+	}
+
+	if err := query.Group("blocked_host").
+		Having("min(first_seen_at) >= ? AND min(first_seen_at) < ?", since, now).
+		Order("min(first_seen_at)").
+		Pluck("blocked_host", &hosts).Error; err != nil {
+		return nil, err
+	}
+
+	if len(hosts) < 1 {
+		return nil, nil
+	}
+
+	listed := hosts[:min(len(hosts), maxAlertHostsListed)]
+	msg := fmt.Sprintf("First %s from %d new blocked hosts: %s", alertDirective(rule), len(hosts), strings.Join(listed, ", "))
+
+	if len(hosts) > len(listed) {
+		msg += fmt.Sprintf(" and %d more", len(hosts)-len(listed))
+	}
+
+	return &models.AlertEvent{Value: float64(len(hosts)), Message: msg + "."}, nil
+}
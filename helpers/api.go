@@ -1,14 +1,22 @@
 package helpers
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
 	"time"
 
 	"alfredoramos.mx/csp-reporter/app"
 	"alfredoramos.mx/csp-reporter/utils"
 	"github.com/getsentry/sentry-go"
+	jose_jwt "github.com/go-jose/go-jose/v4/jwt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -25,30 +33,111 @@ type PaginatedItem interface {
 	GetCreatedAt() time.Time
 }
 
+// Items sorted by search relevance
+type RankedItem interface {
+	GetRank() float64
+}
+
+type HighlightableItem interface {
+	Highlight(q string)
+}
+
 type PaginatedItemOpts struct {
 	RouteName  string
 	TableAlias string
+	// Columns the results can be sorted by, besides the creation date.
+	// They must not be nullable, the cursor has no position for NULL values.
+	SortColumns []string
+	// Fingerprint of the filters applied to the query
+	Filters string
+	// Search query and relevance expression
+	Search   string
+	RankExpr string
+	RankArgs []any
+
+	sortBy    string
+	sortOrder string
 }
 
+// Keyset pagination column
+type paginationKey struct {
+	Expr string
+	Desc bool
+}
+
+const (
+	totalEstimate   string = "estimate"
+	sortByCreatedAt string = "created_at"
+	sortByRank      string = "rank"
+)
+
 func PaginateQuery[T PaginatedItem](items []T, query *gorm.DB, c *fiber.Ctx, opts PaginatedItemOpts) error {
 	perPage := c.Query("per_page")
-	sortOrder := c.Query("sort_order", "desc")
+	sortOrder := strings.ToLower(c.Query("sort_order", DESC))
+	sortBy := strings.ToLower(c.Query("sort_by", opts.defaultSortBy()))
 	cursor := c.Query("cursor")
+	includeTotal := strings.ToLower(c.Query("include_total", "false"))
 
 	limit := utils.GetPaginationSize(perPage)
 
 	isFirstPage := len(cursor) < 1
 	pointsNext := false
 
-	query, pointsNext, err := GetPaginationQuery(query, pointsNext, cursor, sortOrder, opts.TableAlias)
+	if sortOrder != ASC && sortOrder != DESC {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The sort order must be one of: asc, desc."},
+		})
+	}
+
+	if sortColumns := opts.sortColumns(); !slices.Contains(sortColumns, sortBy) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{fmt.Sprintf("The sort column must be one of: %s.", strings.Join(sortColumns, ", "))},
+		})
+	}
+
+	if !slices.Contains([]string{"true", "false", totalEstimate}, includeTotal) {
+		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+			"error": []string{"The total mode must be one of: true, false, estimate."},
+		})
+	}
+
+	opts.sortBy = sortBy
+	opts.sortOrder = sortOrder
+
+	var total *int64
+
+	// Counted before the pagination conditions are added
+	if includeTotal != "false" {
+		n, err := countPaginatedItems[T](query, includeTotal == totalEstimate)
+		if err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Error counting results: %v", err))
+			return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+				"error": []string{"Could not count results."},
+			})
+		}
+
+		total = &n
+	}
+
+	query, pointsNext, err := GetPaginationQuery(query, pointsNext, cursor, sortBy, sortOrder, opts)
 	if err != nil {
-		sentry.CaptureException(err)
+		if errors.Is(err, jose_jwt.ErrExpired) {
+			return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
+				"error": []string{"The cursor has expired."},
+			})
+		}
+
 		slog.Error(fmt.Sprintf("Error paginating results: %v", err))
 		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
 			"error": []string{"Could not paginate results."},
 		})
 	}
 
+	if len(opts.RankExpr) > 0 {
+		query = query.Select(fmt.Sprintf("%s*, %s AS rank", opts.alias(), opts.RankExpr), opts.RankArgs...)
+	}
+
 	if err := query.Limit(limit + 1).Find(&items).Error; err != nil {
 		slog.Error(fmt.Sprintf("Error getting paginated results: %v", err))
 		return c.Status(fiber.StatusBadRequest).JSON(&fiber.Map{
@@ -66,98 +155,309 @@ func PaginateQuery[T PaginatedItem](items []T, query *gorm.DB, c *fiber.Ctx, opt
 		items = utils.Reverse(items)
 	}
 
-	pageInfo := CalculatePagination(isFirstPage, hasPagination, limit, items, pointsNext, opts.RouteName, c)
+	if len(opts.Search) > 0 {
+		for i := range items {
+			if item, ok := any(&items[i]).(HighlightableItem); ok {
+				item.Highlight(opts.Search)
+			}
+		}
+	}
+
+	pageInfo := CalculatePagination(isFirstPage, hasPagination, limit, items, pointsNext, opts, c)
 
-	return c.Status(fiber.StatusOK).JSON(&fiber.Map{
+	response := fiber.Map{
 		"data": items,
 		"next": pageInfo.NextCursor,
 		"prev": pageInfo.PrevCursor,
-	})
+	}
+
+	if total != nil {
+		response["total"] = *total
+		response["total_estimated"] = includeTotal == totalEstimate
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&response)
+}
+
+// Counts all the items matching the query, or estimates the count from the query plan
+func countPaginatedItems[T PaginatedItem](query *gorm.DB, estimate bool) (int64, error) {
+	var total int64
+
+	if !estimate {
+		err := app.DB().Table("(?) AS t", query.Session(&gorm.Session{}).Select("1")).Count(&total).Error
+		return total, err
+	}
+
+	stmt := query.Session(&gorm.Session{DryRun: true}).Find(&[]T{}).Statement
+	if stmt.Error != nil {
+		return 0, stmt.Error
+	}
+
+	sqlDB, err := app.DB().DB()
+	if err != nil {
+		return 0, err
+	}
+
+	raw := ""
+	if err := sqlDB.QueryRow("EXPLAIN (FORMAT JSON) "+stmt.SQL.String(), stmt.Vars...).Scan(&raw); err != nil {
+		return 0, err
+	}
+
+	plan := []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}{}
+
+	if err := json.Unmarshal([]byte(raw), &plan); err != nil {
+		return 0, err
+	}
+
+	if len(plan) < 1 {
+		return 0, errors.New("The query plan is empty.")
+	}
+
+	return int64(plan[0].Plan.Rows), nil
 }
 
-func GetPaginationQuery(query *gorm.DB, pointsNext bool, cursor string, sortOrder string, tableAlias string) (*gorm.DB, bool, error) {
-	alias := ""
+func (o PaginatedItemOpts) alias() string {
+	if len(o.TableAlias) > 0 {
+		return o.TableAlias + "."
+	}
+
+	return ""
+}
 
-	if len(tableAlias) > 0 {
-		alias = tableAlias + "."
+func (o PaginatedItemOpts) defaultSortBy() string {
+	// Most relevant results first when searching
+	if len(o.RankExpr) > 0 {
+		return sortByRank
 	}
 
+	return sortByCreatedAt
+}
+
+func (o PaginatedItemOpts) sortColumns() []string {
+	columns := []string{sortByCreatedAt}
+
+	if len(o.RankExpr) > 0 {
+		columns = append(columns, sortByRank)
+	}
+
+	for _, c := range o.SortColumns {
+		if !slices.Contains(columns, c) {
+			columns = append(columns, c)
+		}
+	}
+
+	return columns
+}
+
+// The creation date and ID are always used as tiebreakers. Every key follows
+// the sort order, so the rows after the cursor are a contiguous range.
+func (o PaginatedItemOpts) paginationKeys(sortBy string, sortOrder string) []paginationKey {
+	desc := sortOrder == DESC
+	keys := []paginationKey{}
+
+	switch sortBy {
+	case sortByCreatedAt:
+	case sortByRank:
+		keys = append(keys, paginationKey{Expr: o.RankExpr, Desc: desc})
+	default:
+		keys = append(keys, paginationKey{Expr: o.alias() + sortBy, Desc: desc})
+	}
+
+	return append(keys,
+		paginationKey{Expr: o.alias() + "created_at", Desc: desc},
+		paginationKey{Expr: o.alias() + "id", Desc: desc},
+	)
+}
+
+func GetPaginationQuery(query *gorm.DB, pointsNext bool, cursor string, sortBy string, sortOrder string, opts PaginatedItemOpts) (*gorm.DB, bool, error) {
+	keys := opts.paginationKeys(sortBy, sortOrder)
+
 	if len(cursor) > 0 {
-		decodedCursor, err := utils.DecodeCursor(cursor)
+		decodedCursor, err := utils.DecodeCursor(cursor, opts.RouteName)
 		if err != nil {
-			sentry.CaptureException(err)
 			slog.Error(fmt.Sprintf("Error decoding cursor: %v", err))
 			return nil, pointsNext, err
 		}
 
+		// Cursors are only valid for the filters and sorting they were created with
+		filters, _ := decodedCursor["filters"].(string)
+		if filters != opts.Filters {
+			return nil, pointsNext, errors.New("The cursor does not match the current filters.")
+		}
+
+		cursorSortBy, _ := decodedCursor["sort_by"].(string)
+		cursorSortOrder, _ := decodedCursor["sort_order"].(string)
+		if cursorSortBy != sortBy || cursorSortOrder != sortOrder {
+			return nil, pointsNext, errors.New("The cursor does not match the current sorting.")
+		}
+
 		pointsNext = decodedCursor["points_next"] == true
-		operator, order := getPaginationOperator(pointsNext, sortOrder)
-		whereStr := fmt.Sprintf("(%[1]screated_at %[2]s @created_at OR (%[1]screated_at = @created_at AND %[1]sid %[2]s @id))", alias, operator)
-		query = query.Where(whereStr, sql.Named("created_at", decodedCursor["created_at"]), sql.Named("id", decodedCursor["id"]))
+		values := []any{decodedCursor["created_at"], decodedCursor["id"]}
+
+		if sortBy != sortByCreatedAt {
+			value, ok := decodedCursor["sort_value"]
+			if !ok || value == nil {
+				return nil, pointsNext, errors.New("The cursor does not have a sort value.")
+			}
+
+			if sortBy != sortByRank {
+				value = normalizeCursorValue(value)
+			}
+
+			values = append([]any{value}, values...)
+		}
 
-		if len(order) > 0 {
-			sortOrder = order
+		whereStr, args := keysetCondition(keys, values, pointsNext)
+
+		// The leading inclusive bound allows partition pruning
+		if sortBy == sortByCreatedAt {
+			operator := ">="
+
+			if keys[0].Desc == pointsNext {
+				operator = "<="
+			}
+
+			whereStr = fmt.Sprintf("(%s %s @key0 AND %s)", keys[0].Expr, operator, whereStr)
 		}
+
+		query = query.Where(whereStr, append(args, opts.RankArgs...)...)
 	}
 
-	query = query.Order(fmt.Sprintf("%[1]screated_at %[2]s", alias, sortOrder))
+	// Previous pages are fetched in reverse and flipped afterwards
+	for i, k := range keys {
+		order := ASC
+
+		if k.Desc != (len(cursor) > 0 && !pointsNext) {
+			order = DESC
+		}
+
+		expr := k.Expr
+
+		// The relevance is sorted by its column alias
+		if sortBy == sortByRank && i == 0 {
+			expr = sortByRank
+		}
+
+		query = query.Order(fmt.Sprintf("%s %s", expr, order))
+	}
 
 	return query, pointsNext, nil
 }
 
-func getPaginationOperator(pointsNext bool, sortOrder string) (string, string) {
-	if pointsNext && sortOrder == ASC {
-		return ">", ""
-	}
+// Builds the condition to fetch the rows after (or before) the cursor:
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+func keysetCondition(keys []paginationKey, values []any, pointsNext bool) (string, []any) {
+	conditions := []string{}
+	args := []any{}
 
-	if pointsNext && sortOrder == DESC {
-		return "<", ""
-	}
+	for i, k := range keys {
+		operator := ">"
+
+		if k.Desc == pointsNext {
+			operator = "<"
+		}
 
-	if !pointsNext && sortOrder == ASC {
-		return "<", DESC
+		parts := []string{}
+
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = @key%d", keys[j].Expr, j))
+		}
+
+		parts = append(parts, fmt.Sprintf("%s %s @key%d", k.Expr, operator, i))
+		conditions = append(conditions, "("+strings.Join(parts, " AND ")+")")
+		args = append(args, sql.Named(fmt.Sprintf("key%d", i), values[i]))
 	}
 
-	if !pointsNext && sortOrder == DESC {
-		return ">", ASC
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// JSON numbers are decoded as floats
+func normalizeCursorValue(v any) any {
+	if f, ok := v.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f)
 	}
 
-	return "", ""
+	return v
 }
 
-func CalculatePagination[T PaginatedItem](isFirstPage bool, hasPagination bool, limit int, items []T, pointsNext bool, routeName string, ctx *fiber.Ctx) utils.PaginationInfo {
+func CalculatePagination[T PaginatedItem](isFirstPage bool, hasPagination bool, limit int, items []T, pointsNext bool, opts PaginatedItemOpts, ctx *fiber.Ctx) utils.PaginationInfo {
 	nextCur := utils.Cursor{}
 	prevCur := utils.Cursor{}
 
 	if isFirstPage && hasPagination {
-		nextCur = utils.CreateCursor(items[limit-1].GetID(), items[limit-1].GetCreatedAt(), true)
+		nextCur = createItemCursor(items[limit-1], true, opts)
 	}
 
 	if !isFirstPage {
 		if pointsNext {
 			if hasPagination {
-				nextCur = utils.CreateCursor(items[limit-1].GetID(), items[limit-1].GetCreatedAt(), true)
+				nextCur = createItemCursor(items[limit-1], true, opts)
 			}
 
-			prevCur = utils.CreateCursor(items[0].GetID(), items[0].GetCreatedAt(), false)
+			prevCur = createItemCursor(items[0], false, opts)
 		} else {
-			nextCur = utils.CreateCursor(items[limit-1].GetID(), items[limit-1].GetCreatedAt(), true)
+			nextCur = createItemCursor(items[limit-1], true, opts)
 
 			if hasPagination {
-				prevCur = utils.CreateCursor(items[0].GetID(), items[0].GetCreatedAt(), false)
+				prevCur = createItemCursor(items[0], false, opts)
 			}
 		}
 	}
 
-	pagination := utils.GeneratePager(nextCur, prevCur, routeName, ctx)
+	pagination := utils.GeneratePager(nextCur, prevCur, opts.RouteName, ctx)
 
 	if isFirstPage {
-		pagination = utils.GeneratePager(nextCur, nil, routeName, ctx)
+		pagination = utils.GeneratePager(nextCur, nil, opts.RouteName, ctx)
 	}
 
 	return pagination
 }
 
+func createItemCursor[T PaginatedItem](item T, pointsNext bool, opts PaginatedItemOpts) utils.Cursor {
+	cur := utils.CreateCursor(item.GetID(), item.GetCreatedAt(), pointsNext, opts.Filters)
+	cur["sort_by"] = opts.sortBy
+	cur["sort_order"] = opts.sortOrder
+
+	switch opts.sortBy {
+	case sortByCreatedAt:
+	case sortByRank:
+		if r, ok := any(item).(RankedItem); ok {
+			cur["sort_value"] = r.GetRank()
+		}
+	default:
+		cur["sort_value"] = getSortValue(item, opts.sortBy)
+	}
+
+	return cur
+}
+
+// Reads the value of the sort column from the model
+func getSortValue(item any, column string) any {
+	s := GetModelSchema(item)
+	if s == nil {
+		return nil
+	}
+
+	field := s.LookUpField(column)
+	if field == nil {
+		slog.Error(fmt.Sprintf("Could not find sort column '%s' in model %s", column, s.Name))
+		return nil
+	}
+
+	value, isZero := field.ValueOf(context.Background(), reflect.Indirect(reflect.ValueOf(item)))
+
+	// Sortable columns must not be nullable
+	if isZero && reflect.ValueOf(value).Kind() == reflect.Pointer {
+		return nil
+	}
+
+	return value
+}
+
 func GetModelSchema(model any) *schema.Schema {
 	stmt := &gorm.Statement{DB: app.DB()}
 	if err := stmt.Parse(model); err != nil {
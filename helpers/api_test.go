@@ -0,0 +1,222 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/testutil"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func TestPaginationKeysFollowSortOrder(t *testing.T) {
+	opts := PaginatedItemOpts{TableAlias: "t", RankExpr: "ts_rank(t.search, q)", SortColumns: []string{"name"}}
+
+	for _, sortBy := range []string{sortByCreatedAt, sortByRank, "name"} {
+		for _, sortOrder := range []string{ASC, DESC} {
+			keys := opts.paginationKeys(sortBy, sortOrder)
+
+			if n := len(keys); n < 2 || keys[n-2].Expr != "t.created_at" || keys[n-1].Expr != "t.id" {
+				t.Fatalf("%s %s: the creation date and ID must be the last keys, got %+v", sortBy, sortOrder, keys)
+			}
+
+			for _, k := range keys {
+				if k.Desc != (sortOrder == DESC) {
+					t.Errorf("%s %s: key %s does not follow the sort order", sortBy, sortOrder, k.Expr)
+				}
+			}
+		}
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	keys := []paginationKey{{Expr: "name", Desc: false}, {Expr: "created_at", Desc: false}, {Expr: "id", Desc: false}}
+
+	tests := []struct {
+		pointsNext bool
+		want       string
+	}{
+		{true, "((name > @key0) OR (name = @key0 AND created_at > @key1) OR (name = @key0 AND created_at = @key1 AND id > @key2))"},
+		{false, "((name < @key0) OR (name = @key0 AND created_at < @key1) OR (name = @key0 AND created_at = @key1 AND id < @key2))"},
+	}
+
+	for _, tt := range tests {
+		got, args := keysetCondition(keys, []any{"a", time.Now(), uuid.New()}, tt.pointsNext)
+
+		if got != tt.want {
+			t.Errorf("pointsNext %v:\ngot  %s\nwant %s", tt.pointsNext, got, tt.want)
+		}
+
+		if len(args) != len(keys) {
+			t.Errorf("pointsNext %v: got %d arguments, want %d", tt.pointsNext, len(args), len(keys))
+		}
+	}
+}
+
+type paginationTestItem struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;not null;default:gen_random_uuid()" json:"id"`
+	Name      string    `gorm:"not null" json:"name"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+}
+
+func (paginationTestItem) TableName() string {
+	return "pagination_test_items"
+}
+
+func (i paginationTestItem) GetID() uuid.UUID {
+	return i.ID
+}
+
+func (i paginationTestItem) GetCreatedAt() time.Time {
+	return i.CreatedAt
+}
+
+type paginationTestPage struct {
+	Data []paginationTestItem `json:"data"`
+	Next *string              `json:"next"`
+	Prev *string              `json:"prev"`
+}
+
+func getPaginationTestPage(t *testing.T, app *fiber.App, target string) paginationTestPage {
+	t.Helper()
+
+	res, err := app.Test(httptest.NewRequest(fiber.MethodGet, target, nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != fiber.StatusOK {
+		t.Fatalf("GET %s: status %d", target, res.StatusCode)
+	}
+
+	page := paginationTestPage{}
+	if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+
+	return page
+}
+
+// Path and query of the absolute cursor URL
+func paginationTestTarget(t *testing.T, cursorURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(cursorURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u.RequestURI()
+}
+
+func paginationTestIDs(items []paginationTestItem) []uuid.UUID {
+	ids := []uuid.UUID{}
+
+	for _, i := range items {
+		ids = append(ids, i.ID)
+	}
+
+	return ids
+}
+
+// Walks every page forwards and then backwards, with repeated sort values and creation dates
+func TestPaginateQueryWalksPages(t *testing.T) {
+	db := testutil.DB(t)
+
+	if err := db.Migrator().CreateTable(&paginationTestItem{}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = db.Migrator().DropTable(&paginationTestItem{})
+	})
+
+	base := time.Now().Truncate(time.Second)
+	items := []paginationTestItem{}
+
+	for i := range 23 {
+		items = append(items, paginationTestItem{
+			Name:      fmt.Sprintf("name-%d", i%4),
+			CreatedAt: base.Add(time.Duration(i%5) * time.Minute),
+		})
+	}
+
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/items", func(c *fiber.Ctx) error {
+		return PaginateQuery([]paginationTestItem{}, db.Model(&paginationTestItem{}), c, PaginatedItemOpts{
+			RouteName:   "test.items.index",
+			SortColumns: []string{"name"},
+		})
+	}).Name("test.items.index")
+
+	for _, sortBy := range []string{sortByCreatedAt, "name"} {
+		for _, sortOrder := range []string{ASC, DESC} {
+			t.Run(sortBy+" "+sortOrder, func(t *testing.T) {
+				expected := slices.Clone(items)
+				slices.SortFunc(expected, func(a, b paginationTestItem) int {
+					n := 0
+
+					if sortBy == "name" {
+						n = strings.Compare(a.Name, b.Name)
+					}
+
+					if n == 0 {
+						n = a.CreatedAt.Compare(b.CreatedAt)
+					}
+
+					if n == 0 {
+						n = bytes.Compare(a.ID[:], b.ID[:])
+					}
+
+					if sortOrder == DESC {
+						n = -n
+					}
+
+					return n
+				})
+
+				pages := [][]uuid.UUID{}
+				page := getPaginationTestPage(t, app, fmt.Sprintf("/items?per_page=5&sort_by=%s&sort_order=%s", sortBy, sortOrder))
+				pages = append(pages, paginationTestIDs(page.Data))
+
+				for page.Next != nil {
+					page = getPaginationTestPage(t, app, paginationTestTarget(t, *page.Next))
+					pages = append(pages, paginationTestIDs(page.Data))
+
+					if len(pages) > len(items) {
+						t.Fatal("The pagination does not end.")
+					}
+				}
+
+				if got, want := slices.Concat(pages...), paginationTestIDs(expected); !slices.Equal(got, want) {
+					t.Fatalf("Walking forwards:\ngot  %v\nwant %v", got, want)
+				}
+
+				// Back to the first page from the last one
+				for i := len(pages) - 2; i >= 0; i-- {
+					if page.Prev == nil {
+						t.Fatalf("Page %d has no previous page.", i+1)
+					}
+
+					page = getPaginationTestPage(t, app, paginationTestTarget(t, *page.Prev))
+
+					if got := paginationTestIDs(page.Data); !slices.Equal(got, pages[i]) {
+						t.Fatalf("Walking backwards, page %d:\ngot  %v\nwant %v", i, got, pages[i])
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	// Tells API tokens apart from access tokens, and makes them easy to find in leaked secrets
+	APITokenPrefix string = "cspr_"
+
+	APITokenDefaultExpiration time.Duration = 90 * 24 * time.Hour
+	APITokenMaxExpiration     time.Duration = 365 * 24 * time.Hour
+
+	apiTokenLength    int           = 40
+	apiTokenLocalsKey string        = "api_token"
+	apiTokenTouchRate time.Duration = time.Minute
+)
+
+var ErrAPITokenInvalid = errors.New("The API token is invalid, expired or revoked.")
+
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Generates a token for the user, the plain token is only returned here
+func NewAPIToken(userID uuid.UUID, name string, roles []string, expiresAt time.Time) (*models.APIToken, string, error) {
+	random, err := utils.RandomString(apiTokenLength)
+	if err != nil || len(random) < 1 {
+		return nil, "", fmt.Errorf("Error generating random string: %w", err)
+	}
+
+	plain := APITokenPrefix + random
+	token := &models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    plain[:len(APITokenPrefix)+6],
+		TokenHash: HashAPIToken(plain),
+		Roles:     roles,
+		ExpiresAt: expiresAt,
+	}
+
+	if err := app.DB().Create(&token).Error; err != nil {
+		return nil, "", err
+	}
+
+	return token, plain, nil
+}
+
+// Valid token matching the plain one, with its user
+func GetAPIToken(plain string) (*models.APIToken, error) {
+	if !IsAPIToken(plain) {
+		return nil, ErrAPITokenInvalid
+	}
+
+	token := &models.APIToken{}
+	if err := app.DB().Where(&models.APIToken{TokenHash: HashAPIToken(plain)}).
+		Where("revoked_at IS NULL AND expires_at > ?", time.Now()).
+		Preload("User").First(&token).Error; err != nil {
+		return nil, ErrAPITokenInvalid
+	}
+
+	return token, nil
+}
+
+// Saves when and where the token was last used, at most once a minute
+func TouchAPIToken(token *models.APIToken, ip string) error {
+	now := time.Now()
+
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < apiTokenTouchRate {
+		return nil
+	}
+
+	return app.DB().Model(&models.APIToken{}).Where(&models.APIToken{ID: token.ID}).
+		Updates(&models.APIToken{LastUsedAt: &now, LastUsedIP: &ip}).Error
+}
+
+func SetRequestAPIToken(c *fiber.Ctx, token *models.APIToken) {
+	c.Locals(apiTokenLocalsKey, token)
+}
+
+// Token the request was authenticated with, nil for access tokens
+func GetRequestAPIToken(c *fiber.Ctx) *models.APIToken {
+	token, ok := c.Locals(apiTokenLocalsKey).(*models.APIToken)
+	if !ok {
+		return nil
+	}
+
+	return token
+}
+
+// Roles the request can use, the ones of the user limited to the
+// roles of the API token and the roles they inherit, if any
+func GetRequestRoles(c *fiber.Ctx) (userRoleList, error) {
+	roles, err := GetUserRoles(GetUserID(c))
+	if err != nil {
+		return userRoleList{}, err
+	}
+
+	token := GetRequestAPIToken(c)
+	if token == nil {
+		return roles, nil
+	}
+
+	return roles.Limit(token.Roles)
+}
+
+func IsAuthenticated(c *fiber.Ctx) bool {
+	return GetRequestAPIToken(c) != nil || c.Locals(utils.AccessTokenContextKey()) != nil
+}
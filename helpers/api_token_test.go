@@ -0,0 +1,156 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/testutil"
+	"github.com/google/uuid"
+)
+
+func TestHashAPIToken(t *testing.T) {
+	token := APITokenPrefix + "abcdef"
+	sum := sha256.Sum256([]byte(token))
+
+	if got, want := HashAPIToken(token), hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if HashAPIToken(token) == HashAPIToken(token+"g") {
+		t.Error("Different tokens must have different hashes.")
+	}
+}
+
+func TestIsAPIToken(t *testing.T) {
+	tests := map[string]bool{
+		APITokenPrefix + "abcdef": true,
+		"eyJhbGciOiJFQ0RILUVTIn0": false,
+		"":                        false,
+		"CSPR_abcdef":             false,
+	}
+
+	for token, want := range tests {
+		if got := IsAPIToken(token); got != want {
+			t.Errorf("%q: got %v, want %v", token, got, want)
+		}
+	}
+}
+
+// Only valid tokens are found, they stop working once revoked or expired
+func TestGetAPIToken(t *testing.T) {
+	db := testutil.DB(t)
+
+	active := true
+	user := &models.User{Email: fmt.Sprintf("%s@example.com", uuid.NewString()), Password: "-", Active: &active}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Where(&models.APIToken{UserID: user.ID}).Delete(&models.APIToken{})
+		db.Unscoped().Delete(&user)
+	})
+
+	token, plain, err := NewAPIToken(user.ID, "Test", []string{"viewer"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsAPIToken(plain) || !strings.HasPrefix(plain, token.Prefix) || token.TokenHash != HashAPIToken(plain) {
+		t.Fatalf("got token %s with prefix %s and hash %s", plain, token.Prefix, token.TokenHash)
+	}
+
+	stored := &models.APIToken{}
+	if err := db.Where(&models.APIToken{ID: token.ID}).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(stored.TokenHash, plain) || stored.TokenHash != token.TokenHash {
+		t.Fatal("Only the hash of the token must be stored.")
+	}
+
+	found, err := GetAPIToken(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.ID != token.ID || found.User.ID != user.ID {
+		t.Fatalf("got token %s of user %s", found.ID, found.User.ID)
+	}
+
+	for _, invalid := range []string{plain + "x", strings.TrimPrefix(plain, APITokenPrefix), ""} {
+		if _, err := GetAPIToken(invalid); !errors.Is(err, ErrAPITokenInvalid) {
+			t.Errorf("%q: got error %v, want %v", invalid, err, ErrAPITokenInvalid)
+		}
+	}
+
+	now := time.Now()
+	if err := db.Model(&token).Updates(&models.APIToken{RevokedAt: &now}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetAPIToken(plain); !errors.Is(err, ErrAPITokenInvalid) {
+		t.Errorf("Revoked token: got error %v, want %v", err, ErrAPITokenInvalid)
+	}
+
+	_, expired, err := NewAPIToken(user.ID, "Expired", nil, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetAPIToken(expired); !errors.Is(err, ErrAPITokenInvalid) {
+		t.Errorf("Expired token: got error %v, want %v", err, ErrAPITokenInvalid)
+	}
+}
+
+// The last use is saved at most once a minute
+func TestTouchAPIToken(t *testing.T) {
+	db := testutil.DB(t)
+
+	active := true
+	user := &models.User{Email: fmt.Sprintf("%s@example.com", uuid.NewString()), Password: "-", Active: &active}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Where(&models.APIToken{UserID: user.ID}).Delete(&models.APIToken{})
+		db.Unscoped().Delete(&user)
+	})
+
+	token, _, err := NewAPIToken(user.ID, "Test", nil, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := TouchAPIToken(token, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &models.APIToken{}
+	if err := db.Where(&models.APIToken{ID: token.ID}).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.LastUsedAt == nil || stored.LastUsedIP == nil || *stored.LastUsedIP != "192.0.2.1" {
+		t.Fatalf("got last use %v from %v", stored.LastUsedAt, stored.LastUsedIP)
+	}
+
+	if err := TouchAPIToken(stored, "192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Where(&models.APIToken{ID: token.ID}).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if *stored.LastUsedIP != "192.0.2.1" {
+		t.Errorf("The last use was saved again within a minute, from %s", *stored.LastUsedIP)
+	}
+}
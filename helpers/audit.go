@@ -0,0 +1,297 @@
+package helpers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/redis/rueidis"
+	"gorm.io/gorm"
+)
+
+const (
+	AuditLogin               string = "auth.login"
+	AuditLoginFailed         string = "auth.login.failed"
+	AuditLogout              string = "auth.logout"
+	AuditPasswordRecovered   string = "auth.password.recovered"
+	AuditPermissionDenied    string = "auth.permission.denied"
+	AuditActivationReviewed  string = "user.activation.reviewed"
+	AuditRoleGranted         string = "user.role.granted"
+	AuditRoleRemoved         string = "user.role.removed"
+	AuditUserDeactivated     string = "user.deactivated"
+	AuditUserReactivated     string = "user.reactivated"
+	AuditPasswordReset       string = "user.password.reset"
+	AuditCachePurged         string = "system.cache.purged"
+	AuditAPITokenCreated     string = "api_token.created"
+	AuditAPITokenRevoked     string = "api_token.revoked"
+	AuditPolicyAdded         string = "policy.added"
+	AuditPolicyRemoved       string = "policy.removed"
+	AuditGroupingPolicyAdded string = "policy.grouping.added"
+)
+
+// Serializes the writers of the hash chain
+const auditLockKey int64 = 0x61756469740a
+
+// Only one denied request of each user is recorded in this period, the others are logged
+const auditDeniedInterval time.Duration = time.Minute
+
+// Previous hash of the first event
+var auditGenesisHash = strings.Repeat("0", 64)
+
+type AuditEntry struct {
+	// Defaults to the current user
+	ActorID    *uuid.UUID
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     any
+	After      any
+}
+
+type AuditVerification struct {
+	Valid   bool       `json:"valid"`
+	Checked int64      `json:"checked"`
+	EventID *uuid.UUID `json:"event_id,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+}
+
+func truncateAuditValue(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+// JSON as stored by jsonb, so the hash does not depend on key order nor whitespace
+func canonicalAuditJSON(data []byte) (json.RawMessage, error) {
+	if len(data) < 1 || string(data) == "null" {
+		return nil, nil
+	}
+
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+func auditValue(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return canonicalAuditJSON(data)
+}
+
+func AuditEventHash(e *models.AuditEvent) (string, error) {
+	before, err := canonicalAuditJSON(e.Before)
+	if err != nil {
+		return "", err
+	}
+
+	after, err := canonicalAuditJSON(e.After)
+	if err != nil {
+		return "", err
+	}
+
+	actorID := ""
+	if e.ActorID != nil {
+		actorID = e.ActorID.String()
+	}
+
+	fields := []string{
+		e.PrevHash,
+		strconv.FormatInt(e.Sequence, 10),
+		e.ID.String(),
+		actorID,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		e.IP,
+		e.UserAgent,
+		e.RequestID,
+		string(before),
+		string(after),
+		strconv.FormatInt(e.CreatedAt.UnixMicro(), 10),
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Appends the event to the hash chain
+func AppendAuditEvent(db *gorm.DB, e *models.AuditEvent) error {
+	var err error
+
+	if e.Before, err = canonicalAuditJSON(e.Before); err != nil {
+		return err
+	}
+
+	if e.After, err = canonicalAuditJSON(e.After); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditLockKey).Error; err != nil {
+			return err
+		}
+
+		last := &models.AuditEvent{}
+		result := tx.Select("sequence", "hash").Order("sequence DESC").Limit(1).Find(&last)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		e.Sequence = 1
+		e.PrevHash = auditGenesisHash
+
+		if result.RowsAffected > 0 {
+			e.Sequence = last.Sequence + 1
+			e.PrevHash = last.Hash
+		}
+
+		e.ID = uuid.New()
+		// Stored with microsecond precision
+		e.CreatedAt = time.Now().Truncate(time.Microsecond)
+
+		hash, err := AuditEventHash(e)
+		if err != nil {
+			return err
+		}
+
+		e.Hash = hash
+
+		return tx.Omit("Actor").Create(&e).Error
+	})
+}
+
+// Records the event of the request, errors are logged so the request is not affected
+func RecordAudit(c *fiber.Ctx, entry AuditEntry) {
+	e := &models.AuditEvent{
+		ActorID:    entry.ActorID,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   truncateAuditValue(entry.TargetID, 255),
+		IP:         c.IP(),
+		UserAgent:  truncateAuditValue(string(c.Request().Header.UserAgent()), 500),
+	}
+
+	if e.ActorID == nil && IsAuthenticated(c) {
+		actorID := GetUserID(c)
+		e.ActorID = &actorID
+	}
+
+	if requestID, ok := c.Locals("requestid").(string); ok {
+		e.RequestID = requestID
+	}
+
+	var err error
+
+	if e.Before, err = auditValue(entry.Before); err == nil {
+		e.After, err = auditValue(entry.After)
+	}
+
+	if err == nil {
+		err = AppendAuditEvent(app.DB(), e)
+	}
+
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Could not record audit event %s: %v", entry.Action, err))
+	}
+}
+
+// Walks the hash chain checking every event and its link to the previous one
+func VerifyAuditChain(db *gorm.DB) (*AuditVerification, error) {
+	v := &AuditVerification{Valid: true}
+	prevHash := auditGenesisHash
+
+	for {
+		events := []models.AuditEvent{}
+		if err := db.Where("sequence > ?", v.Checked).Order("sequence ASC").Limit(1000).Find(&events).Error; err != nil {
+			return nil, err
+		}
+
+		for i := range events {
+			e := &events[i]
+			v.Checked++
+
+			hash, err := AuditEventHash(e)
+			if err != nil {
+				return nil, err
+			}
+
+			switch {
+			case e.Sequence != v.Checked:
+				v.Reason = "The sequence has gaps, events were removed."
+			case e.PrevHash != prevHash:
+				v.Reason = "The event is not linked to the previous one."
+			case e.Hash != hash:
+				v.Reason = "The event has been modified."
+			}
+
+			if len(v.Reason) > 0 {
+				v.Valid = false
+				v.EventID = &e.ID
+
+				return v, nil
+			}
+
+			prevHash = e.Hash
+		}
+
+		if len(events) < 1000 {
+			return v, nil
+		}
+	}
+}
+
+// Records the denied request, repeated ones of the user are only logged
+// so clients retrying forbidden requests do not flood the hash chain
+func RecordPermissionDenied(c *fiber.Ctx, actorID uuid.UUID) {
+	target := c.Method() + " " + c.Path()
+	key := fmt.Sprintf("audit:denied:%s", actorID.String())
+
+	err := app.Cache().Do(context.Background(), app.Cache().B().Set().Key(key).Value(target).Nx().Ex(auditDeniedInterval).Build()).Error()
+	if errors.Is(err, rueidis.Nil) {
+		slog.Warn(fmt.Sprintf("Permission denied to %s on %s", actorID, target))
+		return
+	}
+
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Could not save denied request to cache: %v", err))
+	}
+
+	RecordAudit(c, AuditEntry{
+		ActorID:    &actorID,
+		Action:     AuditPermissionDenied,
+		TargetType: "route",
+		TargetID:   target,
+	})
+}
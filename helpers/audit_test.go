@@ -0,0 +1,134 @@
+package helpers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/testutil"
+	"github.com/google/uuid"
+)
+
+func newAuditTestEvent() *models.AuditEvent {
+	actorID := uuid.New()
+
+	return &models.AuditEvent{
+		ID:         uuid.New(),
+		Sequence:   7,
+		ActorID:    &actorID,
+		Action:     AuditRoleGranted,
+		TargetType: "user",
+		TargetID:   uuid.NewString(),
+		IP:         "192.0.2.1",
+		UserAgent:  "Test",
+		RequestID:  "request",
+		Before:     json.RawMessage(`{"role": "viewer", "site_id": null}`),
+		After:      json.RawMessage(`{"role":"admin","site_id":null}`),
+		PrevHash:   auditGenesisHash,
+		CreatedAt:  time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC),
+	}
+}
+
+func TestAuditEventHash(t *testing.T) {
+	e := newAuditTestEvent()
+
+	hash, err := AuditEventHash(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hash) != 64 {
+		t.Fatalf("got hash %q, want 64 hexadecimal characters", hash)
+	}
+
+	// As returned by jsonb, with another key order and whitespace
+	stored := *e
+	stored.Before = json.RawMessage(`{"site_id":null,"role":"viewer"}`)
+
+	if got, _ := AuditEventHash(&stored); got != hash {
+		t.Errorf("The hash depends on the JSON formatting: got %s, want %s", got, hash)
+	}
+
+	changes := map[string]func(e *models.AuditEvent){
+		"previous hash": func(e *models.AuditEvent) { e.PrevHash = hash },
+		"sequence":      func(e *models.AuditEvent) { e.Sequence++ },
+		"actor":         func(e *models.AuditEvent) { e.ActorID = nil },
+		"action":        func(e *models.AuditEvent) { e.Action = AuditRoleRemoved },
+		"target":        func(e *models.AuditEvent) { e.TargetID = uuid.NewString() },
+		"IP address":    func(e *models.AuditEvent) { e.IP = "192.0.2.2" },
+		"after":         func(e *models.AuditEvent) { e.After = json.RawMessage(`{"role":"superadmin","site_id":null}`) },
+		"creation date": func(e *models.AuditEvent) { e.CreatedAt = e.CreatedAt.Add(time.Microsecond) },
+	}
+
+	for name, change := range changes {
+		changed := *e
+		change(&changed)
+
+		if got, _ := AuditEventHash(&changed); got == hash {
+			t.Errorf("Changing the %s does not change the hash.", name)
+		}
+	}
+}
+
+// Appends events and then tampers with them, everything is rolled back
+func TestVerifyAuditChain(t *testing.T) {
+	tx := testutil.DB(t).Begin()
+	defer tx.Rollback()
+
+	events := []*models.AuditEvent{}
+
+	for range 3 {
+		e := &models.AuditEvent{Action: AuditCachePurged, TargetType: "system", After: json.RawMessage(`{"test":true}`)}
+		if err := AppendAuditEvent(tx, e); err != nil {
+			t.Fatal(err)
+		}
+
+		events = append(events, e)
+	}
+
+	for i := 1; i < len(events); i++ {
+		if events[i].PrevHash != events[i-1].Hash || events[i].Sequence != events[i-1].Sequence+1 {
+			t.Fatalf("Event %d is not linked to the previous one.", i)
+		}
+	}
+
+	v, err := VerifyAuditChain(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !v.Valid || v.Checked < int64(len(events)) {
+		t.Fatalf("The chain must be valid, got %+v", v)
+	}
+
+	if err := tx.Exec("ALTER TABLE audit_events DISABLE TRIGGER audit_events_append_only").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	modified := events[1]
+	if err := tx.Exec("UPDATE audit_events SET target_id = ? WHERE id = ?", "tampered", modified.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err = VerifyAuditChain(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Valid || v.EventID == nil || *v.EventID != modified.ID || v.Reason != "The event has been modified." {
+		t.Fatalf("The modified event must be reported, got %+v", v)
+	}
+
+	// Removing the modified event leaves a gap before the next one
+	if err := tx.Exec("DELETE FROM audit_events WHERE id = ?", modified.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err = VerifyAuditChain(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Valid || v.EventID == nil || *v.EventID != events[2].ID || v.Reason != "The sequence has gaps, events were removed." {
+		t.Fatalf("The removed event must be reported, got %+v", v)
+	}
+}
@@ -1,13 +1,11 @@
 package helpers
 
 import (
-	"database/sql"
 	"fmt"
 	"log/slog"
 	"strings"
 
 	"alfredoramos.mx/csp-reporter/app"
-	"alfredoramos.mx/csp-reporter/models"
 	"alfredoramos.mx/csp-reporter/utils"
 )
 
@@ -18,11 +16,8 @@ func IsAllowedDomain(d string) bool {
 		return false
 	}
 
-	s := &models.Site{}
-
-	if err := app.DB().Model(&models.Site{}).
-		Where("unaccent(lower(domain)) = unaccent(lower(@domain))", sql.Named("domain", d)).
-		First(&s).Error; err != nil {
+	s, err := GetSiteByDomain(app.DB(), d)
+	if err != nil {
 		slog.Error(fmt.Sprintf("Error checking allowed domain: %v", err))
 		return false
 	}
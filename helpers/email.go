@@ -36,6 +36,7 @@ type EmailOpts struct {
 	BCCList        []string                `json:"bcc_list"`
 	AttachmentList []*multipart.FileHeader `json:"attachment_list"`
 	IsInternal     bool                    `json:"is_internal"`
+	SkipBCC        bool                    `json:"skip_bcc"`
 }
 
 func (e EmailOpts) IsValid() bool {
@@ -113,7 +114,9 @@ func SendEmail(opts EmailOpts, data map[string]interface{}) error {
 		msg.CcIgnoreInvalid(opts.CCList...)
 	}
 
-	opts.BCCList = GetSuperAdminEmails()
+	if !opts.SkipBCC {
+		opts.BCCList = GetSuperAdminEmails()
+	}
 
 	if len(opts.BCCList) > 0 {
 		msg.BccIgnoreInvalid(opts.BCCList...)
@@ -0,0 +1,429 @@
+package helpers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+)
+
+const (
+	ExportCSV    string = "csv"
+	ExportNDJSON string = "ndjson"
+	ExportJSON   string = "json"
+)
+
+const (
+	ExportPending   string = "pending"
+	ExportRunning   string = "running"
+	ExportCompleted string = "completed"
+	ExportFailed    string = "failed"
+	ExportExpired   string = "expired"
+)
+
+const exportBatchSize int = 1000
+
+type exportEncoder interface {
+	Encode(r models.Report) error
+	Close() error
+}
+
+type exportDownloadClaims struct {
+	ID uuid.UUID `json:"id"`
+}
+
+func ExportFormats() []string {
+	return []string{ExportCSV, ExportNDJSON, ExportJSON}
+}
+
+func ExportContentType(format string) string {
+	switch format {
+	case ExportCSV:
+		return "text/csv; charset=utf-8"
+	case ExportNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/json"
+	}
+}
+
+func exportDownloadAudience(id uuid.UUID) string {
+	return "export:" + id.String()
+}
+
+func NewExportDownloadToken(e *models.Export) (string, error) {
+	if e.ExpiresAt == nil {
+		return "", errors.New("The export does not have an expiration date.")
+	}
+
+	return utils.NewSignedToken(exportDownloadAudience(e.ID), exportDownloadClaims{ID: e.ID}, time.Until(*e.ExpiresAt))
+}
+
+func ValidateExportDownloadToken(id uuid.UUID, token string) error {
+	claims := exportDownloadClaims{}
+
+	if err := utils.ParseSignedToken(token, exportDownloadAudience(id), &claims); err != nil {
+		return err
+	}
+
+	if claims.ID != id {
+		return errors.New("The download token does not match the export.")
+	}
+
+	return nil
+}
+
+func RunExport(ctx context.Context, id uuid.UUID) (*models.Export, error) {
+	export := &models.Export{}
+	if err := app.DB().Where(&models.Export{ID: id}).First(&export).Error; err != nil {
+		return nil, fmt.Errorf("Could not get export: %w", err)
+	}
+
+	if export.Status != ExportPending && export.Status != ExportRunning {
+		return export, fmt.Errorf("The export is already %s.", export.Status)
+	}
+
+	filters := ReportFilters{}
+	if err := json.Unmarshal(export.Filters, &filters); err != nil {
+		return export, failExport(export, fmt.Errorf("Could not decode export filters: %w", err))
+	}
+
+	storage, err := GetStorage(export.Storage)
+	if err != nil {
+		return export, failExport(export, err)
+	}
+
+	now := time.Now().In(utils.DefaultLocation())
+	if err := app.DB().Model(&export).Updates(&models.Export{Status: ExportRunning, StartedAt: &now}).Error; err != nil {
+		return export, fmt.Errorf("Could not update export status: %w", err)
+	}
+
+	key := fmt.Sprintf("%s.%s", export.ID, export.Format)
+
+	rows, size, err := writeExport(ctx, storage, key, export.Format, filters)
+	if err != nil {
+		if err := storage.Delete(ctx, key); err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not delete incomplete export: %v", err))
+		}
+
+		return export, failExport(export, err)
+	}
+
+	completedAt := time.Now().In(utils.DefaultLocation())
+	expiresAt := completedAt.Add(utils.ExportExpiration())
+
+	if err := app.DB().Model(&export).Updates(&models.Export{
+		Status:      ExportCompleted,
+		Path:        &key,
+		Rows:        rows,
+		Size:        size,
+		CompletedAt: &completedAt,
+		ExpiresAt:   &expiresAt,
+	}).Error; err != nil {
+		return export, fmt.Errorf("Could not update export status: %w", err)
+	}
+
+	return export, nil
+}
+
+func failExport(export *models.Export, err error) error {
+	msg := err.Error()
+
+	if err := app.DB().Model(&export).Updates(&models.Export{Status: ExportFailed, Error: &msg}).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Could not update export status: %v", err))
+	}
+
+	return err
+}
+
+// Writes the reports to the storage, returning the number of rows and bytes written
+func writeExport(ctx context.Context, storage Storage, key string, format string, filters ReportFilters) (int64, int64, error) {
+	w, err := storage.Create(ctx, key)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Could not create export file: %w", err)
+	}
+
+	cw := &countingWriter{w: w}
+	buf := bufio.NewWriter(cw)
+
+	enc, err := newExportEncoder(format, buf)
+	if err != nil {
+		_ = w.Close()
+		return 0, 0, err
+	}
+
+	var rows int64
+
+	if err := streamReports(ctx, filters, func(batch []models.Report) error {
+		for _, r := range batch {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+
+			rows++
+		}
+
+		return nil
+	}); err != nil {
+		_ = w.Close()
+		return 0, 0, fmt.Errorf("Could not write export: %w", err)
+	}
+
+	if err := enc.Close(); err != nil {
+		_ = w.Close()
+		return 0, 0, fmt.Errorf("Could not write export: %w", err)
+	}
+
+	if err := buf.Flush(); err != nil {
+		_ = w.Close()
+		return 0, 0, fmt.Errorf("Could not write export: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return 0, 0, fmt.Errorf("Could not save export file: %w", err)
+	}
+
+	return rows, cw.n, nil
+}
+
+// Reads the reports in batches using keyset pagination
+func streamReports(ctx context.Context, filters ReportFilters, fn func([]models.Report) error) error {
+	var last *models.Report
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch := []models.Report{}
+		query := filters.Apply(app.DB().WithContext(ctx).Model(&models.Report{}).Preload("Site"), "")
+
+		if last != nil {
+			query = query.Where("(created_at, id) > (?, ?)", last.CreatedAt, last.ID)
+		}
+
+		if err := query.Order("created_at, id").Limit(exportBatchSize).Find(&batch).Error; err != nil {
+			return err
+		}
+
+		if len(batch) < 1 {
+			return nil
+		}
+
+		if err := fn(batch); err != nil {
+			return err
+		}
+
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+
+		last = &batch[len(batch)-1]
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
+
+func newExportEncoder(format string, w io.Writer) (exportEncoder, error) {
+	switch format {
+	case ExportCSV:
+		enc := &csvExportEncoder{w: csv.NewWriter(w)}
+		return enc, enc.w.Write(csvExportHeader)
+	case ExportNDJSON:
+		return &ndjsonExportEncoder{enc: json.NewEncoder(w)}, nil
+	case ExportJSON:
+		return &jsonExportEncoder{w: w, enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("Invalid export format '%s'.", format)
+	}
+}
+
+var csvExportHeader = []string{
+	"id",
+	"created_at",
+	"site_id",
+	"site_domain",
+	"document_uri",
+	"referrer",
+	"blocked_uri",
+	"blocked_host",
+	"effective_directive",
+	"violated_directive",
+	"disposition",
+	"status_code",
+	"source_file",
+	"line_number",
+	"column_number",
+	"script_sample",
+	"browser",
+	"user_agent",
+	"original_policy",
+}
+
+type csvExportEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvExportEncoder) Encode(r models.Report) error {
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+
+		return *s
+	}
+
+	num := func(n *int64) string {
+		if n == nil {
+			return ""
+		}
+
+		return strconv.FormatInt(*n, 10)
+	}
+
+	record := []string{
+		r.ID.String(),
+		r.CreatedAt.Format(time.RFC3339Nano),
+		r.SiteID.String(),
+		r.Site.Domain,
+		r.DocumentURI,
+		str(r.Referrer),
+		r.BlockedURI,
+		str(r.BlockedHost),
+		r.EffectiveDirective,
+		r.ViolatedDirective,
+		r.Disposition,
+		strconv.Itoa(r.StatusCode),
+		str(r.SourceFile),
+		num(r.LineNumber),
+		num(r.ColumnNumber),
+		str(r.ScriptSample),
+		str(r.Browser),
+		str(r.UserAgent),
+		r.OriginalPolicy,
+	}
+
+	for i, v := range record {
+		record[i] = escapeCSVFormula(v)
+	}
+
+	return e.w.Write(record)
+}
+
+func (e *csvExportEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// Prevents spreadsheet applications from evaluating cells as formulas
+func escapeCSVFormula(v string) string {
+	if len(v) > 0 && (v[0] == '=' || v[0] == '+' || v[0] == '-' || v[0] == '@' || v[0] == '\t' || v[0] == '\r') {
+		return "'" + v
+	}
+
+	return v
+}
+
+type ndjsonExportEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExportEncoder) Encode(r models.Report) error {
+	return e.enc.Encode(r)
+}
+
+func (e *ndjsonExportEncoder) Close() error {
+	return nil
+}
+
+type jsonExportEncoder struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int64
+}
+
+func (e *jsonExportEncoder) Encode(r models.Report) error {
+	sep := ","
+
+	if e.count == 0 {
+		sep = "["
+	}
+
+	if _, err := io.WriteString(e.w, sep); err != nil {
+		return err
+	}
+
+	e.count++
+
+	return e.enc.Encode(r)
+}
+
+func (e *jsonExportEncoder) Close() error {
+	end := "]\n"
+
+	if e.count == 0 {
+		end = "[]\n"
+	}
+
+	_, err := io.WriteString(e.w, end)
+
+	return err
+}
+
+// Removes the files of the exports past their expiration date
+func PurgeExpiredExports(ctx context.Context) error {
+	exports := []models.Export{}
+	if err := app.DB().WithContext(ctx).
+		Where("status = ? AND expires_at < ?", ExportCompleted, time.Now()).
+		Find(&exports).Error; err != nil {
+		return fmt.Errorf("Could not get expired exports: %w", err)
+	}
+
+	for _, e := range exports {
+		storage, err := GetStorage(e.Storage)
+		if err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not get export storage: %v", err))
+			continue
+		}
+
+		if e.Path != nil {
+			if err := storage.Delete(ctx, *e.Path); err != nil {
+				sentry.CaptureException(err)
+				slog.Error(fmt.Sprintf("Could not delete export file: %v", err))
+				continue
+			}
+		}
+
+		if err := app.DB().WithContext(ctx).Model(&e).Updates(map[string]interface{}{"status": ExportExpired, "path": nil}).Error; err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not update export status: %v", err))
+		}
+	}
+
+	return nil
+}
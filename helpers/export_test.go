@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/models"
+	"github.com/google/uuid"
+)
+
+func TestExportDownloadToken(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	export := &models.Export{ID: uuid.New(), ExpiresAt: &expiresAt}
+
+	token, err := NewExportDownloadToken(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ValidateExportDownloadToken(export.ID, token); err != nil {
+		t.Errorf("The token of the export was rejected: %v", err)
+	}
+
+	if err := ValidateExportDownloadToken(uuid.New(), token); err == nil {
+		t.Error("The token was accepted for another export.")
+	}
+
+	expired := time.Now().Add(-2 * time.Minute)
+	if _, err := NewExportDownloadToken(&models.Export{ID: export.ID}); err == nil {
+		t.Error("A token was created for an export without expiration date.")
+	}
+
+	token, err = NewExportDownloadToken(&models.Export{ID: export.ID, ExpiresAt: &expired})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ValidateExportDownloadToken(export.ID, token); err == nil {
+		t.Error("The token of an expired export was accepted.")
+	}
+}
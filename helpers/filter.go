@@ -0,0 +1,306 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const maxSearchLength int = 200
+
+const (
+	DeletedExclude string = "exclude"
+	DeletedInclude string = "include"
+	DeletedOnly    string = "only"
+)
+
+type ReportFilters struct {
+	SiteIDs             []uuid.UUID `json:"site_id,omitempty"`
+	GroupIDs            []uuid.UUID `json:"group_id,omitempty"`
+	EffectiveDirectives []string    `json:"effective_directive,omitempty"`
+	ViolatedDirectives  []string    `json:"violated_directive,omitempty"`
+	Dispositions        []string    `json:"disposition,omitempty"`
+	StatusCodes         []int       `json:"status_code,omitempty"`
+	From                *time.Time  `json:"from,omitempty"`
+	To                  *time.Time  `json:"to,omitempty"`
+	BlockedURIPrefix    string      `json:"blocked_uri_prefix,omitempty"`
+	BlockedURIContains  string      `json:"blocked_uri_contains,omitempty"`
+	DocumentURIPrefix   string      `json:"document_uri_prefix,omitempty"`
+	DocumentURIContains string      `json:"document_uri_contains,omitempty"`
+	SourceFile          string      `json:"source_file,omitempty"`
+	Deleted             string      `json:"deleted,omitempty"`
+	Quarantined         bool        `json:"quarantined,omitempty"`
+	Search              string      `json:"q,omitempty"`
+}
+
+// The query is split on punctuation the same way as the search vector
+const searchTsQuery string = "plainto_tsquery('simple', regexp_replace(@search, '[^[:alnum:]]+', ' ', 'g'))"
+
+func parseQueryList(s string) []string {
+	list := []string{}
+
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+
+		if len(v) > 0 && !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+func parseQueryTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	return time.ParseInLocation(time.DateOnly, s, utils.DefaultLocation())
+}
+
+// Escapes the wildcards of LIKE patterns
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func ParseReportFilters(c *fiber.Ctx) (ReportFilters, fiber.Map) {
+	errs := fiber.Map{}
+	f := ReportFilters{
+		EffectiveDirectives: parseQueryList(c.Query("effective_directive")),
+		ViolatedDirectives:  parseQueryList(c.Query("violated_directive")),
+		Dispositions:        parseQueryList(c.Query("disposition")),
+		BlockedURIPrefix:    strings.TrimSpace(c.Query("blocked_uri_prefix")),
+		BlockedURIContains:  strings.TrimSpace(c.Query("blocked_uri_contains")),
+		DocumentURIPrefix:   strings.TrimSpace(c.Query("document_uri_prefix")),
+		DocumentURIContains: strings.TrimSpace(c.Query("document_uri_contains")),
+		SourceFile:          strings.TrimSpace(c.Query("source_file")),
+		Deleted:             strings.ToLower(c.Query("deleted", DeletedExclude)),
+		Quarantined:         c.QueryBool("quarantined"),
+		Search:              strings.TrimSpace(c.Query("q")),
+	}
+
+	if len(f.Search) > maxSearchLength {
+		errs = utils.AddError(errs, "q", fmt.Sprintf("The search query must be at most %d characters long.", maxSearchLength))
+	}
+
+	for _, s := range parseQueryList(c.Query("site_id")) {
+		id, err := uuid.Parse(s)
+		if err != nil || !utils.IsValidUuid(id) {
+			errs = utils.AddError(errs, "site_id", "One or more sites are invalid.")
+			continue
+		}
+
+		f.SiteIDs = append(f.SiteIDs, id)
+	}
+
+	for _, s := range parseQueryList(c.Query("group_id")) {
+		id, err := uuid.Parse(s)
+		if err != nil || !utils.IsValidUuid(id) {
+			errs = utils.AddError(errs, "group_id", "One or more violation groups are invalid.")
+			continue
+		}
+
+		f.GroupIDs = append(f.GroupIDs, id)
+	}
+
+	for _, s := range parseQueryList(c.Query("status_code")) {
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 0 {
+			errs = utils.AddError(errs, "status_code", "One or more status codes are invalid.")
+			continue
+		}
+
+		f.StatusCodes = append(f.StatusCodes, code)
+	}
+
+	if from := c.Query("from"); len(from) > 0 {
+		t, err := parseQueryTime(from)
+		if err != nil {
+			errs = utils.AddError(errs, "from", "The start date is invalid.")
+		} else {
+			f.From = &t
+		}
+	}
+
+	if to := c.Query("to"); len(to) > 0 {
+		t, err := parseQueryTime(to)
+		if err != nil {
+			errs = utils.AddError(errs, "to", "The end date is invalid.")
+		} else {
+			f.To = &t
+		}
+	}
+
+	if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+		errs = utils.AddError(errs, "from", "The start date must be before the end date.")
+	}
+
+	if !slices.Contains([]string{DeletedExclude, DeletedInclude, DeletedOnly}, f.Deleted) {
+		errs = utils.AddError(errs, "deleted", "The deleted filter must be one of: exclude, include, only.")
+	}
+
+	return f, errs
+}
+
+func (f ReportFilters) Apply(query *gorm.DB, tableAlias string) *gorm.DB {
+	alias := ""
+
+	if len(tableAlias) > 0 {
+		alias = tableAlias + "."
+	}
+
+	if len(f.SiteIDs) > 0 {
+		query = query.Where(alias+"site_id IN @site_ids", sql.Named("site_ids", f.SiteIDs))
+	}
+
+	if len(f.GroupIDs) > 0 {
+		query = query.Where(alias+"group_id IN @group_ids", sql.Named("group_ids", f.GroupIDs))
+	}
+
+	if len(f.EffectiveDirectives) > 0 {
+		query = query.Where(alias+"effective_directive IN @effective_directives", sql.Named("effective_directives", f.EffectiveDirectives))
+	}
+
+	if len(f.ViolatedDirectives) > 0 {
+		query = query.Where(alias+"violated_directive IN @violated_directives", sql.Named("violated_directives", f.ViolatedDirectives))
+	}
+
+	if len(f.Dispositions) > 0 {
+		query = query.Where(alias+"disposition IN @dispositions", sql.Named("dispositions", f.Dispositions))
+	}
+
+	if len(f.StatusCodes) > 0 {
+		query = query.Where(alias+"status_code IN @status_codes", sql.Named("status_codes", f.StatusCodes))
+	}
+
+	if f.From != nil {
+		query = query.Where(alias+"created_at >= @from", sql.Named("from", *f.From))
+	}
+
+	if f.To != nil {
+		query = query.Where(alias+"created_at < @to", sql.Named("to", *f.To))
+	}
+
+	if len(f.BlockedURIPrefix) > 0 {
+		query = query.Where(alias+"blocked_uri LIKE @blocked_uri_prefix", sql.Named("blocked_uri_prefix", EscapeLike(f.BlockedURIPrefix)+"%"))
+	}
+
+	if len(f.BlockedURIContains) > 0 {
+		query = query.Where(alias+"blocked_uri ILIKE @blocked_uri_contains", sql.Named("blocked_uri_contains", "%"+EscapeLike(f.BlockedURIContains)+"%"))
+	}
+
+	if len(f.DocumentURIPrefix) > 0 {
+		query = query.Where(alias+"document_uri LIKE @document_uri_prefix", sql.Named("document_uri_prefix", EscapeLike(f.DocumentURIPrefix)+"%"))
+	}
+
+	if len(f.DocumentURIContains) > 0 {
+		query = query.Where(alias+"document_uri ILIKE @document_uri_contains", sql.Named("document_uri_contains", "%"+EscapeLike(f.DocumentURIContains)+"%"))
+	}
+
+	if len(f.SourceFile) > 0 {
+		query = query.Where(alias+"source_file = @source_file", sql.Named("source_file", f.SourceFile))
+	}
+
+	if len(f.Search) > 0 {
+		query = query.Where(fmt.Sprintf(`(%[1]ssearch_vector @@ %[2]s
+			OR %[1]sblocked_uri ILIKE @search_like
+			OR %[1]sdocument_uri ILIKE @search_like
+			OR %[1]ssource_file ILIKE @search_like
+			OR %[1]sscript_sample ILIKE @search_like
+			OR %[1]soriginal_policy ILIKE @search_like
+			OR @search <%% %[1]sblocked_uri
+			OR @search <%% %[1]sdocument_uri
+			OR @search <%% %[1]ssource_file)`, alias, searchTsQuery),
+			sql.Named("search", f.Search),
+			sql.Named("search_like", "%"+EscapeLike(f.Search)+"%"),
+		)
+	}
+
+	// Reports of unverified sites are kept apart from the rest
+	query = query.Where(alias+"quarantined = @quarantined", sql.Named("quarantined", f.Quarantined))
+
+	switch f.Deleted {
+	case DeletedInclude:
+		query = query.Unscoped()
+	case DeletedOnly:
+		query = query.Unscoped().Where(alias + "deleted_at IS NOT NULL")
+	}
+
+	return query
+}
+
+func (f ReportFilters) IsEmpty() bool {
+	return len(f.SiteIDs) < 1 &&
+		len(f.GroupIDs) < 1 &&
+		len(f.EffectiveDirectives) < 1 &&
+		len(f.ViolatedDirectives) < 1 &&
+		len(f.Dispositions) < 1 &&
+		len(f.StatusCodes) < 1 &&
+		f.From == nil &&
+		f.To == nil &&
+		len(f.BlockedURIPrefix) < 1 &&
+		len(f.BlockedURIContains) < 1 &&
+		len(f.DocumentURIPrefix) < 1 &&
+		len(f.DocumentURIContains) < 1 &&
+		len(f.SourceFile) < 1 &&
+		!f.Quarantined &&
+		len(f.Search) < 1
+}
+
+// Relevance of the search query, combining full-text and fuzzy matches
+func (f ReportFilters) Rank(tableAlias string) (string, []any) {
+	if len(f.Search) < 1 {
+		return "", nil
+	}
+
+	alias := ""
+
+	if len(tableAlias) > 0 {
+		alias = tableAlias + "."
+	}
+
+	expr := fmt.Sprintf(`(ts_rank(%[1]ssearch_vector, %[2]s) + greatest(
+		word_similarity(@search, %[1]sblocked_uri),
+		word_similarity(@search, %[1]sdocument_uri),
+		word_similarity(@search, %[1]ssource_file)
+	))::float8`, alias, searchTsQuery)
+
+	return expr, []any{sql.Named("search", f.Search)}
+}
+
+func (f ReportFilters) PaginatedItemOpts(routeName string, tableAlias string) PaginatedItemOpts {
+	opts := PaginatedItemOpts{
+		RouteName:  routeName,
+		TableAlias: tableAlias,
+		Filters:    f.Fingerprint(),
+		Search:     f.Search,
+	}
+
+	opts.RankExpr, opts.RankArgs = f.Rank(tableAlias)
+
+	return opts
+}
+
+func (f ReportFilters) Fingerprint() string {
+	raw, err := json.Marshal(f)
+	if err != nil {
+		sentry.CaptureException(err)
+		return ""
+	}
+
+	sum := sha256.Sum256(raw)
+
+	return hex.EncodeToString(sum[:])
+}
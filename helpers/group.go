@@ -0,0 +1,209 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	GroupNew          string = "new"
+	GroupAcknowledged string = "acknowledged"
+	GroupInProgress   string = "in_progress"
+	GroupResolved     string = "resolved"
+	GroupIgnored      string = "ignored"
+)
+
+const (
+	assigneeMe   string = "me"
+	assigneeNone string = "none"
+)
+
+func GroupStatuses() []string {
+	return []string{GroupNew, GroupAcknowledged, GroupInProgress, GroupResolved, GroupIgnored}
+}
+
+type GroupFilters struct {
+	Statuses            []string    `json:"status,omitempty"`
+	AssigneeIDs         []uuid.UUID `json:"assignee,omitempty"`
+	Unassigned          bool        `json:"unassigned,omitempty"`
+	SiteIDs             []uuid.UUID `json:"site_id,omitempty"`
+	EffectiveDirectives []string    `json:"effective_directive,omitempty"`
+	BlockedHost         string      `json:"blocked_host,omitempty"`
+}
+
+func ParseGroupFilters(c *fiber.Ctx, userID uuid.UUID) (GroupFilters, fiber.Map) {
+	errs := fiber.Map{}
+	f := GroupFilters{
+		EffectiveDirectives: parseQueryList(c.Query("effective_directive")),
+		BlockedHost:         strings.ToLower(strings.TrimSpace(c.Query("blocked_host"))),
+	}
+
+	for _, s := range parseQueryList(strings.ToLower(c.Query("status"))) {
+		if !slices.Contains(GroupStatuses(), s) {
+			errs = utils.AddError(errs, "status", "The status must be one of: "+strings.Join(GroupStatuses(), ", ")+".")
+			continue
+		}
+
+		f.Statuses = append(f.Statuses, s)
+	}
+
+	for _, s := range parseQueryList(c.Query("assignee")) {
+		switch strings.ToLower(s) {
+		case assigneeMe:
+			f.AssigneeIDs = append(f.AssigneeIDs, userID)
+		case assigneeNone:
+			f.Unassigned = true
+		default:
+			id, err := uuid.Parse(s)
+			if err != nil || !utils.IsValidUuid(id) {
+				errs = utils.AddError(errs, "assignee", "One or more assignees are invalid.")
+				continue
+			}
+
+			f.AssigneeIDs = append(f.AssigneeIDs, id)
+		}
+	}
+
+	for _, s := range parseQueryList(c.Query("site_id")) {
+		id, err := uuid.Parse(s)
+		if err != nil || !utils.IsValidUuid(id) {
+			errs = utils.AddError(errs, "site_id", "One or more sites are invalid.")
+			continue
+		}
+
+		f.SiteIDs = append(f.SiteIDs, id)
+	}
+
+	return f, errs
+}
+
+func (f GroupFilters) Apply(query *gorm.DB, tableAlias string) *gorm.DB {
+	alias := ""
+
+	if len(tableAlias) > 0 {
+		alias = tableAlias + "."
+	}
+
+	if len(f.Statuses) > 0 {
+		query = query.Where(alias+"status IN @statuses", sql.Named("statuses", f.Statuses))
+	}
+
+	switch {
+	case len(f.AssigneeIDs) > 0 && f.Unassigned:
+		query = query.Where("("+alias+"assignee_id IN @assignees OR "+alias+"assignee_id IS NULL)", sql.Named("assignees", f.AssigneeIDs))
+	case len(f.AssigneeIDs) > 0:
+		query = query.Where(alias+"assignee_id IN @assignees", sql.Named("assignees", f.AssigneeIDs))
+	case f.Unassigned:
+		query = query.Where(alias + "assignee_id IS NULL")
+	}
+
+	if len(f.SiteIDs) > 0 {
+		query = query.Where(alias+"site_id IN @site_ids", sql.Named("site_ids", f.SiteIDs))
+	}
+
+	if len(f.EffectiveDirectives) > 0 {
+		query = query.Where(alias+"effective_directive IN @effective_directives", sql.Named("effective_directives", f.EffectiveDirectives))
+	}
+
+	if len(f.BlockedHost) > 0 {
+		query = query.Where(alias+"blocked_host = @blocked_host", sql.Named("blocked_host", f.BlockedHost))
+	}
+
+	return query
+}
+
+func (f GroupFilters) Fingerprint() string {
+	raw, err := json.Marshal(f)
+	if err != nil {
+		sentry.CaptureException(err)
+		return ""
+	}
+
+	sum := sha256.Sum256(raw)
+
+	return hex.EncodeToString(sum[:])
+}
+
+type upsertedGroup struct {
+	ID       uuid.UUID
+	Inserted bool
+}
+
+// Adds the report to its violation group, reopening resolved groups when the
+// violation happens again after it was resolved.
+func UpsertViolationGroup(tx *gorm.DB, r *models.Report) (uuid.UUID, bool, error) {
+	host := ""
+
+	if r.BlockedHost != nil {
+		host = *r.BlockedHost
+	}
+
+	seenAt := r.CreatedAt
+
+	if seenAt.IsZero() {
+		seenAt = time.Now()
+	}
+
+	group := upsertedGroup{}
+	if err := tx.Raw(`INSERT INTO violation_groups (site_id, effective_directive, blocked_host, status, count, first_seen_at, last_seen_at)
+		VALUES (@site_id, @effective_directive, @blocked_host, @status_new, 1, @seen_at, @seen_at)
+		ON CONFLICT (site_id, effective_directive, blocked_host) DO UPDATE SET
+			count = violation_groups.count + 1,
+			first_seen_at = least(violation_groups.first_seen_at, EXCLUDED.first_seen_at),
+			last_seen_at = greatest(violation_groups.last_seen_at, EXCLUDED.last_seen_at),
+			status = CASE WHEN violation_groups.status = @status_resolved AND EXCLUDED.last_seen_at > violation_groups.resolved_at THEN @status_new ELSE violation_groups.status END,
+			reopened_at = CASE WHEN violation_groups.status = @status_resolved AND EXCLUDED.last_seen_at > violation_groups.resolved_at THEN clock_timestamp() ELSE violation_groups.reopened_at END,
+			updated_at = clock_timestamp()
+		RETURNING id, (xmax = 0) AS inserted`,
+		sql.Named("site_id", r.SiteID),
+		sql.Named("effective_directive", r.EffectiveDirective),
+		sql.Named("blocked_host", host),
+		sql.Named("seen_at", seenAt),
+		sql.Named("status_new", GroupNew),
+		sql.Named("status_resolved", GroupResolved),
+	).Scan(&group).Error; err != nil {
+		return uuid.Nil, false, err
+	}
+
+	return group.ID, group.Inserted, nil
+}
+
+// Groups the reports received before violation groups existed
+func BackfillViolationGroups(tx *gorm.DB) (int64, error) {
+	if err := tx.Exec(`INSERT INTO violation_groups (site_id, effective_directive, blocked_host, status, count, first_seen_at, last_seen_at)
+		SELECT site_id, effective_directive, coalesce(blocked_host, ''), @status_new, count(*), min(created_at), max(created_at)
+		FROM reports
+		WHERE group_id IS NULL AND NOT quarantined
+		GROUP BY site_id, effective_directive, coalesce(blocked_host, '')
+		ON CONFLICT (site_id, effective_directive, blocked_host) DO UPDATE SET
+			count = violation_groups.count + EXCLUDED.count,
+			first_seen_at = least(violation_groups.first_seen_at, EXCLUDED.first_seen_at),
+			last_seen_at = greatest(violation_groups.last_seen_at, EXCLUDED.last_seen_at),
+			updated_at = clock_timestamp()`,
+		sql.Named("status_new", GroupNew),
+	).Error; err != nil {
+		return 0, err
+	}
+
+	result := tx.Exec(`UPDATE reports r SET group_id = g.id
+		FROM violation_groups g
+		WHERE r.group_id IS NULL
+			AND NOT r.quarantined
+			AND g.site_id = r.site_id
+			AND g.effective_directive = r.effective_directive
+			AND g.blocked_host = coalesce(r.blocked_host, '')`)
+
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,242 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrIdentityUserNotFound = errors.New("There is no account for this user.")
+	ErrIdentityUserInactive = errors.New("The user account is not active.")
+	ErrIdentityEmailInvalid = errors.New("The identity provider did not return a verified email address.")
+)
+
+// User authenticated by an external identity provider, like OIDC or LDAP
+type ExternalIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     *string
+	LastName      *string
+	Groups        []string
+}
+
+// How users of an identity provider are provisioned and get their roles
+type IdentityOptions struct {
+	AutoProvision bool
+	GroupRoles    map[string][]string
+	DefaultRole   string
+	Organization  string
+}
+
+// Roles granted by the groups of the user, and every role managed through groups.
+// Group names are not case sensitive.
+func identityGroupRoles(groups []string, mapping map[string][]string) ([]string, []string) {
+	granted, managed := []string{}, []string{}
+
+	for group, roles := range mapping {
+		member := slices.ContainsFunc(groups, func(g string) bool {
+			return strings.EqualFold(g, group)
+		})
+
+		for _, r := range roles {
+			if !slices.Contains(managed, r) {
+				managed = append(managed, r)
+			}
+
+			if member && !slices.Contains(granted, r) {
+				granted = append(granted, r)
+			}
+		}
+	}
+
+	return granted, managed
+}
+
+// Gives the user the global roles mapped from their groups, and removes the
+// mapped roles they are no longer entitled to. Other roles are left as they are.
+func syncIdentityRoles(tx *gorm.DB, user *models.User, groups []string, opts IdentityOptions, provisioned bool) error {
+	granted, managed := identityGroupRoles(groups, opts.GroupRoles)
+
+	if provisioned && len(granted) < 1 && RoleRank(opts.DefaultRole) >= 0 {
+		granted = append(granted, opts.DefaultRole)
+
+		if !slices.Contains(managed, opts.DefaultRole) {
+			managed = append(managed, opts.DefaultRole)
+		}
+	}
+
+	for _, name := range managed {
+		role := &models.Role{}
+		if err := tx.Where(&models.Role{Name: name}).First(&role).Error; err != nil {
+			return fmt.Errorf("Invalid mapped role '%s': %w", name, err)
+		}
+
+		userRole := &models.UserRole{}
+		result := tx.Where(&models.UserRole{UserID: user.ID, RoleID: role.ID}).Where("site_id IS NULL").Limit(1).Find(&userRole)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if slices.Contains(granted, name) {
+			if result.RowsAffected > 0 {
+				continue
+			}
+
+			userRole = &models.UserRole{UserID: user.ID, RoleID: role.ID, CreatedByID: user.ID, UpdatedByID: user.ID}
+			if err := tx.Omit("User", "Role", "Site", "CreatedBy", "UpdatedBy", "DeletedBy").Create(&userRole).Error; err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if result.RowsAffected < 1 {
+			continue
+		}
+
+		if name == superAdminRole {
+			n, err := CountSuperAdmins(tx)
+			if err != nil {
+				return err
+			}
+
+			if n < 2 {
+				continue
+			}
+		}
+
+		if err := tx.Model(&userRole).Updates(&models.UserRole{UpdatedByID: user.ID, DeletedByID: &user.ID}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&userRole).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func provisionIdentityUser(tx *gorm.DB, identity *ExternalIdentity, opts IdentityOptions) (*models.User, error) {
+	// Never used, the provider authenticates the user
+	password, err := utils.RandomString(64)
+	if err != nil || len(password) < 1 {
+		return nil, fmt.Errorf("Error generating random string: %w", err)
+	}
+
+	active := true
+	user := &models.User{
+		FirstName: identity.FirstName,
+		LastName:  identity.LastName,
+		Email:     identity.Email,
+		Password:  utils.HashPassword(password),
+		Active:    &active,
+	}
+
+	if err := tx.Omit("Roles").Create(&user).Error; err != nil {
+		return nil, err
+	}
+
+	if len(opts.Organization) > 0 {
+		org := &models.Organization{}
+		if err := tx.Where(&models.Organization{Slug: opts.Organization}).First(&org).Error; err != nil {
+			return nil, fmt.Errorf("Invalid organization '%s': %w", opts.Organization, err)
+		}
+
+		if err := AddOrganizationMember(tx, org.ID, user.ID, OrganizationMember); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// User of the identity, linked by verified email or provisioned on the first login
+func GetIdentityUser(identity *ExternalIdentity, opts IdentityOptions) (*models.User, error) {
+	user := &models.User{}
+
+	err := app.DB().Transaction(func(tx *gorm.DB) error {
+		provisioned := false
+		link := &models.UserIdentity{}
+		err := tx.Where(&models.UserIdentity{Issuer: identity.Issuer, Subject: identity.Subject}).Preload("User").First(&link).Error
+
+		switch {
+		case err == nil:
+			user = &link.User
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return err
+		case !identity.EmailVerified || !utils.IsValidEmail(identity.Email):
+			return ErrIdentityEmailInvalid
+		default:
+			err := tx.Where(&models.User{Email: identity.Email}).First(&user).Error
+
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound) && opts.AutoProvision:
+				if user, err = provisionIdentityUser(tx, identity, opts); err != nil {
+					return err
+				}
+
+				provisioned = true
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				return ErrIdentityUserNotFound
+			case err != nil:
+				return err
+			}
+
+			link = &models.UserIdentity{UserID: user.ID, Issuer: identity.Issuer, Subject: identity.Subject}
+		}
+
+		if user.Active == nil || !*user.Active {
+			return ErrIdentityUserInactive
+		}
+
+		// The provider authenticated the user, so a forced password change only
+		// applies to the local password, which is replaced so it cannot be used
+		if user.MustChangePassword != nil && *user.MustChangePassword {
+			password, err := utils.RandomPassword(35)
+			if err != nil {
+				return err
+			}
+
+			mustChangePass := false
+			user.Password = utils.HashPassword(password)
+			user.MustChangePassword = &mustChangePass
+
+			if err := tx.Model(&user).Select("password", "must_change_password").Updates(&models.User{
+				Password:           user.Password,
+				MustChangePassword: &mustChangePass,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		link.Email = identity.Email
+		link.LastLoginAt = &now
+
+		if err := tx.Omit("User").Save(&link).Error; err != nil {
+			return err
+		}
+
+		return syncIdentityRoles(tx, user, identity.Groups, opts, provisioned)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := InvalidateUserCache(user.ID); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
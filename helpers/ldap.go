@@ -0,0 +1,241 @@
+package helpers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const ldapTimeout time.Duration = 10 * time.Second
+
+var ErrLDAPInvalidCredentials = errors.New("The user credentials are invalid.")
+
+// Opens the connections to the directory server
+var ldapDialer = dialLDAP
+
+// Replaces the function used to connect to the directory, such as one reaching a local stand-in
+func SetLDAPDialer(d func() (ldap.Client, error)) {
+	ldapDialer = d
+}
+
+// Whether the user must authenticate against the directory instead of the local password
+func IsLDAPUser(email string) bool {
+	if !utils.LDAPEnabled() {
+		return false
+	}
+
+	_, domain, ok := strings.Cut(strings.ToLower(email), "@")
+
+	return ok && slices.Contains(utils.LDAPDomains(), domain)
+}
+
+func ldapTLSConfig(u *url.URL) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: u.Hostname(),
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile := os.Getenv("LDAP_CA_FILE"); len(caFile) > 0 {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read LDAP CA file: %w", err)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("The LDAP CA file has no valid certificates.")
+		}
+	}
+
+	return config, nil
+}
+
+// Connection over LDAPS or StartTLS, plain connections are only allowed in debug mode
+func dialLDAP() (ldap.Client, error) {
+	u, err := url.Parse(os.Getenv("LDAP_URL"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid LDAP URL: %w", err)
+	}
+
+	if u.Scheme != "ldaps" && !utils.LDAPStartTLS() && !utils.IsDebug() {
+		return nil, errors.New("The LDAP connection must use LDAPS or StartTLS.")
+	}
+
+	config, err := ldapTLSConfig(u)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ldap.DialURL(u.String(), ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}), ldap.DialWithTLSConfig(config))
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetTimeout(ldapTimeout)
+
+	if u.Scheme != "ldaps" && utils.LDAPStartTLS() {
+		if err := conn.StartTLS(config); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func ldapFilter(filter string, values map[string]string) string {
+	for k, v := range values {
+		filter = strings.ReplaceAll(filter, "{"+k+"}", ldap.EscapeFilter(v))
+	}
+
+	return filter
+}
+
+// Value of the first relative DN, the common name of most groups
+func ldapGroupName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) < 1 || len(parsed.RDNs[0].Attributes) < 1 {
+		return dn
+	}
+
+	return parsed.RDNs[0].Attributes[0].Value
+}
+
+func searchLDAPGroups(conn ldap.Client, entry *ldap.Entry) ([]string, error) {
+	groups := []string{}
+
+	if len(utils.LDAPGroupFilter()) < 1 {
+		for _, dn := range entry.GetAttributeValues("memberOf") {
+			groups = append(groups, ldapGroupName(dn))
+		}
+
+		return groups, nil
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		utils.LDAPGroupBaseDN(),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(ldapTimeout.Seconds()), false,
+		ldapFilter(utils.LDAPGroupFilter(), map[string]string{"dn": entry.DN}),
+		[]string{"cn"},
+		nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("Could not search LDAP groups: %w", err)
+	}
+
+	for _, g := range result.Entries {
+		name := g.GetAttributeValue("cn")
+
+		if len(name) < 1 {
+			name = ldapGroupName(g.DN)
+		}
+
+		groups = append(groups, name)
+	}
+
+	return groups, nil
+}
+
+// Finds the user in the directory with the service account, then binds as the user to check the password
+func AuthenticateLDAP(email string, password string) (*ExternalIdentity, error) {
+	// An empty password would be an unauthenticated bind, which always succeeds
+	if len(email) < 1 || len(password) < 1 {
+		return nil, ErrLDAPInvalidCredentials
+	}
+
+	conn, err := ldapDialer()
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to LDAP server: %w", err)
+	}
+	defer conn.Close()
+
+	if bindDN := os.Getenv("LDAP_BIND_DN"); len(bindDN) > 0 {
+		if err := conn.Bind(bindDN, os.Getenv("LDAP_BIND_PASSWORD")); err != nil {
+			return nil, fmt.Errorf("Could not bind LDAP service account: %w", err)
+		}
+	}
+
+	username, _, _ := strings.Cut(email, "@")
+	attributes := []string{"mail", "givenName", "sn"}
+
+	if len(utils.LDAPGroupFilter()) < 1 {
+		attributes = append(attributes, "memberOf")
+	}
+
+	// Two results are enough to know the filter is ambiguous
+	result, err := conn.Search(ldap.NewSearchRequest(
+		os.Getenv("LDAP_BASE_DN"),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false,
+		ldapFilter(utils.LDAPUserFilter(), map[string]string{"email": email, "username": username}),
+		attributes,
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("Could not search LDAP user: %w", err)
+	}
+
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrLDAPInvalidCredentials
+	}
+
+	entry := result.Entries[0]
+
+	// Searched before binding as the user, who may not be allowed to read groups
+	groups, err := searchLDAPGroups(conn, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrLDAPInvalidCredentials
+		}
+
+		return nil, fmt.Errorf("Could not bind LDAP user: %w", err)
+	}
+
+	// The filter may not match by email, the typed one could belong to someone else
+	mail := entry.GetAttributeValue("mail")
+	if len(mail) < 1 {
+		return nil, ErrIdentityEmailInvalid
+	}
+
+	identity := &ExternalIdentity{
+		Issuer:  os.Getenv("LDAP_URL"),
+		Subject: entry.DN,
+		// The directory is trusted, the address comes from the entry of the user
+		Email:         mail,
+		EmailVerified: true,
+		Groups:        groups,
+	}
+
+	if v := entry.GetAttributeValue("givenName"); len(v) > 0 {
+		identity.FirstName = &v
+	}
+
+	if v := entry.GetAttributeValue("sn"); len(v) > 0 {
+		identity.LastName = &v
+	}
+
+	return identity, nil
+}
+
+func GetLDAPUser(identity *ExternalIdentity) (*models.User, error) {
+	return GetIdentityUser(identity, IdentityOptions{
+		AutoProvision: utils.LDAPAutoProvision(),
+		GroupRoles:    utils.LDAPGroupRoles(),
+		DefaultRole:   utils.LDAPDefaultRole(),
+		Organization:  utils.LDAPOrganization(),
+	})
+}
@@ -0,0 +1,335 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/testutil"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	ldapTestBindDN   = "cn=admin,dc=example,dc=org"
+	ldapTestUserDN   = "uid=user01,ou=users,dc=example,dc=org"
+	ldapTestPassword = "password1"
+)
+
+// Directory server holding the service account and a single user
+type ldapTestConn struct {
+	ldap.Client
+	bound    string
+	closed   bool
+	entries  []*ldap.Entry
+	groups   []*ldap.Entry
+	searches []*ldap.SearchRequest
+}
+
+func (c *ldapTestConn) Bind(username string, password string) error {
+	switch {
+	case username == ldapTestBindDN && password == "adminpassword",
+		username == ldapTestUserDN && password == ldapTestPassword:
+		c.bound = username
+		return nil
+	}
+
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("Invalid credentials"))
+}
+
+func (c *ldapTestConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if c.bound != ldapTestBindDN {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("Insufficient access"))
+	}
+
+	c.searches = append(c.searches, req)
+
+	if req.BaseDN == "ou=groups,dc=example,dc=org" {
+		return &ldap.SearchResult{Entries: c.groups}, nil
+	}
+
+	if req.SizeLimit > 0 && len(c.entries) > req.SizeLimit {
+		return &ldap.SearchResult{Entries: c.entries[:req.SizeLimit]}, ldap.NewError(ldap.LDAPResultSizeLimitExceeded, errors.New("Size limit exceeded"))
+	}
+
+	return &ldap.SearchResult{Entries: c.entries}, nil
+}
+
+func (c *ldapTestConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newLDAPTestConn(t *testing.T) *ldapTestConn {
+	t.Helper()
+
+	conn := &ldapTestConn{
+		entries: []*ldap.Entry{ldap.NewEntry(ldapTestUserDN, map[string][]string{
+			"mail":      {"user01@example.org"},
+			"givenName": {"User"},
+			"sn":        {"One"},
+			"memberOf":  {"cn=readers,ou=groups,dc=example,dc=org"},
+		})},
+		groups: []*ldap.Entry{
+			ldap.NewEntry("cn=readers,ou=groups,dc=example,dc=org", map[string][]string{"cn": {"readers"}}),
+			ldap.NewEntry("cn=writers,ou=groups,dc=example,dc=org", nil),
+		},
+	}
+
+	t.Setenv("LDAP_ENABLE", "true")
+	t.Setenv("LDAP_URL", "ldaps://ldap.example.org")
+	t.Setenv("LDAP_DOMAINS", "example.org")
+	t.Setenv("LDAP_BIND_DN", ldapTestBindDN)
+	t.Setenv("LDAP_BIND_PASSWORD", "adminpassword")
+	t.Setenv("LDAP_BASE_DN", "ou=users,dc=example,dc=org")
+	t.Setenv("LDAP_USER_FILTER", "(uid={username})")
+	t.Setenv("LDAP_GROUP_FILTER", "")
+	t.Setenv("LDAP_GROUP_BASE_DN", "")
+
+	dialer := ldapDialer
+	SetLDAPDialer(func() (ldap.Client, error) {
+		return conn, nil
+	})
+
+	t.Cleanup(func() {
+		SetLDAPDialer(dialer)
+	})
+
+	return conn
+}
+
+func TestAuthenticateLDAP(t *testing.T) {
+	conn := newLDAPTestConn(t)
+
+	identity, err := AuthenticateLDAP("user01@example.org", ldapTestPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !conn.closed {
+		t.Error("The connection was not closed.")
+	}
+
+	if conn.bound != ldapTestUserDN {
+		t.Errorf("got bound DN %s, want %s", conn.bound, ldapTestUserDN)
+	}
+
+	if len(conn.searches) != 1 {
+		t.Fatalf("got %d searches, want 1", len(conn.searches))
+	}
+
+	req := conn.searches[0]
+	if req.BaseDN != "ou=users,dc=example,dc=org" || req.Filter != "(uid=user01)" || req.SizeLimit != 2 || !slices.Contains(req.Attributes, "mail") || !slices.Contains(req.Attributes, "memberOf") {
+		t.Errorf("got search %+v", req)
+	}
+
+	if identity.Issuer != "ldaps://ldap.example.org" || identity.Subject != ldapTestUserDN || identity.Email != "user01@example.org" || !identity.EmailVerified {
+		t.Errorf("got identity %+v", identity)
+	}
+
+	if identity.FirstName == nil || *identity.FirstName != "User" || identity.LastName == nil || *identity.LastName != "One" {
+		t.Errorf("got name %v %v", identity.FirstName, identity.LastName)
+	}
+
+	if !slices.Equal(identity.Groups, []string{"readers"}) {
+		t.Errorf("got groups %v", identity.Groups)
+	}
+}
+
+func TestAuthenticateLDAPMail(t *testing.T) {
+	conn := newLDAPTestConn(t)
+
+	// Found by username, the email of the entry is used instead of the typed one
+	conn.entries[0] = ldap.NewEntry(ldapTestUserDN, map[string][]string{"mail": {"User01@Example.org"}})
+
+	identity, err := AuthenticateLDAP("user01@other.example.org", ldapTestPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Email != "User01@Example.org" {
+		t.Errorf("got email %s, want the one of the directory", identity.Email)
+	}
+
+	conn.entries[0] = ldap.NewEntry(ldapTestUserDN, map[string][]string{"givenName": {"User"}})
+
+	if _, err := AuthenticateLDAP("user01@example.org", ldapTestPassword); !errors.Is(err, ErrIdentityEmailInvalid) {
+		t.Errorf("got error %v, want %v", err, ErrIdentityEmailInvalid)
+	}
+}
+
+func TestAuthenticateLDAPGroupFilter(t *testing.T) {
+	conn := newLDAPTestConn(t)
+
+	t.Setenv("LDAP_GROUP_FILTER", "(&(objectClass=groupOfNames)(member={dn}))")
+	t.Setenv("LDAP_GROUP_BASE_DN", "ou=groups,dc=example,dc=org")
+
+	identity, err := AuthenticateLDAP("user01@example.org", ldapTestPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(conn.searches) != 2 {
+		t.Fatalf("got %d searches, want 2", len(conn.searches))
+	}
+
+	if slices.Contains(conn.searches[0].Attributes, "memberOf") {
+		t.Error("The memberOf attribute is not needed with a group filter.")
+	}
+
+	want := fmt.Sprintf("(&(objectClass=groupOfNames)(member=%s))", ldap.EscapeFilter(ldapTestUserDN))
+	if got := conn.searches[1].Filter; got != want {
+		t.Errorf("got group filter %s, want %s", got, want)
+	}
+
+	// The common name, or the first value of the DN when it is missing
+	if !slices.Equal(identity.Groups, []string{"readers", "writers"}) {
+		t.Errorf("got groups %v", identity.Groups)
+	}
+}
+
+func TestAuthenticateLDAPRejected(t *testing.T) {
+	entry := ldap.NewEntry("uid=user02,ou=users,dc=example,dc=org", map[string][]string{"mail": {"user02@example.org"}})
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		entries  int
+		want     error
+	}{
+		{"empty password", "user01@example.org", "", 1, ErrLDAPInvalidCredentials},
+		{"empty email", "", ldapTestPassword, 1, ErrLDAPInvalidCredentials},
+		{"wrong password", "user01@example.org", "password2", 1, ErrLDAPInvalidCredentials},
+		{"no entries", "user01@example.org", ldapTestPassword, 0, ErrLDAPInvalidCredentials},
+		{"ambiguous filter", "user01@example.org", ldapTestPassword, 3, ErrLDAPInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		conn := newLDAPTestConn(t)
+
+		switch tt.entries {
+		case 0:
+			conn.entries = nil
+		case 3:
+			conn.entries = append(conn.entries, entry, entry)
+		}
+
+		identity, err := AuthenticateLDAP(tt.email, tt.password)
+		if !errors.Is(err, tt.want) || identity != nil {
+			t.Errorf("%s: got identity %+v and error %v, want %v", tt.name, identity, err, tt.want)
+		}
+
+		if conn.bound == ldapTestUserDN {
+			t.Errorf("%s: the user was bound.", tt.name)
+		}
+	}
+
+	conn := newLDAPTestConn(t)
+	t.Setenv("LDAP_BIND_PASSWORD", "wrong")
+
+	if _, err := AuthenticateLDAP("user01@example.org", ldapTestPassword); err == nil || errors.Is(err, ErrLDAPInvalidCredentials) {
+		t.Errorf("Service account: got error %v, want a connection error", err)
+	}
+
+	if len(conn.searches) > 0 {
+		t.Error("The directory was searched without binding the service account.")
+	}
+}
+
+func TestGetLDAPUser(t *testing.T) {
+	db := testutil.DB(t)
+	newLDAPTestConn(t)
+
+	email := fmt.Sprintf("%s@example.org", uuid.NewString())
+	identity := &ExternalIdentity{
+		Issuer:        "ldaps://ldap.example.org",
+		Subject:       fmt.Sprintf("uid=%s,ou=users,dc=example,dc=org", uuid.NewString()),
+		Email:         email,
+		EmailVerified: true,
+		Groups:        []string{"Readers"},
+	}
+
+	t.Setenv("LDAP_AUTO_PROVISION", "false")
+	t.Setenv("LDAP_GROUP_ROLES", "readers=viewer")
+	t.Setenv("LDAP_DEFAULT_ROLE", "")
+	t.Setenv("LDAP_ORGANIZATION", "")
+
+	if _, err := GetLDAPUser(identity); !errors.Is(err, ErrIdentityUserNotFound) {
+		t.Fatalf("got error %v, want %v", err, ErrIdentityUserNotFound)
+	}
+
+	t.Setenv("LDAP_AUTO_PROVISION", "true")
+
+	user, err := GetLDAPUser(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Unscoped().Where(&models.UserIdentity{UserID: user.ID}).Delete(&models.UserIdentity{})
+		db.Unscoped().Where(&models.UserRole{UserID: user.ID}).Delete(&models.UserRole{})
+		db.Unscoped().Delete(&user)
+	})
+
+	if user.Email != email || user.Active == nil || !*user.Active {
+		t.Errorf("got user %s, active %v", user.Email, user.Active)
+	}
+
+	roles := func() []string {
+		names := []string{}
+		if err := db.Model(&models.UserRole{}).Joins("JOIN roles ON roles.id = user_roles.role_id").Where("user_roles.user_id = ? AND user_roles.site_id IS NULL", user.ID).Pluck("roles.name", &names).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		return names
+	}
+
+	if got := roles(); !slices.Equal(got, []string{"viewer"}) {
+		t.Errorf("got roles %v, want [viewer]", got)
+	}
+
+	// Linked by the identity, the mapped role is removed with the group
+	identity.Groups = nil
+
+	linked, err := GetLDAPUser(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if linked.ID != user.ID {
+		t.Errorf("got user %s, want %s", linked.ID, user.ID)
+	}
+
+	if got := roles(); len(got) > 0 {
+		t.Errorf("got roles %v, want none", got)
+	}
+
+	// A forced password change replaces the local password instead of blocking the login
+	mustChangePass := true
+	if err := db.Model(&user).Select("must_change_password").Updates(&models.User{MustChangePassword: &mustChangePass}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetLDAPUser(identity); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &models.User{}
+	if err := db.Where(&models.User{ID: user.ID}).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.MustChangePassword == nil || *stored.MustChangePassword || stored.Password == user.Password {
+		t.Errorf("got must_change_password %v, password changed %v", stored.MustChangePassword, stored.Password != user.Password)
+	}
+
+	// Without a verified email, unknown identities are not linked or provisioned
+	other := &ExternalIdentity{Issuer: identity.Issuer, Subject: uuid.NewString(), Email: email}
+
+	if _, err := GetLDAPUser(other); !errors.Is(err, ErrIdentityEmailInvalid) {
+		t.Errorf("got error %v, want %v", err, ErrIdentityEmailInvalid)
+	}
+}
@@ -0,0 +1,279 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	NotificationEmail   string = "email"
+	NotificationInApp   string = "in_app"
+	NotificationWebhook string = "webhook"
+)
+
+const (
+	NotificationImmediate string = "immediate"
+	NotificationDigest    string = "digest"
+	NotificationOff       string = "off"
+)
+
+// Sent to personal webhooks that receive digests
+const WebhookNotificationDigest string = "notification.digest"
+
+func NotificationChannels() []string {
+	return []string{NotificationEmail, NotificationInApp, NotificationWebhook}
+}
+
+func NotificationFrequencies() []string {
+	return []string{NotificationImmediate, NotificationDigest, NotificationOff}
+}
+
+func NotificationEventTypes() []string {
+	return []string{WebhookReportCreated, WebhookGroupNew, WebhookAlertFired}
+}
+
+// Frequency used when the user has not set a preference
+func DefaultNotificationFrequency(event string, channel string) string {
+	switch channel {
+	case NotificationInApp:
+		return NotificationImmediate
+	case NotificationEmail:
+		if event == WebhookReportCreated {
+			return NotificationDigest
+		}
+
+		return NotificationImmediate
+	default:
+		return NotificationOff
+	}
+}
+
+// Content shared by every channel
+type NotificationContent struct {
+	Event  string
+	SiteID uuid.UUID
+	Title  string
+	Body   string
+	URL    string
+}
+
+type NotificationRecipient struct {
+	UserID      uuid.UUID
+	Email       string
+	Name        string
+	Frequencies map[string]string
+}
+
+// Effective preferences of the user, by event type and channel
+func GetNotificationPreferences(userID uuid.UUID) (map[string]map[string]string, error) {
+	prefs := []models.NotificationPreference{}
+	if err := app.DB().Where(&models.NotificationPreference{UserID: userID}).Find(&prefs).Error; err != nil {
+		return nil, err
+	}
+
+	result := map[string]map[string]string{}
+
+	for _, event := range NotificationEventTypes() {
+		result[event] = map[string]string{}
+
+		for _, channel := range NotificationChannels() {
+			result[event][channel] = DefaultNotificationFrequency(event, channel)
+		}
+	}
+
+	for _, p := range prefs {
+		if _, ok := result[p.EventType]; ok {
+			result[p.EventType][p.Channel] = p.Frequency
+		}
+	}
+
+	return result, nil
+}
+
+func SetNotificationPreferences(userID uuid.UUID, prefs []models.NotificationPreference) error {
+	if len(prefs) < 1 {
+		return nil
+	}
+
+	for i := range prefs {
+		prefs[i].UserID = userID
+	}
+
+	return app.DB().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "event_type"}, {Name: "channel"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"frequency": gorm.Expr("EXCLUDED.frequency"), "updated_at": gorm.Expr("clock_timestamp()")}),
+	}).Omit(clause.Associations).Create(&prefs).Error
+}
+
+// Active users subscribed to the site, with their frequency for each channel
+func GetNotificationRecipients(event string, siteID uuid.UUID) ([]NotificationRecipient, error) {
+	users := []models.User{}
+	if err := app.DB().Model(&models.User{}).
+		Joins("INNER JOIN site_subscriptions ss ON ss.user_id = users.id").
+		Where("ss.site_id = ? AND users.active = ?", siteID, true).
+		// Subscriptions outlive memberships, only current members are notified
+		Where(`EXISTS (
+			SELECT 1 FROM organization_members om
+			INNER JOIN sites s ON s.organization_id = om.organization_id
+			WHERE om.user_id = users.id AND s.id = ss.site_id
+		) OR EXISTS (
+			SELECT 1 FROM user_roles ur
+			INNER JOIN roles r ON r.id = ur.role_id
+			WHERE ur.user_id = users.id AND ur.deleted_at IS NULL AND r.name = ?
+		)`, superAdminRole).
+		Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	if len(users) < 1 {
+		return nil, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(users))
+
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+
+	prefs := []models.NotificationPreference{}
+	if err := app.DB().Where("user_id IN ? AND event_type = ?", ids, event).Find(&prefs).Error; err != nil {
+		return nil, err
+	}
+
+	recipients := make([]NotificationRecipient, 0, len(users))
+
+	for _, u := range users {
+		r := NotificationRecipient{UserID: u.ID, Email: u.Email, Name: u.GetFullName(), Frequencies: map[string]string{}}
+
+		for _, channel := range NotificationChannels() {
+			r.Frequencies[channel] = DefaultNotificationFrequency(event, channel)
+		}
+
+		for _, p := range prefs {
+			if p.UserID == u.ID {
+				r.Frequencies[p.Channel] = p.Frequency
+			}
+		}
+
+		recipients = append(recipients, r)
+	}
+
+	return recipients, nil
+}
+
+func CreateInAppNotification(userID uuid.UUID, n NotificationContent) error {
+	notification := &models.Notification{
+		UserID:    userID,
+		SiteID:    uuidPtr(n.SiteID),
+		EventType: n.Event,
+		Title:     truncateWebhookText(n.Title, 255),
+		Body:      n.Body,
+		URL:       utils.ToStringPtr(n.URL),
+	}
+
+	return app.DB().Omit(clause.Associations).Create(&notification).Error
+}
+
+func QueueNotificationDigest(userID uuid.UUID, channel string, n NotificationContent) error {
+	item := &models.NotificationDigestItem{
+		UserID:    userID,
+		Channel:   channel,
+		SiteID:    uuidPtr(n.SiteID),
+		EventType: n.Event,
+		Title:     truncateWebhookText(n.Title, 255),
+		Body:      n.Body,
+		URL:       utils.ToStringPtr(n.URL),
+	}
+
+	return app.DB().Omit(clause.Associations).Create(&item).Error
+}
+
+type PendingDigest struct {
+	User    models.User
+	Channel string
+	Items   []models.NotificationDigestItem
+}
+
+type DigestItem struct {
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	URL       string    `json:"url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type DigestPayload struct {
+	Count int          `json:"count"`
+	Items []DigestItem `json:"items"`
+}
+
+// Summary of the digest, listing up to limit items
+func (d PendingDigest) Payload(limit int) DigestPayload {
+	p := DigestPayload{Count: len(d.Items), Items: []DigestItem{}}
+
+	for _, item := range d.Items[:min(len(d.Items), limit)] {
+		di := DigestItem{Title: item.Title, Body: item.Body, CreatedAt: item.CreatedAt}
+
+		if item.URL != nil {
+			di.URL = *item.URL
+		}
+
+		p.Items = append(p.Items, di)
+	}
+
+	return p
+}
+
+// Pending digest items, grouped by user and channel
+func GetPendingDigests(ctx context.Context) ([]PendingDigest, error) {
+	items := []models.NotificationDigestItem{}
+	if err := app.DB().WithContext(ctx).
+		Where("sent_at IS NULL").
+		Preload("User").
+		Order("user_id, channel, created_at").
+		Find(&items).Error; err != nil {
+		return nil, fmt.Errorf("Could not get pending digest items: %w", err)
+	}
+
+	digests := []PendingDigest{}
+
+	for _, item := range items {
+		last := len(digests) - 1
+
+		if last < 0 || digests[last].User.ID != item.UserID || digests[last].Channel != item.Channel {
+			digests = append(digests, PendingDigest{User: item.User, Channel: item.Channel})
+			last++
+		}
+
+		digests[last].Items = append(digests[last].Items, item)
+	}
+
+	return digests, nil
+}
+
+func MarkDigestSent(ctx context.Context, d PendingDigest) error {
+	ids := make([]uuid.UUID, 0, len(d.Items))
+
+	for _, item := range d.Items {
+		ids = append(ids, item.ID)
+	}
+
+	return app.DB().WithContext(ctx).Model(&models.NotificationDigestItem{}).
+		Where("id IN ?", ids).
+		Update("sent_at", time.Now()).Error
+}
+
+func uuidPtr(id uuid.UUID) *uuid.UUID {
+	if id == uuid.Nil {
+		return nil
+	}
+
+	return &id
+}
@@ -0,0 +1,472 @@
+package helpers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/go-jose/go-jose/v4"
+	jose_jwt "github.com/go-jose/go-jose/v4/jwt"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// Binds the state to the browser that started the login
+	oidcStateCookie     string        = "oidc_state"
+	oidcStateExpiration time.Duration = 10 * time.Minute
+	oidcCacheExpiration time.Duration = time.Hour
+	oidcClockLeeway     time.Duration = time.Minute
+	oidcMaxResponseSize int64         = 1 << 20
+)
+
+var (
+	ErrOIDCDisabled     = errors.New("Single sign-on is not enabled.")
+	ErrOIDCStateInvalid = errors.New("The single sign-on request is invalid or has expired.")
+)
+
+var oidcSigningAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Saved in Redis between the redirect to the provider and the callback
+type oidcState struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+type oidcTokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Discovery document and keys are cached, keys are fetched again for unknown key IDs
+var oidcCache struct {
+	sync.Mutex
+	provider        *oidcProvider
+	providerExpires time.Time
+	keys            *jose.JSONWebKeySet
+	keysExpires     time.Time
+}
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Replaces the client used to reach the provider, such as one trusting a local stand-in
+func SetOIDCHTTPClient(c *http.Client) {
+	oidcHTTPClient = c
+}
+
+// Keeps the state of the login until the callback, the state can only be taken once
+type oidcStateStore interface {
+	Save(ctx context.Context, key string, value string, expiration time.Duration) error
+	Take(ctx context.Context, key string) (string, error)
+}
+
+type redisOIDCStateStore struct{}
+
+func (redisOIDCStateStore) Save(ctx context.Context, key string, value string, expiration time.Duration) error {
+	return app.Cache().Do(ctx, app.Cache().B().Set().Key(key).Value(value).Ex(expiration).Build()).Error()
+}
+
+func (redisOIDCStateStore) Take(ctx context.Context, key string) (string, error) {
+	return app.Cache().Do(ctx, app.Cache().B().Getdel().Key(key).Build()).ToString()
+}
+
+var oidcStates oidcStateStore = redisOIDCStateStore{}
+
+// Plain HTTP is only allowed in debug mode, to test against a local provider
+func checkOIDCEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || len(u.Host) < 1 {
+		return fmt.Errorf("Invalid OIDC endpoint '%s'.", endpoint)
+	}
+
+	if u.Scheme != "https" && !(u.Scheme == "http" && utils.IsDebug()) {
+		return fmt.Errorf("The OIDC endpoint '%s' must use HTTPS.", endpoint)
+	}
+
+	return nil
+}
+
+func fetchOIDCJSON(ctx context.Context, endpoint string, v any) error {
+	if err := checkOIDCEndpoint(endpoint); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(fiber.HeaderAccept, "application/json")
+
+	res, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status %d from %s", res.StatusCode, endpoint)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, oidcMaxResponseSize)).Decode(v)
+}
+
+func getOIDCProvider(ctx context.Context) (*oidcProvider, error) {
+	oidcCache.Lock()
+	defer oidcCache.Unlock()
+
+	if oidcCache.provider != nil && time.Now().Before(oidcCache.providerExpires) {
+		return oidcCache.provider, nil
+	}
+
+	issuer := utils.OIDCIssuer()
+	provider := &oidcProvider{}
+	if err := fetchOIDCJSON(ctx, issuer+"/.well-known/openid-configuration", provider); err != nil {
+		return nil, fmt.Errorf("Could not get OIDC discovery document: %w", err)
+	}
+
+	if strings.TrimRight(provider.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("The OIDC issuer '%s' does not match '%s'.", provider.Issuer, issuer)
+	}
+
+	for _, endpoint := range []string{provider.AuthorizationEndpoint, provider.TokenEndpoint, provider.JWKSURI} {
+		if err := checkOIDCEndpoint(endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	oidcCache.provider = provider
+	oidcCache.providerExpires = time.Now().Add(oidcCacheExpiration)
+
+	return provider, nil
+}
+
+func getOIDCKeys(ctx context.Context, provider *oidcProvider, refresh bool) (*jose.JSONWebKeySet, error) {
+	oidcCache.Lock()
+	defer oidcCache.Unlock()
+
+	if !refresh && oidcCache.keys != nil && time.Now().Before(oidcCache.keysExpires) {
+		return oidcCache.keys, nil
+	}
+
+	keys := &jose.JSONWebKeySet{}
+	if err := fetchOIDCJSON(ctx, provider.JWKSURI, keys); err != nil {
+		return nil, fmt.Errorf("Could not get OIDC keys: %w", err)
+	}
+
+	oidcCache.keys = keys
+	oidcCache.keysExpires = time.Now().Add(oidcCacheExpiration)
+
+	return keys, nil
+}
+
+func oidcStateKey(state string) string {
+	return "oidc:state:" + state
+}
+
+func hashOIDCState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func clearOIDCStateCookie(c *fiber.Ctx) {
+	c.ClearCookie(oidcStateCookie)
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/",
+		Domain:   os.Getenv("COOKIE_DOMAIN"),
+		Expires:  time.Now().In(utils.DefaultLocation()).Add(-1 * time.Hour),
+		Secure:   !utils.IsDebug(),
+		HTTPOnly: true,
+		SameSite: "Lax",
+	})
+}
+
+// URL of the provider the user is redirected to, with the state, nonce and PKCE challenge.
+// The hash of the state is saved in a cookie, so the login can only be completed by the
+// browser that started it.
+func NewOIDCAuthorizationURL(c *fiber.Ctx) (string, error) {
+	if !utils.OIDCEnabled() {
+		return "", ErrOIDCDisabled
+	}
+
+	ctx := c.UserContext()
+
+	provider, err := getOIDCProvider(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	values := []string{}
+
+	for _, n := range []int{32, 32, 64} {
+		v, err := utils.RandomString(n)
+		if err != nil || len(v) < 1 {
+			return "", fmt.Errorf("Error generating random string: %w", err)
+		}
+
+		values = append(values, v)
+	}
+
+	stateID, s := values[0], oidcState{Nonce: values[1], Verifier: values[2]}
+
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	if err := oidcStates.Save(ctx, oidcStateKey(stateID), string(raw), oidcStateExpiration); err != nil {
+		return "", fmt.Errorf("Could not save OIDC state: %w", err)
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    hashOIDCState(stateID),
+		Path:     "/",
+		Domain:   os.Getenv("COOKIE_DOMAIN"),
+		Expires:  time.Now().In(utils.DefaultLocation()).Add(oidcStateExpiration),
+		Secure:   !utils.IsDebug(),
+		HTTPOnly: true,
+		// Sent on the redirect back from the provider
+		SameSite: "Lax",
+	})
+
+	challenge := sha256.Sum256([]byte(s.Verifier))
+
+	u, err := url.Parse(provider.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", os.Getenv("OIDC_CLIENT_ID"))
+	q.Set("redirect_uri", os.Getenv("OIDC_REDIRECT_URL"))
+	q.Set("scope", strings.Join(utils.OIDCScopes(), " "))
+	q.Set("state", stateID)
+	q.Set("nonce", s.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Exchanges the authorization code for an ID token and returns its validated claims.
+// The state can only be used once, by the browser that started the login.
+func ExchangeOIDCCode(c *fiber.Ctx, stateID string, code string) (*ExternalIdentity, error) {
+	if !utils.OIDCEnabled() {
+		return nil, ErrOIDCDisabled
+	}
+
+	ctx := c.UserContext()
+	cookie := c.Cookies(oidcStateCookie)
+	clearOIDCStateCookie(c)
+
+	if len(stateID) < 1 || len(code) < 1 || subtle.ConstantTimeCompare([]byte(cookie), []byte(hashOIDCState(stateID))) != 1 {
+		return nil, ErrOIDCStateInvalid
+	}
+
+	raw, err := oidcStates.Take(ctx, oidcStateKey(stateID))
+	if err != nil {
+		return nil, ErrOIDCStateInvalid
+	}
+
+	s := &oidcState{}
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return nil, ErrOIDCStateInvalid
+	}
+
+	provider, err := getOIDCProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", os.Getenv("OIDC_REDIRECT_URL"))
+	form.Set("client_id", clientID)
+	form.Set("code_verifier", s.Verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(fiber.HeaderContentType, "application/x-www-form-urlencoded")
+	req.Header.Set(fiber.HeaderAccept, "application/json")
+
+	// Public clients only send their ID, along with the PKCE verifier
+	if secret := os.Getenv("OIDC_CLIENT_SECRET"); len(secret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(secret))
+	}
+
+	res, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not exchange OIDC code: %w", err)
+	}
+	defer res.Body.Close()
+
+	tokens := &oidcTokenResponse{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, oidcMaxResponseSize)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("Invalid OIDC token response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK || len(tokens.IDToken) < 1 {
+		return nil, fmt.Errorf("OIDC token request failed with status %d: %s %s", res.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+
+	return verifyOIDCIDToken(ctx, provider, tokens.IDToken, s.Nonce)
+}
+
+func verifyOIDCIDToken(ctx context.Context, provider *oidcProvider, idToken string, nonce string) (*ExternalIdentity, error) {
+	token, err := jose_jwt.ParseSigned(idToken, oidcSigningAlgorithms)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ID token: %w", err)
+	}
+
+	if len(token.Headers) != 1 {
+		return nil, errors.New("The ID token must have a single signature.")
+	}
+
+	keys, err := getOIDCKeys(ctx, provider, false)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := token.Headers[0].KeyID
+	candidates := keys.Key(kid)
+
+	// The provider may have rotated its keys
+	if len(candidates) < 1 && len(kid) > 0 {
+		if keys, err = getOIDCKeys(ctx, provider, true); err != nil {
+			return nil, err
+		}
+
+		candidates = keys.Key(kid)
+	}
+
+	if len(kid) < 1 {
+		candidates = keys.Keys
+	}
+
+	std := jose_jwt.Claims{}
+	custom := map[string]any{}
+	verified := false
+
+	for _, key := range candidates {
+		if err := token.Claims(key, &std, &custom); err == nil {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return nil, errors.New("The ID token signature is not valid.")
+	}
+
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+
+	if err := std.ValidateWithLeeway(jose_jwt.Expected{
+		Issuer:      provider.Issuer,
+		AnyAudience: jose_jwt.Audience{clientID},
+		Time:        time.Now(),
+	}, oidcClockLeeway); err != nil {
+		return nil, fmt.Errorf("Invalid ID token claims: %w", err)
+	}
+
+	if std.Expiry == nil || std.IssuedAt == nil || len(std.Subject) < 1 {
+		return nil, errors.New("The ID token is missing required claims.")
+	}
+
+	if n, _ := custom["nonce"].(string); n != nonce {
+		return nil, errors.New("The ID token nonce does not match.")
+	}
+
+	if azp, ok := custom["azp"].(string); (ok || len(std.Audience) > 1) && azp != clientID {
+		return nil, errors.New("The ID token was not issued for this client.")
+	}
+
+	claims := &ExternalIdentity{
+		Issuer:  std.Issuer,
+		Subject: std.Subject,
+		Groups:  oidcStringList(custom[utils.OIDCGroupsClaim()]),
+	}
+
+	claims.Email, _ = custom["email"].(string)
+
+	// Some providers send it as a string
+	switch v := custom["email_verified"].(type) {
+	case bool:
+		claims.EmailVerified = v
+	case string:
+		claims.EmailVerified, _ = strconv.ParseBool(v)
+	}
+
+	if v, ok := custom["given_name"].(string); ok && len(v) > 0 {
+		claims.FirstName = &v
+	}
+
+	if v, ok := custom["family_name"].(string); ok && len(v) > 0 {
+		claims.LastName = &v
+	}
+
+	return claims, nil
+}
+
+func oidcStringList(v any) []string {
+	list := []string{}
+
+	switch values := v.(type) {
+	case string:
+		list = append(list, values)
+	case []any:
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				list = append(list, s)
+			}
+		}
+	}
+
+	return list
+}
+
+func GetOIDCUser(identity *ExternalIdentity) (*models.User, error) {
+	return GetIdentityUser(identity, IdentityOptions{
+		AutoProvision: utils.OIDCAutoProvision(),
+		GroupRoles:    utils.OIDCGroupRoles(),
+		DefaultRole:   utils.OIDCDefaultRole(),
+		Organization:  utils.OIDCOrganization(),
+	})
+}
@@ -0,0 +1,371 @@
+package helpers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	jose_jwt "github.com/go-jose/go-jose/v4/jwt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/rueidis"
+	"github.com/valyala/fasthttp"
+)
+
+// In-memory stand-in for Redis
+type oidcTestStateStore struct {
+	sync.Mutex
+	values map[string]string
+}
+
+func (s *oidcTestStateStore) Save(_ context.Context, key string, value string, _ time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.values[key] = value
+
+	return nil
+}
+
+func (s *oidcTestStateStore) Take(_ context.Context, key string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	v, ok := s.values[key]
+	if !ok {
+		return "", rueidis.Nil
+	}
+
+	delete(s.values, key)
+
+	return v, nil
+}
+
+type oidcTestCode struct {
+	challenge string
+	claims    map[string]any
+}
+
+// Identity provider issuing ID tokens for the codes it authorized
+type oidcTestProvider struct {
+	sync.Mutex
+	srv   *httptest.Server
+	key   *ecdsa.PrivateKey
+	codes map[string]oidcTestCode
+}
+
+func newOIDCTestProvider(t *testing.T) *oidcTestProvider {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &oidcTestProvider{key: key, codes: map[string]oidcTestCode{}}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                p.srv.URL,
+			AuthorizationEndpoint: p.srv.URL + "/authorize",
+			TokenEndpoint:         p.srv.URL + "/token",
+			JWKSURI:               p.srv.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &p.key.PublicKey, KeyID: "idp", Algorithm: string(jose.ES256), Use: "sig"},
+		}})
+	})
+
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		p.Lock()
+		code, ok := p.codes[r.PostFormValue("code")]
+		delete(p.codes, r.PostFormValue("code"))
+		p.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != code.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(oidcTokenResponse{Error: "invalid_grant"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(oidcTokenResponse{IDToken: p.sign(t, code.claims)})
+	})
+
+	p.srv = httptest.NewTLSServer(mux)
+
+	t.Setenv("OIDC_ENABLE", "true")
+	t.Setenv("OIDC_ISSUER", p.srv.URL)
+	t.Setenv("OIDC_CLIENT_ID", "csp-reporter")
+	t.Setenv("OIDC_CLIENT_SECRET", "")
+	t.Setenv("OIDC_REDIRECT_URL", "https://csp.example.com/login/oidc")
+	t.Setenv("APP_DEBUG", "false")
+
+	client, states := oidcHTTPClient, oidcStates
+	SetOIDCHTTPClient(p.srv.Client())
+	oidcStates = &oidcTestStateStore{values: map[string]string{}}
+	resetOIDCCache()
+
+	t.Cleanup(func() {
+		p.srv.Close()
+		SetOIDCHTTPClient(client)
+		oidcStates = states
+		resetOIDCCache()
+	})
+
+	return p
+}
+
+func resetOIDCCache() {
+	oidcCache.Lock()
+	defer oidcCache.Unlock()
+
+	oidcCache.provider = nil
+	oidcCache.keys = nil
+}
+
+func (p *oidcTestProvider) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jose.JSONWebKey{Key: p.key, KeyID: "idp"}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jose_jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+// Authorizes the request of the URL, the claims replace the default ones
+func (p *oidcTestProvider) authorize(t *testing.T, authURL string, claims map[string]any) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	now := time.Now()
+	code := q.Get("state") + "-code"
+	defaults := map[string]any{
+		"iss":            p.srv.URL,
+		"aud":            q.Get("client_id"),
+		"sub":            "user-1",
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          q.Get("nonce"),
+		"email":          "user@example.com",
+		"email_verified": true,
+		"groups":         []string{"csp-admins"},
+	}
+
+	for k, v := range claims {
+		defaults[k] = v
+	}
+
+	p.Lock()
+	p.codes[code] = oidcTestCode{challenge: q.Get("code_challenge"), claims: defaults}
+	p.Unlock()
+
+	return code
+}
+
+func newOIDCTestCtx(t *testing.T) *fiber.Ctx {
+	t.Helper()
+
+	app := fiber.New()
+	c := app.AcquireCtx(&fasthttp.RequestCtx{})
+	t.Cleanup(func() {
+		app.ReleaseCtx(c)
+	})
+
+	return c
+}
+
+// Callback request of the browser that started the login, with its state cookie
+func newOIDCTestCallbackCtx(t *testing.T, login *fiber.Ctx) *fiber.Ctx {
+	t.Helper()
+
+	cookie := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(cookie)
+
+	cookie.SetKey(oidcStateCookie)
+	if !login.Response().Header.Cookie(cookie) {
+		t.Fatal("The login did not set the state cookie.")
+	}
+
+	c := newOIDCTestCtx(t)
+	c.Request().Header.SetCookie(oidcStateCookie, string(cookie.Value()))
+
+	return c
+}
+
+func TestOIDCLogin(t *testing.T) {
+	p := newOIDCTestProvider(t)
+	login := newOIDCTestCtx(t)
+
+	authURL, err := NewOIDCAuthorizationURL(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookie := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(cookie)
+
+	cookie.SetKey(oidcStateCookie)
+	if !login.Response().Header.Cookie(cookie) || !cookie.HTTPOnly() || cookie.SameSite() != fasthttp.CookieSameSiteLaxMode {
+		t.Errorf("got state cookie %s", cookie.String())
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+
+	if !strings.HasPrefix(authURL, p.srv.URL+"/authorize?") {
+		t.Errorf("got authorization URL %s", authURL)
+	}
+
+	for k, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             "csp-reporter",
+		"redirect_uri":          "https://csp.example.com/login/oidc",
+		"scope":                 "openid email profile",
+		"code_challenge_method": "S256",
+	} {
+		if got := q.Get(k); got != want {
+			t.Errorf("%s: got %q, want %q", k, got, want)
+		}
+	}
+
+	for _, k := range []string{"state", "nonce", "code_challenge"} {
+		if len(q.Get(k)) < 1 {
+			t.Errorf("The authorization URL has no %s.", k)
+		}
+	}
+
+	state := q.Get("state")
+
+	// Started by another browser, the state is not used
+	if _, err := ExchangeOIDCCode(newOIDCTestCtx(t), state, p.authorize(t, authURL, nil)); !errors.Is(err, ErrOIDCStateInvalid) {
+		t.Errorf("Missing cookie: got error %v, want %v", err, ErrOIDCStateInvalid)
+	}
+
+	callback := newOIDCTestCallbackCtx(t, login)
+	identity, err := ExchangeOIDCCode(callback, state, p.authorize(t, authURL, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Issuer != p.srv.URL || identity.Subject != "user-1" || identity.Email != "user@example.com" || !identity.EmailVerified {
+		t.Errorf("got identity %+v", identity)
+	}
+
+	if len(identity.Groups) != 1 || identity.Groups[0] != "csp-admins" {
+		t.Errorf("got groups %v", identity.Groups)
+	}
+
+	// The state was used already
+	if _, err := ExchangeOIDCCode(newOIDCTestCallbackCtx(t, login), state, p.authorize(t, authURL, nil)); !errors.Is(err, ErrOIDCStateInvalid) {
+		t.Errorf("Reused state: got error %v, want %v", err, ErrOIDCStateInvalid)
+	}
+
+	if _, err := ExchangeOIDCCode(newOIDCTestCallbackCtx(t, login), "unknown", "code"); !errors.Is(err, ErrOIDCStateInvalid) {
+		t.Errorf("Unknown state: got error %v, want %v", err, ErrOIDCStateInvalid)
+	}
+}
+
+func TestOIDCLoginRejected(t *testing.T) {
+	p := newOIDCTestProvider(t)
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+		// Replaces the PKCE challenge the provider received
+		challenge string
+		want      string
+	}{
+		{"PKCE verifier", nil, "tampered", "invalid_grant"},
+		{"nonce", map[string]any{"nonce": "other"}, "", "nonce does not match"},
+		{"audience", map[string]any{"aud": "other-client"}, "", "Invalid ID token claims"},
+		{"authorized party", map[string]any{"aud": []string{"csp-reporter", "other-client"}, "azp": "other-client"}, "", "not issued for this client"},
+		{"issuer", map[string]any{"iss": "https://idp.example.com"}, "", "Invalid ID token claims"},
+		{"expiration", map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}, "", "Invalid ID token claims"},
+		{"subject", map[string]any{"sub": ""}, "", "missing required claims"},
+	}
+
+	for _, tt := range tests {
+		login := newOIDCTestCtx(t)
+
+		authURL, err := NewOIDCAuthorizationURL(login)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		code := p.authorize(t, authURL, tt.claims)
+
+		if len(tt.challenge) > 0 {
+			p.Lock()
+			c := p.codes[code]
+			c.challenge = tt.challenge
+			p.codes[code] = c
+			p.Unlock()
+		}
+
+		u, _ := url.Parse(authURL)
+
+		_, err = ExchangeOIDCCode(newOIDCTestCallbackCtx(t, login), u.Query().Get("state"), code)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCheckOIDCEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		debug    string
+		valid    bool
+	}{
+		{"https://idp.example.com/token", "false", true},
+		{"http://idp.example.com/token", "false", false},
+		{"http://localhost:8080/token", "true", true},
+		{"ftp://idp.example.com/token", "true", false},
+		{"/token", "true", false},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("APP_DEBUG", tt.debug)
+
+		if err := checkOIDCEndpoint(tt.endpoint); (err == nil) != tt.valid {
+			t.Errorf("%s in debug %s: got error %v, want valid %v", tt.endpoint, tt.debug, err, tt.valid)
+		}
+	}
+}
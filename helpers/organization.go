@@ -0,0 +1,254 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	OrganizationAdmin  string = "admin"
+	OrganizationMember string = "member"
+)
+
+const (
+	OrganizationInvitationExpiration time.Duration = 7 * 24 * time.Hour
+	// Role with access to every organization
+	superAdminRole string = "superadmin"
+)
+
+var slugInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+var (
+	ErrInvitationInvalid = errors.New("The invitation is invalid or has expired.")
+	ErrInvitationEmail   = errors.New("The invitation was sent to a different email address.")
+)
+
+func OrganizationRoles() []string {
+	return []string{OrganizationAdmin, OrganizationMember}
+}
+
+func OrganizationSlug(name string) string {
+	return strings.Trim(slugInvalidChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// Whether the request can use the global superadmin role
+func IsSuperAdmin(c *fiber.Ctx) bool {
+	roles, err := GetRequestRoles(c)
+	if err != nil {
+		return false
+	}
+
+	return roles.IsSuperAdmin()
+}
+
+// Organizations the user is a member of, optionally only those with the given role
+func GetUserOrganizationIDs(userID uuid.UUID, role string) ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+	query := app.DB().Model(&models.OrganizationMember{}).
+		Joins("INNER JOIN organizations o ON o.id = organization_members.organization_id AND o.deleted_at IS NULL").
+		Where("organization_members.user_id = ?", userID)
+
+	if len(role) > 0 {
+		query = query.Where("organization_members.role = ?", role)
+	}
+
+	if err := query.Pluck("organization_members.organization_id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func IsOrganizationAdmin(c *fiber.Ctx, organizationID uuid.UUID) bool {
+	if IsSuperAdmin(c) {
+		return true
+	}
+
+	ids, err := GetUserOrganizationIDs(GetUserID(c), OrganizationAdmin)
+
+	return err == nil && slices.Contains(ids, organizationID)
+}
+
+func IsOrganizationMember(c *fiber.Ctx, organizationID uuid.UUID) bool {
+	if IsSuperAdmin(c) {
+		return true
+	}
+
+	ids, err := GetUserOrganizationIDs(GetUserID(c), "")
+
+	return err == nil && slices.Contains(ids, organizationID)
+}
+
+// Sites the user can access, superadmins can access all of them
+type SiteScope struct {
+	All     bool
+	SiteIDs []uuid.UUID
+}
+
+// Sites of the organizations of the user and the sites the request has a role on
+func GetSiteScope(c *fiber.Ctx) (*SiteScope, error) {
+	roles, err := GetRequestRoles(c)
+	if err != nil {
+		return nil, err
+	}
+
+	scope, err := getOrganizationSiteScope(GetUserID(c), roles)
+	if err != nil || scope.All {
+		return scope, err
+	}
+
+	for _, r := range roles.Scoped() {
+		if !scope.Allows(*r.SiteID) {
+			scope.SiteIDs = append(scope.SiteIDs, *r.SiteID)
+		}
+	}
+
+	return scope, nil
+}
+
+func getOrganizationSiteScope(userID uuid.UUID, roles userRoleList) (*SiteScope, error) {
+	if roles.IsSuperAdmin() {
+		return &SiteScope{All: true}, nil
+	}
+
+	orgIDs, err := GetUserOrganizationIDs(userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	scope := &SiteScope{SiteIDs: []uuid.UUID{}}
+
+	if len(orgIDs) < 1 {
+		return scope, nil
+	}
+
+	// Deleted sites included, their reports are still visible
+	if err := app.DB().Unscoped().Model(&models.Site{}).
+		Where("organization_id IN ?", orgIDs).
+		Pluck("id", &scope.SiteIDs).Error; err != nil {
+		return nil, err
+	}
+
+	return scope, nil
+}
+
+func (s SiteScope) Allows(siteID uuid.UUID) bool {
+	return s.All || slices.Contains(s.SiteIDs, siteID)
+}
+
+// Limits a query to the sites in scope
+func (s SiteScope) Apply(query *gorm.DB, column string) *gorm.DB {
+	if s.All {
+		return query
+	}
+
+	if len(s.SiteIDs) < 1 {
+		return query.Where("1 = 0")
+	}
+
+	return query.Where(column+" IN ?", s.SiteIDs)
+}
+
+// Narrows a site filter to the sites in scope, an empty filter means all of them
+func (s SiteScope) Restrict(ids []uuid.UUID) []uuid.UUID {
+	if s.All {
+		return ids
+	}
+
+	if len(ids) < 1 {
+		ids = s.SiteIDs
+	} else {
+		ids = slices.DeleteFunc(slices.Clone(ids), func(id uuid.UUID) bool {
+			return !slices.Contains(s.SiteIDs, id)
+		})
+	}
+
+	// Matches nothing, instead of everything
+	if len(ids) < 1 {
+		return []uuid.UUID{uuid.Nil}
+	}
+
+	return ids
+}
+
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Creates an invitation, returning the token to be sent to the invitee
+func NewOrganizationInvitation(tx *gorm.DB, invitation *models.OrganizationInvitation) (string, error) {
+	token, err := utils.RandomString(40)
+	if err != nil {
+		return "", err
+	}
+
+	invitation.TokenHash = hashInvitationToken(token)
+	invitation.ExpiresAt = time.Now().Add(OrganizationInvitationExpiration)
+
+	if err := tx.Omit("Organization", "InvitedBy").Create(&invitation).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Pending invitation for the email
+func GetOrganizationInvitation(tx *gorm.DB, token string, email string) (*models.OrganizationInvitation, error) {
+	invitation := &models.OrganizationInvitation{}
+	if err := tx.Where(&models.OrganizationInvitation{TokenHash: hashInvitationToken(strings.TrimSpace(token))}).
+		Where("accepted_at IS NULL AND expires_at > ?", time.Now()).
+		Preload("Organization").
+		First(&invitation).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvitationInvalid
+		}
+
+		return nil, err
+	}
+
+	if !strings.EqualFold(invitation.Email, strings.TrimSpace(email)) {
+		return nil, ErrInvitationEmail
+	}
+
+	return invitation, nil
+}
+
+// Adds the user to the organization of the invitation and marks it as accepted
+func AcceptOrganizationInvitation(tx *gorm.DB, invitation *models.OrganizationInvitation, userID uuid.UUID) error {
+	if err := AddOrganizationMember(tx, invitation.OrganizationID, userID, invitation.Role); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	invitation.AcceptedAt = &now
+
+	return tx.Model(&invitation).Update("accepted_at", now).Error
+}
+
+// Adds the member or updates their role
+func AddOrganizationMember(tx *gorm.DB, organizationID uuid.UUID, userID uuid.UUID, role string) error {
+	if !slices.Contains(OrganizationRoles(), role) {
+		return fmt.Errorf("Invalid organization role '%s'.", role)
+	}
+
+	return tx.Exec(`INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES (?, ?, ?)
+		ON CONFLICT (organization_id, user_id) DO UPDATE SET role = EXCLUDED.role, updated_at = clock_timestamp()`,
+		organizationID, userID, role,
+	).Error
+}
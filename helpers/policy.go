@@ -0,0 +1,97 @@
+package helpers
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"github.com/casbin/casbin/v2/util"
+	"github.com/gofiber/fiber/v2"
+)
+
+type PolicyCheck struct {
+	// Named routes no policy allows
+	UncoveredRoutes []string
+	// Policies whose endpoint and method match no route
+	UnusedPolicies []string
+}
+
+// Parameter constraints and optional markers, as in /reports/:id<guid>
+var routeParamPattern = regexp.MustCompile(`<[^>]*>|\?`)
+
+func (pc *PolicyCheck) OK() bool {
+	return len(pc.UncoveredRoutes) < 1 && len(pc.UnusedPolicies) < 1
+}
+
+func (pc *PolicyCheck) Log() {
+	for _, r := range pc.UncoveredRoutes {
+		slog.Warn(fmt.Sprintf("Route without policy: %s", r))
+	}
+
+	for _, p := range pc.UnusedPolicies {
+		slog.Warn(fmt.Sprintf("Policy without route: %s", p))
+	}
+}
+
+func normalizeRoutePath(path string) string {
+	path = routeParamPattern.ReplaceAllString(path, "")
+
+	if len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+	}
+
+	return path
+}
+
+// Compares the routes of the app with the loaded policies. Public routes
+// must be allowed to the guest role too, so every named route needs a policy.
+func CheckRoutePolicies(routes []fiber.Route) (*PolicyCheck, error) {
+	policies, err := app.Auth().GetPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	return MatchRoutePolicies(routes, policies), nil
+}
+
+// Matches the routes with the policies, given as role, domain, endpoint and method
+func MatchRoutePolicies(routes []fiber.Route, policies [][]string) *PolicyCheck {
+	check := &PolicyCheck{UncoveredRoutes: []string{}, UnusedPolicies: []string{}}
+	used := make([]bool, len(policies))
+
+	for _, r := range routes {
+		if r.Method == fiber.MethodHead || r.Method == fiber.MethodOptions {
+			continue
+		}
+
+		path := normalizeRoutePath(r.Path)
+		covered := false
+
+		for i, p := range policies {
+			if len(p) < 4 || p[3] != r.Method || !util.KeyMatch2(path, p[2]) {
+				continue
+			}
+
+			covered = true
+			used[i] = true
+		}
+
+		if !covered && len(r.Name) > 0 {
+			check.UncoveredRoutes = append(check.UncoveredRoutes, fmt.Sprintf("%s %s (%s)", r.Method, path, r.Name))
+		}
+	}
+
+	for i, p := range policies {
+		if !used[i] {
+			check.UnusedPolicies = append(check.UnusedPolicies, strings.Join(p, ", "))
+		}
+	}
+
+	slices.Sort(check.UncoveredRoutes)
+	slices.Sort(check.UnusedPolicies)
+
+	return check
+}
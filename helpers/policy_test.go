@@ -0,0 +1,62 @@
+package helpers
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestNormalizeRoutePath(t *testing.T) {
+	tests := map[string]string{
+		"/":                             "/",
+		"/api/v1/sites/":                "/api/v1/sites",
+		"/api/v1/csp/reports/:id<guid>": "/api/v1/csp/reports/:id",
+		"/api/v1/users/:id?":            "/api/v1/users/:id",
+	}
+
+	for path, want := range tests {
+		if got := normalizeRoutePath(path); got != want {
+			t.Errorf("%s: got %s, want %s", path, got, want)
+		}
+	}
+}
+
+func TestMatchRoutePolicies(t *testing.T) {
+	routes := []fiber.Route{
+		{Method: fiber.MethodGet, Path: "/api/v1/sites/all", Name: "api.sites.index"},
+		{Method: fiber.MethodGet, Path: "/api/v1/sites/:id<guid>", Name: "api.sites.show"},
+		{Method: fiber.MethodHead, Path: "/api/v1/sites/:id<guid>", Name: "api.sites.show"},
+		{Method: fiber.MethodDelete, Path: "/api/v1/sites/:id<guid>", Name: "api.sites.delete"},
+		// Unnamed routes, such as the error handlers, may not have policies
+		{Method: fiber.MethodGet, Path: "/api/v1/unnamed"},
+	}
+
+	policies := [][]string{
+		{"viewer", "*", "/api/v1/sites/all", fiber.MethodGet, "allow"},
+		{"viewer", "*", "/api/v1/sites/:id", fiber.MethodGet, "allow"},
+		{"manager", "*", "/api/v1/sites/:id", fiber.MethodPatch, "allow"},
+		{"admin", "global", "/api/v1/users/all", fiber.MethodGet, "allow"},
+	}
+
+	check := MatchRoutePolicies(routes, policies)
+
+	if want := []string{"DELETE /api/v1/sites/:id (api.sites.delete)"}; !slices.Equal(check.UncoveredRoutes, want) {
+		t.Errorf("uncovered routes:\ngot  %v\nwant %v", check.UncoveredRoutes, want)
+	}
+
+	if want := []string{
+		"admin, global, /api/v1/users/all, GET, allow",
+		"manager, *, /api/v1/sites/:id, PATCH, allow",
+	}; !slices.Equal(check.UnusedPolicies, want) {
+		t.Errorf("unused policies:\ngot  %v\nwant %v", check.UnusedPolicies, want)
+	}
+
+	if check.OK() {
+		t.Error("The check must fail with uncovered routes or unused policies.")
+	}
+
+	if !MatchRoutePolicies(routes[:3], policies[:2]).OK() {
+		t.Error("The check must pass when every route and policy match.")
+	}
+}
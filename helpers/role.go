@@ -7,19 +7,32 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"regexp"
+	"slices"
 	"time"
 
 	"alfredoramos.mx/csp-reporter/app"
 	"alfredoramos.mx/csp-reporter/models"
 	"alfredoramos.mx/csp-reporter/utils"
 	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/redis/rueidis"
+	"gorm.io/gorm"
+)
+
+const (
+	// Domain of the global roles, policies in it are never granted by site roles
+	GlobalDomain string = "global"
+	// Pattern of the policies and role inheritances that apply to every domain
+	AnyDomain string = "*"
 )
 
 type userRole struct {
 	ID   uuid.UUID `json:"id"`
 	Name string    `json:"name"`
+	// Roles limited to a site only apply to it
+	SiteID *uuid.UUID `json:"site_id,omitempty"`
 }
 
 type userRoleList []userRole
@@ -28,12 +41,78 @@ func (l userRoleList) Names() []string {
 	names := []string{}
 
 	for _, r := range l {
-		names = append(names, r.Name)
+		if !slices.Contains(names, r.Name) {
+			names = append(names, r.Name)
+		}
 	}
 
 	return names
 }
 
+func (l userRoleList) Global() userRoleList {
+	return slices.DeleteFunc(slices.Clone(l), func(r userRole) bool {
+		return r.SiteID != nil
+	})
+}
+
+func (l userRoleList) Scoped() userRoleList {
+	return slices.DeleteFunc(slices.Clone(l), func(r userRole) bool {
+		return r.SiteID == nil
+	})
+}
+
+// Roles limited to the given names, all of them when there are no names.
+// Each role becomes the given names it is or inherits from, on the same site.
+func (l userRoleList) Limit(names []string) (userRoleList, error) {
+	if len(names) < 1 {
+		return l, nil
+	}
+
+	roles := userRoleList{}
+
+	for _, r := range l {
+		inherited, err := ExpandRoles([]string{r.Name})
+		if err != nil {
+			return userRoleList{}, err
+		}
+
+		for _, name := range names {
+			if !slices.Contains(inherited, name) {
+				continue
+			}
+
+			role := userRole{Name: name, SiteID: r.SiteID}
+
+			if name == r.Name {
+				role.ID = r.ID
+			}
+
+			roles = append(roles, role)
+		}
+	}
+
+	return roles, nil
+}
+
+func (l userRoleList) IsSuperAdmin() bool {
+	return slices.Contains(l.Global().Names(), superAdminRole)
+}
+
+// Highest rank of the roles, site roles only count on their site
+func (l userRoleList) Rank(siteID *uuid.UUID) int {
+	rank := -1
+
+	for _, r := range l {
+		if r.SiteID != nil && (siteID == nil || *r.SiteID != *siteID) {
+			continue
+		}
+
+		rank = max(rank, RoleRank(r.Name))
+	}
+
+	return rank
+}
+
 func (l userRoleList) IDs() []uuid.UUID {
 	ids := []uuid.UUID{}
 
@@ -66,13 +145,12 @@ func GetUserRoles(id uuid.UUID) (userRoleList, error) {
 		return roles, nil
 	}
 
-	limit := 10
-
 	if err := app.DB().Model(&models.Role{}).
+		Select("roles.id, roles.name, ur.site_id").
 		Joins("INNER JOIN user_roles ur ON roles.id = ur.role_id").
 		Joins("INNER JOIN users u ON ur.user_id = u.id").
 		Where("ur.deleted_at IS NULL AND u.deleted_at IS NULL AND u.id = @user_id AND u.active = @active", sql.Named("user_id", id), sql.Named("active", true)).
-		Limit(limit).Find(&roles).Error; err != nil {
+		Find(&roles).Error; err != nil {
 		return []userRole{}, err
 	}
 
@@ -89,25 +167,35 @@ func GetUserRoles(id uuid.UUID) (userRoleList, error) {
 	return roles, nil
 }
 
-func HasPermission(id uuid.UUID, p string, m string) bool {
-	if !utils.IsValidUuid(id) {
-		return false
-	}
+// Roles with the ones they inherit
+func ExpandRoles(names []string) ([]string, error) {
+	roles := slices.Clone(names)
 
-	r, err := GetUserRoles(id)
-	if err != nil {
-		slog.Error(fmt.Sprintf("User roles error: %v", err))
-		r = []userRole{}
+	for _, name := range names {
+		inherited, err := app.Auth().GetImplicitRolesForUser(name, AnyDomain)
+		if err != nil {
+			return []string{}, err
+		}
+
+		for _, r := range inherited {
+			if !slices.Contains(roles, r) {
+				roles = append(roles, r)
+			}
+		}
 	}
 
-	if len(r) < 1 {
+	return roles, nil
+}
+
+func enforceRoles(roles []string, domain string, p string, m string) bool {
+	if len(roles) < 1 {
 		return false
 	}
 
 	ps := [][]interface{}{}
 
-	for _, val := range r.Names() {
-		ps = append(ps, []interface{}{val, p, m})
+	for _, val := range roles {
+		ps = append(ps, []interface{}{val, domain, p, m})
 	}
 
 	result, err := app.Auth().BatchEnforce(ps)
@@ -117,11 +205,184 @@ func HasPermission(id uuid.UUID, p string, m string) bool {
 		return false
 	}
 
-	for _, val := range result {
-		if val {
-			return true
+	return slices.Contains(result, true)
+}
+
+// Sites where the user is allowed to access the endpoint, nil if there are none.
+// Global roles apply to the sites of the organizations of the user, site roles only
+// to requests targeting their site, so requests without a site only use global roles.
+// Only the roles of the request are used, as API tokens can be limited to some roles.
+func GetPermittedSites(c *fiber.Ctx, siteID *uuid.UUID, p string, m string) (*SiteScope, error) {
+	id := GetUserID(c)
+	if !utils.IsValidUuid(id) {
+		return nil, nil
+	}
+
+	r, err := GetRequestRoles(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var scope *SiteScope
+
+	if enforceRoles(r.Global().Names(), GlobalDomain, p, m) {
+		if scope, err = getOrganizationSiteScope(id, r); err != nil {
+			return nil, err
 		}
 	}
 
-	return false
+	if siteID == nil {
+		return scope, nil
+	}
+
+	for _, role := range r.Scoped() {
+		if *role.SiteID != *siteID || (scope != nil && scope.Allows(*role.SiteID)) {
+			continue
+		}
+
+		if !enforceRoles([]string{role.Name}, role.SiteID.String(), p, m) {
+			continue
+		}
+
+		if scope == nil {
+			scope = &SiteScope{SiteIDs: []uuid.UUID{}}
+		}
+
+		scope.SiteIDs = append(scope.SiteIDs, *role.SiteID)
+	}
+
+	return scope, nil
+}
+
+// Routes whose ID belongs to a site, directly or through the site of the record
+var permissionSiteRoutes = []struct {
+	pattern *regexp.Regexp
+	table   string
+}{
+	{regexp.MustCompile(`^/api/v1/sites/([0-9a-fA-F-]{36})(?:/|$)`), ""},
+	{regexp.MustCompile(`^/api/v1/notifications/subscriptions/([0-9a-fA-F-]{36})$`), ""},
+	{regexp.MustCompile(`^/api/v1/csp/reports/([0-9a-fA-F-]{36})(?:/|$)`), "reports"},
+	{regexp.MustCompile(`^/api/v1/csp/groups/([0-9a-fA-F-]{36})(?:/|$)`), "violation_groups"},
+	{regexp.MustCompile(`^/api/v1/csp/alerts/rules/([0-9a-fA-F-]{36})$`), "alert_rules"},
+}
+
+// Site targeted by the request, from the route parameters or the site_id query.
+// Returns nil when the request does not target a single site.
+func ResolvePermissionSite(c *fiber.Ctx) (*uuid.UUID, error) {
+	for _, r := range permissionSiteRoutes {
+		m := r.pattern.FindStringSubmatch(c.Path())
+		if m == nil {
+			continue
+		}
+
+		id, err := uuid.Parse(m[1])
+		if err != nil || !utils.IsValidUuid(id) {
+			return nil, nil
+		}
+
+		if len(r.table) < 1 {
+			return &id, nil
+		}
+
+		siteIDs := []uuid.UUID{}
+		if err := app.DB().Table(r.table).Where("id = ?", id).Limit(1).Pluck("site_id", &siteIDs).Error; err != nil {
+			return nil, err
+		}
+
+		// Missing records are handled by the controllers
+		if len(siteIDs) < 1 {
+			return nil, nil
+		}
+
+		return &siteIDs[0], nil
+	}
+
+	if id, err := uuid.Parse(c.Query("site_id")); err == nil && utils.IsValidUuid(id) {
+		return &id, nil
+	}
+
+	return nil, nil
+}
+
+const siteScopeContextKey string = "site_scope"
+
+// Sites the endpoint may be used on, set by the permissions middleware
+func SetRequestSiteScope(c *fiber.Ctx, scope *SiteScope) {
+	c.Locals(siteScopeContextKey, scope)
+}
+
+func GetRequestSiteScope(c *fiber.Ctx) *SiteScope {
+	scope, ok := c.Locals(siteScopeContextKey).(*SiteScope)
+	if !ok {
+		return nil
+	}
+
+	return scope
+}
+
+// Roles from the lowest to the highest
+func RoleRanks() []string {
+	return []string{"user", "viewer", "manager", "admin", superAdminRole}
+}
+
+// Position of the role in the ranks, -1 if it's unknown
+func RoleRank(name string) int {
+	return slices.Index(RoleRanks(), name)
+}
+
+// Highest rank of the user, site roles only count on their site
+func GetUserRank(userID uuid.UUID, siteID *uuid.UUID) (int, error) {
+	roles, err := GetUserRoles(userID)
+	if err != nil {
+		return -1, err
+	}
+
+	return roles.Rank(siteID), nil
+}
+
+// Highest rank the request can use, site roles only count on their site
+func GetRequestRank(c *fiber.Ctx, siteID *uuid.UUID) (int, error) {
+	roles, err := GetRequestRoles(c)
+	if err != nil {
+		return -1, err
+	}
+
+	return roles.Rank(siteID), nil
+}
+
+// Active users with the global superadmin role
+func superAdmins(tx *gorm.DB) *gorm.DB {
+	return tx.Model(&models.User{}).
+		Joins("INNER JOIN user_roles ur ON ur.user_id = users.id AND ur.deleted_at IS NULL AND ur.site_id IS NULL").
+		Joins("INNER JOIN roles r ON r.id = ur.role_id").
+		Where("users.active = ? AND r.name = ?", true, superAdminRole)
+}
+
+// Counts the superadmins, changes to them wait for the end of the transaction
+// so concurrent requests cannot remove the last one
+func CountSuperAdmins(tx *gorm.DB) (int64, error) {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(@key))", sql.Named("key", "roles:"+superAdminRole)).Error; err != nil {
+		return 0, err
+	}
+
+	var n int64
+	err := superAdmins(tx).Distinct("users.id").Count(&n).Error
+
+	return n, err
+}
+
+// Whether the user is an active superadmin, without using the cached roles
+func IsActiveSuperAdmin(tx *gorm.DB, userID uuid.UUID) (bool, error) {
+	var n int64
+	err := superAdmins(tx).Where("users.id = ?", userID).Count(&n).Error
+
+	return n > 0, err
+}
+
+// Removes the cached roles and account of the user, so changes take effect right away
+func InvalidateUserCache(userID uuid.UUID) error {
+	return app.Cache().Do(context.Background(), app.Cache().B().Del().Key(
+		fmt.Sprintf("roles:%s", userID.String()),
+		fmt.Sprintf("user:%s", userID.String()),
+	).Build()).Error()
 }
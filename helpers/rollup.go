@@ -0,0 +1,96 @@
+package helpers
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	RollupHourly string = "hour"
+	RollupDaily  string = "day"
+)
+
+func RollupGranularities() []string {
+	return []string{RollupHourly, RollupDaily}
+}
+
+func getRollupTable(granularity string) (string, error) {
+	var model any
+
+	switch granularity {
+	case RollupHourly:
+		model = &models.HourlyReportRollup{}
+	case RollupDaily:
+		model = &models.DailyReportRollup{}
+	default:
+		return "", fmt.Errorf("Invalid rollup granularity '%s'.", granularity)
+	}
+
+	s := GetModelSchema(model)
+	if s == nil {
+		return "", fmt.Errorf("Could not get rollup table for '%s' granularity.", granularity)
+	}
+
+	return s.Table, nil
+}
+
+func RollupBucket(granularity string, t time.Time) (time.Time, time.Time) {
+	t = t.In(utils.DefaultLocation())
+
+	if granularity == RollupDaily {
+		start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 0, 1)
+	}
+
+	start := t.Truncate(time.Hour)
+
+	return start, start.Add(time.Hour)
+}
+
+func RecomputeRollup(granularity string, bucket time.Time) error {
+	table, err := getRollupTable(granularity)
+	if err != nil {
+		return err
+	}
+
+	start, end := RollupBucket(granularity, bucket)
+
+	return app.DB().Transaction(func(tx *gorm.DB) error {
+		// Serialize re-computations of the same bucket
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(@key))", sql.Named("key", fmt.Sprintf("%s:%d", table, start.Unix()))).Error; err != nil {
+			return err
+		}
+
+		//#nosec G201 -- The table name is taken from the model schema
+		if err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE bucket = @start", table), sql.Named("start", start)).Error; err != nil {
+			return err
+		}
+
+		//#nosec G201 -- The table name is taken from the model schema
+		return tx.Exec(fmt.Sprintf(`INSERT INTO %s (bucket, site_id, effective_directive, blocked_host, disposition, count, distinct_documents, updated_at)
+			SELECT @start, site_id, effective_directive, coalesce(blocked_host, ''), disposition, count(*), count(DISTINCT document_uri), clock_timestamp()
+			FROM reports
+			WHERE deleted_at IS NULL AND NOT quarantined AND created_at >= @start AND created_at < @end
+			GROUP BY site_id, effective_directive, coalesce(blocked_host, ''), disposition`, table),
+			sql.Named("start", start),
+			sql.Named("end", end),
+		).Error
+	})
+}
+
+// Hours with reports matching the query, to recompute their rollups after changes
+func GetReportBuckets(query *gorm.DB) ([]time.Time, error) {
+	buckets := []time.Time{}
+
+	if err := query.Select("DISTINCT date_trunc('hour', created_at)").Scan(&buckets).Error; err != nil {
+		return nil, err
+	}
+
+	return buckets, nil
+}
@@ -0,0 +1,92 @@
+package helpers
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Apex domain the reports of a site are matched against
+func NormalizeSiteDomain(d string) (string, error) {
+	domain, err := utils.GetApexDomain(d)
+	if err != nil && utils.IsDebug() {
+		// Allows hosts like localhost while testing
+		domain, err = utils.GetDomainHostname(d)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(domain), nil
+}
+
+// Site that owns the domain, either as its main or additional domain
+func GetSiteByDomain(tx *gorm.DB, domain string) (*models.Site, error) {
+	site := &models.Site{}
+	err := tx.Model(&models.Site{}).
+		Where("unaccent(lower(domain)) = unaccent(lower(@domain))", sql.Named("domain", domain)).
+		Or("id IN (?)", tx.Model(&models.SiteDomain{}).Select("site_id").Where("lower(domain) = lower(?)", domain)).
+		First(&site).Error
+
+	return site, err
+}
+
+// Whether the domain is used by a site other than the given one, deleted sites included
+func IsSiteDomainTaken(tx *gorm.DB, domain string, siteID uuid.UUID) (bool, error) {
+	var n int64
+
+	if err := tx.Unscoped().Model(&models.Site{}).
+		Where("lower(domain) = lower(?) AND id <> ?", domain, siteID).
+		Count(&n).Error; err != nil || n > 0 {
+		return n > 0, err
+	}
+
+	if err := tx.Model(&models.SiteDomain{}).
+		Where("lower(domain) = lower(?) AND site_id <> ?", domain, siteID).
+		Count(&n).Error; err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// Whether the site settings discard the report
+func IsIgnoredReport(site *models.Site, r *models.Report) bool {
+	if slices.Contains(site.Settings.IgnoredDirectives, strings.ToLower(r.EffectiveDirective)) {
+		return true
+	}
+
+	return r.BlockedHost != nil && slices.Contains(site.Settings.IgnoredBlockedHosts, strings.ToLower(*r.BlockedHost))
+}
+
+// Adds the report count and last report date of each site. Complete days are read
+// from the daily rollups and only the newer reports are counted, so listing sites
+// does not scan all of their reports. Older reports only tell the day of the last one.
+func SelectSiteReportStats(query *gorm.DB) (*gorm.DB, error) {
+	table, err := getRollupTable(RollupDaily)
+	if err != nil {
+		return nil, err
+	}
+
+	// The rollup of the previous day might not be computed yet
+	today, _ := RollupBucket(RollupDaily, time.Now())
+	since, _ := RollupBucket(RollupDaily, today.Add(-time.Second))
+
+	//#nosec G201 -- The table name is taken from the model schema
+	return query.Select("sites.*, coalesce(report_stats.report_count, 0) AS report_count, report_stats.last_report_at").
+		Joins(fmt.Sprintf(`LEFT JOIN (
+			SELECT site_id, sum(count)::bigint AS report_count, max(last_report_at) AS last_report_at FROM (
+				SELECT site_id, sum(count) AS count, max(bucket) AS last_report_at FROM %s WHERE bucket < @since GROUP BY site_id
+				UNION ALL
+				SELECT site_id, count(*), max(created_at) FROM reports WHERE deleted_at IS NULL AND NOT quarantined AND created_at >= @since GROUP BY site_id
+			) s GROUP BY site_id
+		) report_stats ON report_stats.site_id = sites.id`, table), sql.Named("since", since)), nil
+}
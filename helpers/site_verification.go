@@ -0,0 +1,238 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	SiteVerificationDNS    string = "dns"
+	SiteVerificationHTTP   string = "http"
+	SiteVerificationConfig string = "config"
+)
+
+const (
+	siteVerificationRecord  string = "_csp-reporter"
+	siteVerificationPath    string = "/.well-known/csp-reporter-verification.txt"
+	siteVerificationPrefix  string = "csp-reporter-verification="
+	siteVerificationTimeout        = 10 * time.Second
+	// Consecutive failed re-verifications before a verified site loses its status
+	maxSiteVerificationFailures int   = 3
+	maxSiteVerificationBody     int64 = 1024
+)
+
+var ErrSiteUnverified = errors.New("The site has not been verified.")
+
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+var (
+	siteVerificationResolver TXTResolver = net.DefaultResolver
+	siteVerificationClient               = newWebhookClient()
+)
+
+// Replaces the resolver used to look up verification records
+func SetSiteVerificationResolver(r TXTResolver) {
+	siteVerificationResolver = r
+}
+
+// Replaces the client used to fetch verification files
+func SetSiteVerificationHTTPClient(c *http.Client) {
+	siteVerificationClient = c
+}
+
+func NewSiteVerificationToken() (string, error) {
+	return utils.RandomString(32)
+}
+
+type SiteVerificationInstructions struct {
+	DNSName     string `json:"dns_name"`
+	DNSValue    string `json:"dns_value"`
+	HTTPPath    string `json:"http_path"`
+	HTTPContent string `json:"http_content"`
+}
+
+// Either of the methods proves the ownership of every domain of the site
+func GetSiteVerificationInstructions(site *models.Site) SiteVerificationInstructions {
+	return SiteVerificationInstructions{
+		DNSName:     siteVerificationRecord + ".<domain>",
+		DNSValue:    siteVerificationPrefix + site.VerificationToken,
+		HTTPPath:    siteVerificationPath,
+		HTTPContent: site.VerificationToken,
+	}
+}
+
+func verifyDomainDNS(ctx context.Context, domain string, token string) error {
+	records, err := siteVerificationResolver.LookupTXT(ctx, siteVerificationRecord+"."+domain)
+	if err != nil {
+		return fmt.Errorf("Could not look up the TXT record of %s: %w", domain, err)
+	}
+
+	if !slices.Contains(records, siteVerificationPrefix+token) {
+		return fmt.Errorf("The TXT record of %s does not contain the verification token.", domain)
+	}
+
+	return nil
+}
+
+func verifyDomainHTTP(ctx context.Context, domain string, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+domain+siteVerificationPath, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := siteVerificationClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Could not get the verification file of %s: %w", domain, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("The verification file of %s returned status %d.", domain, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSiteVerificationBody))
+	if err != nil {
+		return fmt.Errorf("Could not read the verification file of %s: %w", domain, err)
+	}
+
+	if strings.TrimSpace(string(body)) != token {
+		return fmt.Errorf("The verification file of %s does not contain the verification token.", domain)
+	}
+
+	return nil
+}
+
+// Checks the DNS record first, then the well-known file
+func verifyDomain(ctx context.Context, domain string, token string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, siteVerificationTimeout)
+	defer cancel()
+
+	dnsErr := verifyDomainDNS(ctx, domain, token)
+	if dnsErr == nil {
+		return SiteVerificationDNS, nil
+	}
+
+	httpErr := verifyDomainHTTP(ctx, domain, token)
+	if httpErr == nil {
+		return SiteVerificationHTTP, nil
+	}
+
+	return "", errors.Join(dnsErr, httpErr)
+}
+
+type SiteVerificationResult struct {
+	Verified bool
+	Error    string
+	// Hours of the released reports, their rollups must be recomputed
+	Buckets []time.Time
+}
+
+// Verifies every domain of the site and saves the outcome.
+// Reports quarantined while the site was unverified are released once it's verified.
+func VerifySite(ctx context.Context, site *models.Site) (*SiteVerificationResult, error) {
+	if len(site.VerificationToken) < 1 {
+		return nil, errors.New("The site has no verification token.")
+	}
+
+	domains := []string{site.Domain}
+
+	for _, d := range site.Domains {
+		domains = append(domains, d.Domain)
+	}
+
+	result := &SiteVerificationResult{Verified: true}
+	method := ""
+
+	for _, d := range domains {
+		m, err := verifyDomain(ctx, d, site.VerificationToken)
+		if err != nil {
+			result.Verified = false
+			result.Error = err.Error()
+			break
+		}
+
+		// The method of the main domain is the one shown
+		if len(method) < 1 {
+			method = m
+		}
+	}
+
+	now := time.Now()
+	site.LastVerificationAt = &now
+
+	if result.Verified {
+		if site.VerifiedAt == nil {
+			site.VerifiedAt = &now
+		}
+
+		site.VerificationMethod = &method
+		site.VerificationError = nil
+		site.VerificationFailures = 0
+	} else {
+		site.VerificationError = &result.Error
+		site.VerificationFailures++
+
+		// A site that was already verified gets some leeway for transient errors
+		if site.VerifiedAt != nil && site.VerificationFailures >= maxSiteVerificationFailures {
+			site.VerifiedAt = nil
+			site.VerificationMethod = nil
+		}
+	}
+
+	err := app.DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&site).Select(
+			"last_verification_at",
+			"verified_at",
+			"verification_method",
+			"verification_error",
+			"verification_failures",
+		).Updates(&site).Error; err != nil {
+			return err
+		}
+
+		if site.VerifiedAt == nil {
+			return nil
+		}
+
+		buckets, err := ReleaseQuarantinedReports(tx, site)
+		result.Buckets = buckets
+
+		return err
+	})
+
+	return result, err
+}
+
+// Makes the quarantined reports of the site visible, grouping them as if they had just arrived
+func ReleaseQuarantinedReports(tx *gorm.DB, site *models.Site) ([]time.Time, error) {
+	query := tx.Model(&models.Report{}).Where(&models.Report{SiteID: site.ID, Quarantined: true})
+
+	buckets, err := GetReportBuckets(query.Session(&gorm.Session{}))
+	if err != nil || len(buckets) < 1 {
+		return nil, err
+	}
+
+	if err := query.Session(&gorm.Session{}).Update("quarantined", false).Error; err != nil {
+		return nil, err
+	}
+
+	if _, err := BackfillViolationGroups(tx); err != nil {
+		return nil, err
+	}
+
+	return buckets, nil
+}
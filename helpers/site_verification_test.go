@@ -0,0 +1,193 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/testutil"
+	"github.com/google/uuid"
+)
+
+// TXT records by name
+type siteVerificationTestResolver map[string][]string
+
+func (r siteVerificationTestResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+
+	return records, nil
+}
+
+// Responses by URL, any other URL is not found
+type siteVerificationTestTransport map[string]string
+
+func (t siteVerificationTestTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	status := http.StatusOK
+	body, ok := t[r.URL.String()]
+
+	if !ok {
+		status = http.StatusNotFound
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     http.Header{},
+		Request:    r,
+	}, nil
+}
+
+func setSiteVerificationTest(t *testing.T, records map[string][]string, files map[string]string) {
+	t.Helper()
+
+	resolver, client := siteVerificationResolver, siteVerificationClient
+	SetSiteVerificationResolver(siteVerificationTestResolver(records))
+	SetSiteVerificationHTTPClient(&http.Client{Transport: siteVerificationTestTransport(files)})
+
+	t.Cleanup(func() {
+		SetSiteVerificationResolver(resolver)
+		SetSiteVerificationHTTPClient(client)
+	})
+}
+
+func TestVerifyDomainDNS(t *testing.T) {
+	setSiteVerificationTest(t, map[string][]string{
+		"_csp-reporter.example.com": {"v=spf1 -all", "csp-reporter-verification=token"},
+		"_csp-reporter.example.org": {"csp-reporter-verification=other"},
+	}, nil)
+
+	tests := []struct {
+		domain string
+		valid  bool
+	}{
+		{"example.com", true},
+		{"example.org", false},
+		{"example.net", false},
+	}
+
+	for _, tt := range tests {
+		if err := verifyDomainDNS(context.Background(), tt.domain, "token"); (err == nil) != tt.valid {
+			t.Errorf("%s: got error %v, want valid %v", tt.domain, err, tt.valid)
+		}
+	}
+}
+
+func TestVerifyDomainHTTP(t *testing.T) {
+	setSiteVerificationTest(t, nil, map[string]string{
+		"https://example.com/.well-known/csp-reporter-verification.txt": "token\n",
+		"https://example.org/.well-known/csp-reporter-verification.txt": "other",
+		// Only HTTPS is checked
+		"http://example.net/.well-known/csp-reporter-verification.txt": "token",
+	})
+
+	tests := []struct {
+		domain string
+		valid  bool
+	}{
+		{"example.com", true},
+		{"example.org", false},
+		{"example.net", false},
+	}
+
+	for _, tt := range tests {
+		if err := verifyDomainHTTP(context.Background(), tt.domain, "token"); (err == nil) != tt.valid {
+			t.Errorf("%s: got error %v, want valid %v", tt.domain, err, tt.valid)
+		}
+	}
+}
+
+func TestVerifyDomain(t *testing.T) {
+	setSiteVerificationTest(t, map[string][]string{
+		"_csp-reporter.dns.example.com":  {"csp-reporter-verification=token"},
+		"_csp-reporter.both.example.com": {"csp-reporter-verification=token"},
+	}, map[string]string{
+		"https://http.example.com/.well-known/csp-reporter-verification.txt": "token",
+		"https://both.example.com/.well-known/csp-reporter-verification.txt": "token",
+	})
+
+	tests := []struct {
+		domain string
+		method string
+	}{
+		{"dns.example.com", SiteVerificationDNS},
+		{"http.example.com", SiteVerificationHTTP},
+		{"both.example.com", SiteVerificationDNS},
+		{"none.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		method, err := verifyDomain(context.Background(), tt.domain, "token")
+
+		if method != tt.method {
+			t.Errorf("%s: got method %q, want %q", tt.domain, method, tt.method)
+		}
+
+		if (err == nil) != (len(tt.method) > 0) {
+			t.Errorf("%s: got error %v", tt.domain, err)
+		}
+	}
+}
+
+// Every domain must be verified, and a verified site keeps its status after a single failure
+func TestVerifySite(t *testing.T) {
+	db := testutil.DB(t)
+
+	domain := uuid.NewString() + ".example.com"
+	alias := uuid.NewString() + ".example.com"
+
+	records := map[string][]string{
+		"_csp-reporter." + domain: {"csp-reporter-verification=token"},
+	}
+	files := map[string]string{}
+	setSiteVerificationTest(t, records, files)
+
+	site := &models.Site{Domain: domain, VerificationToken: "token", Domains: []models.SiteDomain{{Domain: alias}}}
+	if err := db.Create(&site).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Where(&models.SiteDomain{SiteID: site.ID}).Delete(&models.SiteDomain{})
+		db.Unscoped().Delete(&site)
+	})
+
+	steps := []struct {
+		verifyAlias bool
+		verified    bool
+		failures    int
+	}{
+		{false, false, 1},
+		{true, true, 0},
+		{false, true, 1},
+	}
+
+	for i, s := range steps {
+		delete(files, fmt.Sprintf("https://%s%s", alias, siteVerificationPath))
+
+		if s.verifyAlias {
+			files[fmt.Sprintf("https://%s%s", alias, siteVerificationPath)] = "token"
+		}
+
+		result, err := VerifySite(context.Background(), site)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		saved := &models.Site{}
+		if err := db.Where(&models.Site{ID: site.ID}).First(&saved).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Verified != s.verifyAlias || (saved.VerifiedAt != nil) != s.verified || saved.VerificationFailures != s.failures {
+			t.Errorf("step %d: got result %v, verified at %v and %d failures", i, result.Verified, saved.VerifiedAt, saved.VerificationFailures)
+		}
+	}
+}
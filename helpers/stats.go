@@ -0,0 +1,436 @@
+package helpers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/redis/rueidis"
+)
+
+const (
+	defaultStatsTop int = 10
+	maxStatsTop     int = 100
+)
+
+type statsInterval struct {
+	DefaultWindow time.Duration
+	MaxWindow     time.Duration
+	CacheTTL      time.Duration
+}
+
+var statsIntervals = map[string]statsInterval{
+	"minute": {DefaultWindow: time.Hour, MaxWindow: 24 * time.Hour, CacheTTL: time.Minute},
+	"hour":   {DefaultWindow: 24 * time.Hour, MaxWindow: 92 * 24 * time.Hour, CacheTTL: 5 * time.Minute},
+	"day":    {DefaultWindow: 30 * 24 * time.Hour, MaxWindow: 731 * 24 * time.Hour, CacheTTL: 15 * time.Minute},
+}
+
+type statsDimension struct {
+	Column string
+	// Empty when the dimension is not available in the rollup tables
+	RollupColumn string
+}
+
+var statsDimensions = map[string]statsDimension{
+	"site":                {Column: "site_id", RollupColumn: "site_id"},
+	"effective_directive": {Column: "effective_directive", RollupColumn: "effective_directive"},
+	"disposition":         {Column: "disposition", RollupColumn: "disposition"},
+	"blocked_host":        {Column: "blocked_host", RollupColumn: "NULLIF(blocked_host, '')"},
+	"document_path":       {Column: "document_path"},
+	"browser":             {Column: "browser"},
+}
+
+type StatsQuery struct {
+	From     time.Time   `json:"from"`
+	To       time.Time   `json:"to"`
+	Interval string      `json:"interval"`
+	GroupBy  []string    `json:"group_by"`
+	TopBy    []string    `json:"top_by"`
+	Top      int         `json:"top"`
+	SiteIDs  []uuid.UUID `json:"site_ids"`
+}
+
+type StatsBucket struct {
+	Bucket time.Time          `json:"bucket"`
+	Group  map[string]*string `json:"group,omitempty"`
+	Count  int64              `json:"count"`
+}
+
+type StatsTopItem struct {
+	Value *string `json:"value"`
+	Count int64   `json:"count"`
+}
+
+type StatsResult struct {
+	From     time.Time                 `json:"from"`
+	To       time.Time                 `json:"to"`
+	Interval string                    `json:"interval"`
+	GroupBy  []string                  `json:"group_by"`
+	Series   []StatsBucket             `json:"series"`
+	Top      map[string][]StatsTopItem `json:"top"`
+}
+
+func (q StatsQuery) cacheKey() string {
+	raw, err := json.Marshal(q)
+	if err != nil {
+		sentry.CaptureException(err)
+		return ""
+	}
+
+	sum := sha256.Sum256(raw)
+
+	return "stats:reports:" + hex.EncodeToString(sum[:])
+}
+
+func parseStatsDimensions(s string) ([]string, bool) {
+	dims := []string{}
+
+	for _, d := range strings.Split(s, ",") {
+		d = strings.TrimSpace(d)
+
+		if len(d) < 1 {
+			continue
+		}
+
+		if _, ok := statsDimensions[d]; !ok {
+			return nil, false
+		}
+
+		if !slices.Contains(dims, d) {
+			dims = append(dims, d)
+		}
+	}
+
+	return dims, true
+}
+
+func ParseStatsQuery(c *fiber.Ctx) (StatsQuery, fiber.Map) {
+	errs := fiber.Map{}
+	q := StatsQuery{
+		Interval: strings.ToLower(c.Query("interval", "hour")),
+		Top:      defaultStatsTop,
+	}
+
+	interval, ok := statsIntervals[q.Interval]
+	if !ok {
+		errs = utils.AddError(errs, "interval", "The interval must be one of: minute, hour, day.")
+		interval = statsIntervals["hour"]
+	}
+
+	// Rounded so the default window can be cached
+	now := time.Now().In(utils.DefaultLocation()).Truncate(time.Minute)
+	q.To = now
+	q.From = now.Add(-interval.DefaultWindow)
+
+	if to := c.Query("to"); len(to) > 0 {
+		t, err := parseQueryTime(to)
+		if err != nil {
+			errs = utils.AddError(errs, "to", "The end date is invalid.")
+		}
+
+		q.To = t
+	}
+
+	if from := c.Query("from"); len(from) > 0 {
+		t, err := parseQueryTime(from)
+		if err != nil {
+			errs = utils.AddError(errs, "from", "The start date is invalid.")
+		}
+
+		q.From = t
+	} else {
+		q.From = q.To.Add(-interval.DefaultWindow)
+	}
+
+	if !q.From.Before(q.To) {
+		errs = utils.AddError(errs, "from", "The start date must be before the end date.")
+	}
+
+	if q.To.Sub(q.From) > interval.MaxWindow {
+		errs = utils.AddError(errs, "from", fmt.Sprintf("The requested window is too large for the '%s' interval.", q.Interval))
+	}
+
+	groupBy, ok := parseStatsDimensions(c.Query("group_by"))
+	if !ok {
+		errs = utils.AddError(errs, "group_by", "One or more grouping dimensions are invalid.")
+	}
+
+	q.GroupBy = groupBy
+
+	topBy, ok := parseStatsDimensions(c.Query("top_by", "blocked_host,effective_directive,document_path"))
+	if !ok {
+		errs = utils.AddError(errs, "top_by", "One or more top list dimensions are invalid.")
+	}
+
+	q.TopBy = topBy
+
+	if top := c.Query("top"); len(top) > 0 {
+		n, err := strconv.Atoi(top)
+		if err != nil || n < 1 || n > maxStatsTop {
+			errs = utils.AddError(errs, "top", fmt.Sprintf("The top list size must be between 1 and %d.", maxStatsTop))
+		}
+
+		q.Top = n
+	}
+
+	for _, s := range strings.Split(c.Query("site_id"), ",") {
+		s = strings.TrimSpace(s)
+
+		if len(s) < 1 {
+			continue
+		}
+
+		id, err := uuid.Parse(s)
+		if err != nil || !utils.IsValidUuid(id) {
+			errs = utils.AddError(errs, "site_id", "One or more sites are invalid.")
+			continue
+		}
+
+		q.SiteIDs = append(q.SiteIDs, id)
+	}
+
+	return q, errs
+}
+
+func (q StatsQuery) canUseRollups() bool {
+	if q.Interval != RollupHourly && q.Interval != RollupDaily {
+		return false
+	}
+
+	for _, d := range append(slices.Clone(q.GroupBy), q.TopBy...) {
+		if len(statsDimensions[d].RollupColumn) < 1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the range of complete buckets that can be read from the rollup
+// tables. The most recent bucket is excluded as it might not be computed yet.
+func (q StatsQuery) rollupRange() (time.Time, time.Time, bool) {
+	if !q.canUseRollups() {
+		return time.Time{}, time.Time{}, false
+	}
+
+	from, end := RollupBucket(q.Interval, q.From)
+
+	if !from.Equal(q.From) {
+		from = end
+	}
+
+	to, _ := RollupBucket(q.Interval, q.To)
+	latest, _ := RollupBucket(q.Interval, time.Now())
+	latest, _ = RollupBucket(q.Interval, latest.Add(-time.Second))
+
+	if to.After(latest) {
+		to = latest
+	}
+
+	return from, to, from.Before(to)
+}
+
+// Builds a subquery with the bucket, the requested dimensions and the count,
+// combining rollups for complete buckets and raw reports for the rest.
+func statsSource(q StatsQuery, dims []string) (string, []interface{}) {
+	rawColumns := []string{"date_trunc(@interval, created_at, @tz) AS bucket"}
+	rollupColumns := []string{"bucket"}
+	groups := []string{"1"}
+
+	for i, d := range dims {
+		rawColumns = append(rawColumns, fmt.Sprintf("%s::text AS %s", statsDimensions[d].Column, d))
+		rollupColumns = append(rollupColumns, fmt.Sprintf("%s::text AS %s", statsDimensions[d].RollupColumn, d))
+		groups = append(groups, strconv.Itoa(i+2))
+	}
+
+	siteFilter := ""
+	args := []interface{}{
+		sql.Named("from", q.From),
+		sql.Named("to", q.To),
+		sql.Named("interval", q.Interval),
+		sql.Named("tz", utils.DefaultTimeZone()),
+	}
+
+	if len(q.SiteIDs) > 0 {
+		siteFilter = " AND site_id IN @site_ids"
+		args = append(args, sql.Named("site_ids", q.SiteIDs))
+	}
+
+	rawSelect := fmt.Sprintf("SELECT %s, count(*) AS count FROM reports WHERE deleted_at IS NULL AND NOT quarantined%s AND %%s GROUP BY %s", strings.Join(rawColumns, ", "), siteFilter, strings.Join(groups, ", "))
+
+	rollupFrom, rollupTo, ok := q.rollupRange()
+	if !ok {
+		return fmt.Sprintf(rawSelect, "created_at >= @from AND created_at < @to"), args
+	}
+
+	table, err := getRollupTable(q.Interval)
+	if err != nil {
+		sentry.CaptureException(err)
+		return fmt.Sprintf(rawSelect, "created_at >= @from AND created_at < @to"), args
+	}
+
+	args = append(args, sql.Named("rollup_from", rollupFrom), sql.Named("rollup_to", rollupTo))
+
+	return fmt.Sprintf(
+		"SELECT %[1]s, count FROM %[2]s WHERE bucket >= @rollup_from AND bucket < @rollup_to%[3]s UNION ALL %[4]s",
+		strings.Join(rollupColumns, ", "),
+		table,
+		siteFilter,
+		fmt.Sprintf(rawSelect, "((created_at >= @from AND created_at < @rollup_from) OR (created_at >= @rollup_to AND created_at < @to))"),
+	), args
+}
+
+func getStatsSeries(q StatsQuery) ([]StatsBucket, error) {
+	source, args := statsSource(q, q.GroupBy)
+	groups := append([]string{"bucket"}, q.GroupBy...)
+	rows := []map[string]interface{}{}
+
+	//#nosec G201 -- Columns are taken from a whitelist
+	if err := app.DB().Raw(fmt.Sprintf(
+		"SELECT %[1]s, sum(count)::bigint AS count FROM (%[2]s) s GROUP BY %[1]s ORDER BY bucket ASC, count DESC",
+		strings.Join(groups, ", "),
+		source,
+	), args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	series := []StatsBucket{}
+
+	for _, row := range rows {
+		bucket := StatsBucket{Count: toInt64(row["count"])}
+
+		if t, ok := row["bucket"].(time.Time); ok {
+			bucket.Bucket = t.In(utils.DefaultLocation())
+		}
+
+		if len(q.GroupBy) > 0 {
+			bucket.Group = map[string]*string{}
+
+			for _, d := range q.GroupBy {
+				bucket.Group[d] = toStringPtr(row[d])
+			}
+		}
+
+		series = append(series, bucket)
+	}
+
+	return series, nil
+}
+
+func getStatsTop(q StatsQuery, dimension string) ([]StatsTopItem, error) {
+	source, args := statsSource(q, []string{dimension})
+	items := []StatsTopItem{}
+
+	//#nosec G201 -- Columns are taken from a whitelist
+	if err := app.DB().Raw(fmt.Sprintf(
+		"SELECT %[1]s AS value, sum(count)::bigint AS count FROM (%[2]s) s GROUP BY %[1]s ORDER BY count DESC LIMIT @top",
+		dimension,
+		source,
+	), append(args, sql.Named("top", q.Top))...).Scan(&items).Error; err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func GetReportStats(q StatsQuery) (*StatsResult, error) {
+	key := q.cacheKey()
+	result := &StatsResult{}
+
+	if len(key) > 0 {
+		cached, err := app.Cache().DoCache(context.Background(), app.Cache().B().Get().Key(key).Cache(), time.Minute).ToString()
+		if err != nil && !errors.Is(err, rueidis.Nil) {
+			sentry.CaptureException(err)
+			slog.Warn(fmt.Sprintf("Could not get cached statistics: %v", err))
+		}
+
+		if len(cached) > 0 {
+			if err := json.Unmarshal([]byte(cached), &result); err != nil {
+				slog.Error(fmt.Sprintf("Could not decode cached statistics: %v", err))
+			} else {
+				return result, nil
+			}
+		}
+	}
+
+	series, err := getStatsSeries(q)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get statistics series: %w", err)
+	}
+
+	result = &StatsResult{
+		From:     q.From,
+		To:       q.To,
+		Interval: q.Interval,
+		GroupBy:  q.GroupBy,
+		Series:   series,
+		Top:      map[string][]StatsTopItem{},
+	}
+
+	for _, d := range q.TopBy {
+		top, err := getStatsTop(q, d)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get top %s list: %w", d, err)
+		}
+
+		result.Top[d] = top
+	}
+
+	if len(key) < 1 {
+		return result, nil
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not serialize statistics for cache: %v", err))
+		return result, nil
+	}
+
+	if err := app.Cache().Do(context.Background(), app.Cache().B().Set().Key(key).Value(string(raw)).Ex(statsIntervals[q.Interval].CacheTTL).Build()).Error(); err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Could not save statistics to cache: %v", err))
+	}
+
+	return result, nil
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int32:
+		return int64(n)
+	case int:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+
+	return 0
+}
+
+func toStringPtr(v interface{}) *string {
+	switch s := v.(type) {
+	case string:
+		return &s
+	case []byte:
+		str := string(s)
+		return &str
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	StorageLocal string = "local"
+	StorageS3    string = "s3"
+)
+
+type Storage interface {
+	Create(ctx context.Context, key string) (io.WriteCloser, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+func GetStorage(name string) (Storage, error) {
+	switch name {
+	case StorageLocal:
+		return localStorage{BasePath: utils.ExportStoragePath()}, nil
+	case StorageS3:
+		bucket := strings.TrimSpace(os.Getenv("AWS_BUCKET"))
+
+		if len(bucket) < 1 {
+			return nil, errors.New("The storage bucket is empty.")
+		}
+
+		return s3Storage{Bucket: bucket}, nil
+	default:
+		return nil, fmt.Errorf("Invalid storage '%s'.", name)
+	}
+}
+
+type localStorage struct {
+	BasePath string
+}
+
+func (s localStorage) path(key string) (string, error) {
+	p := filepath.Join(s.BasePath, filepath.Clean("/"+key))
+
+	if !strings.HasPrefix(p, filepath.Clean(s.BasePath)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("Invalid storage key '%s'.", key)
+	}
+
+	return p, nil
+}
+
+func (s localStorage) Create(_ context.Context, key string) (io.WriteCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600) //#nosec G304 -- The path is validated above
+}
+
+func (s localStorage) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(p) //#nosec G304 -- The path is validated above
+}
+
+func (s localStorage) Delete(_ context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+type s3Storage struct {
+	Bucket string
+}
+
+// Streams the written data to the bucket as a multipart upload
+type s3Writer struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pipe.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	if err := w.pipe.Close(); err != nil {
+		return err
+	}
+
+	return <-w.done
+}
+
+func (s s3Storage) Create(ctx context.Context, key string) (io.WriteCloser, error) {
+	reader, writer := io.Pipe()
+	w := &s3Writer{pipe: writer, done: make(chan error, 1)}
+
+	go func() {
+		_, err := manager.NewUploader(app.S3()).Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(key),
+			Body:   reader,
+		})
+
+		// Unblock the writer if the upload fails
+		_ = reader.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return w, nil
+}
+
+func (s s3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := app.S3().GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Body, nil
+}
+
+func (s s3Storage) Delete(ctx context.Context, key string) error {
+	_, err := app.S3().DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+
+	return err
+}
@@ -14,8 +14,11 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/redis/rueidis"
+	"gorm.io/gorm"
 )
 
+const accountRecoveryExpiration time.Duration = 6 * time.Hour
+
 func UserExists(id uuid.UUID, email string) bool {
 	if !utils.IsValidUuid(id) || !utils.IsValidEmail(email) {
 		return false
@@ -33,8 +36,10 @@ func UserExists(id uuid.UUID, email string) bool {
 		return true
 	}
 
+	// Deactivated accounts and forced password resets end the existing sessions
+	active := true
 	user := &models.User{}
-	if err := app.DB().Where(&models.User{ID: id, Email: email}).First(&user).Error; err != nil {
+	if err := app.DB().Where(&models.User{ID: id, Email: email, Active: &active}).Where("must_change_password IS NOT TRUE").First(&user).Error; err != nil {
 		return false
 	}
 
@@ -51,6 +56,10 @@ func UserExists(id uuid.UUID, email string) bool {
 }
 
 func GetUserID(c *fiber.Ctx) uuid.UUID {
+	if token := GetRequestAPIToken(c); token != nil {
+		return token.UserID
+	}
+
 	jwe := c.Locals(utils.AccessTokenContextKey())
 
 	if jwe == nil {
@@ -65,3 +74,23 @@ func GetUserID(c *fiber.Ctx) uuid.UUID {
 
 	return uuid.MustParse(claims.User.ID.String())
 }
+
+// Creates the link the user needs to set a new password
+func NewAccountRecovery(tx *gorm.DB, userID uuid.UUID) (*models.AccountRecovery, error) {
+	hash, err := utils.RandomString(35)
+	if err != nil || len(hash) < 1 {
+		return nil, fmt.Errorf("Error generating random string: %w", err)
+	}
+
+	recovery := &models.AccountRecovery{
+		Hash:      hash,
+		UserID:    userID,
+		ExpiresAt: time.Now().In(utils.DefaultLocation()).Add(accountRecoveryExpiration),
+	}
+
+	if err := tx.Create(&recovery).Error; err != nil {
+		return nil, err
+	}
+
+	return recovery, nil
+}
@@ -0,0 +1,266 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	WebhookReportCreated  string = "report.created"
+	WebhookGroupNew       string = "group.new"
+	WebhookAlertFired     string = "alert.fired"
+	WebhookUserRegistered string = "user.registered"
+	WebhookUserActivated  string = "user.activated"
+	// Sent by the test action only
+	WebhookPing string = "ping"
+)
+
+const (
+	webhookSecretPrefix    string = "whsec_"
+	maxWebhookResponseBody int64  = 1024
+)
+
+var webhookClient = newWebhookClient()
+
+// Clock of the signature timestamps, replaced in tests
+var webhookNow = time.Now
+
+// Only public addresses can be reached, unless debug mode is enabled
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			if utils.IsDebug() {
+				return nil
+			}
+
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("The address %s is not allowed.", host)
+			}
+
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast()
+}
+
+// Replaces the client used to deliver webhooks, such as one for a local stand-in server
+func SetWebhookHTTPClient(c *http.Client) {
+	webhookClient = c
+}
+
+func WebhookEventTypes() []string {
+	return []string{WebhookReportCreated, WebhookGroupNew, WebhookAlertFired, WebhookUserRegistered, WebhookUserActivated}
+}
+
+type WebhookEvent struct {
+	ID        uuid.UUID       `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func NewWebhookEvent(event string, data any) (WebhookEvent, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return WebhookEvent{}, fmt.Errorf("Could not encode webhook event data: %w", err)
+	}
+
+	return WebhookEvent{
+		ID:        uuid.New(),
+		Event:     event,
+		CreatedAt: time.Now().In(utils.DefaultLocation()),
+		Data:      raw,
+	}, nil
+}
+
+func NewWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return webhookSecretPrefix + hex.EncodeToString(b), nil
+}
+
+// HMAC-SHA256 of the timestamp and body, joined by a dot
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func ValidateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return errors.New("The webhook URL must use HTTP or HTTPS.")
+	}
+
+	if len(u.Hostname()) < 1 {
+		return errors.New("The webhook URL must have a host.")
+	}
+
+	if u.User != nil {
+		return errors.New("The webhook URL cannot contain credentials.")
+	}
+
+	return nil
+}
+
+// Sends the event to the webhook and saves the attempt in the delivery log
+func DeliverWebhook(ctx context.Context, w *models.Webhook, e WebhookEvent, attempt int) (*models.WebhookDelivery, error) {
+	body, err := FormatWebhookPayload(w.Kind, e)
+	if err != nil {
+		return nil, fmt.Errorf("Could not encode webhook event: %w", err)
+	}
+
+	delivery := &models.WebhookDelivery{
+		WebhookID: w.ID,
+		EventID:   e.ID,
+		Event:     e.Event,
+		Payload:   body,
+		Attempt:   attempt,
+	}
+
+	start := time.Now()
+	deliveryErr := sendWebhookRequest(ctx, w, e, body, delivery)
+	delivery.Duration = time.Since(start).Milliseconds()
+	delivery.Success = deliveryErr == nil
+
+	if deliveryErr != nil {
+		msg := deliveryErr.Error()
+		delivery.Error = &msg
+	}
+
+	if err := app.DB().Create(&delivery).Error; err != nil {
+		return delivery, fmt.Errorf("Could not save webhook delivery: %w", err)
+	}
+
+	if err := app.DB().Model(&models.Webhook{}).
+		Where(&models.Webhook{ID: w.ID}).
+		Update("last_delivery_at", delivery.CreatedAt).Error; err != nil {
+		return delivery, fmt.Errorf("Could not update webhook: %w", err)
+	}
+
+	return delivery, deliveryErr
+}
+
+func sendWebhookRequest(ctx context.Context, w *models.Webhook, e WebhookEvent, body []byte, delivery *models.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, utils.WebhookTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := webhookNow().Unix()
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CSP-Reporter-Webhooks/1.0")
+	req.Header.Set("X-CSP-Reporter-Event", e.Event)
+	req.Header.Set("X-CSP-Reporter-Delivery", e.ID.String())
+	req.Header.Set("X-CSP-Reporter-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-CSP-Reporter-Signature", "sha256="+SignWebhookPayload(w.Secret, timestamp, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseBody))
+	if err != nil {
+		return err
+	}
+
+	delivery.StatusCode = &resp.StatusCode
+
+	if len(respBody) > 0 {
+		s := string(bytes.ToValidUTF8(respBody, []byte{}))
+		delivery.ResponseBody = &s
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected response status %d.", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func RecordWebhookSuccess(id uuid.UUID) error {
+	return app.DB().Model(&models.Webhook{}).
+		Where(&models.Webhook{ID: id}).
+		Update("failure_count", 0).Error
+}
+
+// Counts a failed event, disabling the webhook after too many consecutive failures
+func RecordWebhookFailure(id uuid.UUID) (bool, error) {
+	enabled := true
+
+	if err := app.DB().Raw(`UPDATE webhooks SET
+			failure_count = failure_count + 1,
+			enabled = failure_count + 1 < @limit,
+			disabled_at = CASE WHEN failure_count + 1 >= @limit THEN clock_timestamp() ELSE disabled_at END,
+			updated_at = clock_timestamp()
+		WHERE id = @id
+		RETURNING enabled`,
+		sql.Named("id", id),
+		sql.Named("limit", utils.WebhookFailureLimit()),
+	).Scan(&enabled).Error; err != nil {
+		return false, err
+	}
+
+	return !enabled, nil
+}
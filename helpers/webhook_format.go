@@ -0,0 +1,329 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"alfredoramos.mx/csp-reporter/models"
+)
+
+const (
+	WebhookJSON       string = "json"
+	WebhookSlack      string = "slack"
+	WebhookMattermost string = "mattermost"
+	WebhookMSTeams    string = "msteams"
+	WebhookDiscord    string = "discord"
+)
+
+const (
+	webhookColorInfo    int = 0x0c4a6e
+	webhookColorWarning int = 0xd97706
+	webhookColorDanger  int = 0xdc2626
+	// Most platforms limit the length of each field
+	maxWebhookFieldLength int = 1000
+	maxWebhookTextLength  int = 3000
+)
+
+func WebhookKinds() []string {
+	return []string{WebhookJSON, WebhookSlack, WebhookMattermost, WebhookMSTeams, WebhookDiscord}
+}
+
+type webhookMessageField struct {
+	Name  string
+	Value string
+}
+
+// Platform-independent content of a chat message
+type webhookMessage struct {
+	Title  string
+	Text   string
+	Fields []webhookMessageField
+	URL    string
+	Color  int
+}
+
+func (m *webhookMessage) addField(name string, value string) {
+	if len(value) > 0 {
+		m.Fields = append(m.Fields, webhookMessageField{Name: name, Value: truncateWebhookText(value, maxWebhookFieldLength)})
+	}
+}
+
+func truncateWebhookText(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+// Absolute URL of a page of the frontend
+func AppURL(path string) string {
+	return strings.TrimRight(os.Getenv("APP_DOMAIN"), "/") + path
+}
+
+func siteLabel(s models.Site) string {
+	if s.Title != nil && len(*s.Title) > 0 {
+		return fmt.Sprintf("%s (%s)", *s.Title, s.Domain)
+	}
+
+	return s.Domain
+}
+
+// Renders the event as the body expected by the webhook kind
+func FormatWebhookPayload(kind string, e WebhookEvent) ([]byte, error) {
+	if kind == WebhookJSON || len(kind) < 1 {
+		return json.Marshal(e)
+	}
+
+	m, err := newWebhookMessage(e)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Title = truncateWebhookText(m.Title, 250)
+	m.Text = truncateWebhookText(m.Text, maxWebhookTextLength)
+
+	switch kind {
+	case WebhookSlack:
+		return json.Marshal(slackPayload(m))
+	case WebhookMattermost:
+		return json.Marshal(mattermostPayload(m))
+	case WebhookMSTeams:
+		return json.Marshal(msteamsPayload(m))
+	case WebhookDiscord:
+		return json.Marshal(discordPayload(m, e))
+	default:
+		return nil, fmt.Errorf("Invalid webhook kind '%s'.", kind)
+	}
+}
+
+func newWebhookMessage(e WebhookEvent) (*webhookMessage, error) {
+	m := &webhookMessage{Color: webhookColorInfo}
+
+	switch e.Event {
+	case WebhookReportCreated:
+		r := models.Report{}
+		if err := json.Unmarshal(e.Data, &r); err != nil {
+			return nil, err
+		}
+
+		m.Title = "New CSP violation report"
+		m.Text = fmt.Sprintf("A `%s` violation has been reported on %s.", r.EffectiveDirective, siteLabel(r.Site))
+		m.URL = AppURL("/reports/" + r.ID.String())
+		m.Color = webhookColorWarning
+		m.addField("Site", siteLabel(r.Site))
+		m.addField("Directive", r.EffectiveDirective)
+		m.addField("Blocked URI", r.BlockedURI)
+		m.addField("Document URI", r.DocumentURI)
+		m.addField("Disposition", r.Disposition)
+	case WebhookGroupNew:
+		g := models.ViolationGroup{}
+		if err := json.Unmarshal(e.Data, &g); err != nil {
+			return nil, err
+		}
+
+		m.Title = "New violation group"
+		m.Text = fmt.Sprintf("The first `%s` violation from this blocked host has been reported on %s.", g.EffectiveDirective, siteLabel(g.Site))
+		m.URL = AppURL("/groups/" + g.ID.String())
+		m.Color = webhookColorWarning
+		m.addField("Site", siteLabel(g.Site))
+		m.addField("Directive", g.EffectiveDirective)
+		m.addField("Blocked host", g.BlockedHost)
+	case WebhookAlertFired:
+		a := struct {
+			Alert models.AlertEvent `json:"alert"`
+			Rule  models.AlertRule  `json:"rule"`
+		}{}
+		if err := json.Unmarshal(e.Data, &a); err != nil {
+			return nil, err
+		}
+
+		m.Title = "Alert: " + a.Rule.Name
+		m.Text = a.Alert.Message
+		m.URL = AppURL("/alerts/rules/" + a.Rule.ID.String())
+		m.Color = webhookColorDanger
+		m.addField("Site", siteLabel(a.Rule.Site))
+
+		if a.Rule.EffectiveDirective != nil {
+			m.addField("Directive", *a.Rule.EffectiveDirective)
+		}
+
+		m.addField("Rule", a.Rule.Kind)
+	case WebhookUserRegistered, WebhookUserActivated:
+		u := models.User{}
+		if err := json.Unmarshal(e.Data, &u); err != nil {
+			return nil, err
+		}
+
+		m.Title = "New user registration"
+		m.Text = fmt.Sprintf("%s is waiting for activation.", u.Email)
+		m.URL = AppURL("/activations")
+
+		if e.Event == WebhookUserActivated {
+			m.Title = "User account activated"
+			m.Text = fmt.Sprintf("The account of %s has been activated.", u.Email)
+		}
+
+		m.addField("Name", u.GetFullName())
+		m.addField("Email", u.Email)
+	case WebhookNotificationDigest:
+		d := DigestPayload{}
+		if err := json.Unmarshal(e.Data, &d); err != nil {
+			return nil, err
+		}
+
+		lines := []string{}
+
+		for _, item := range d.Items {
+			lines = append(lines, "• "+item.Title)
+		}
+
+		if more := d.Count - len(d.Items); more > 0 {
+			lines = append(lines, fmt.Sprintf("And %d more.", more))
+		}
+
+		m.Title = fmt.Sprintf("%d new notifications", d.Count)
+		m.Text = strings.Join(lines, "\n")
+		m.URL = AppURL("/notifications")
+	case WebhookPing:
+		m.Title = "Test event"
+		m.Text = "This is a test event, the webhook is working."
+	default:
+		m.Title = e.Event
+		m.Text = string(e.Data)
+	}
+
+	return m, nil
+}
+
+func slackPayload(m *webhookMessage) map[string]interface{} {
+	blocks := []map[string]interface{}{
+		{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": m.Title},
+		},
+		{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": m.Text},
+		},
+	}
+
+	// Slack allows up to 10 fields per section
+	fields := []map[string]interface{}{}
+
+	for _, f := range m.Fields[:min(len(m.Fields), 10)] {
+		fields = append(fields, map[string]interface{}{"type": "mrkdwn", "text": fmt.Sprintf("*%s*\n%s", f.Name, f.Value)})
+	}
+
+	if len(fields) > 0 {
+		blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+	}
+
+	if len(m.URL) > 0 {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "actions",
+			"elements": []map[string]interface{}{{
+				"type": "button",
+				"text": map[string]interface{}{"type": "plain_text", "text": "View details"},
+				"url":  m.URL,
+			}},
+		})
+	}
+
+	return map[string]interface{}{
+		"text":   m.Title,
+		"blocks": blocks,
+	}
+}
+
+func mattermostPayload(m *webhookMessage) map[string]interface{} {
+	fields := []map[string]interface{}{}
+
+	for _, f := range m.Fields {
+		fields = append(fields, map[string]interface{}{"title": f.Name, "value": f.Value, "short": len(f.Value) < 40})
+	}
+
+	attachment := map[string]interface{}{
+		"fallback": m.Title,
+		"color":    fmt.Sprintf("#%06x", m.Color),
+		"title":    m.Title,
+		"text":     m.Text,
+		"fields":   fields,
+	}
+
+	if len(m.URL) > 0 {
+		attachment["title_link"] = m.URL
+	}
+
+	return map[string]interface{}{
+		"text":        m.Title,
+		"attachments": []map[string]interface{}{attachment},
+	}
+}
+
+// Adaptive card, as accepted by Teams workflows and incoming webhooks
+func msteamsPayload(m *webhookMessage) map[string]interface{} {
+	facts := []map[string]interface{}{}
+
+	for _, f := range m.Fields {
+		facts = append(facts, map[string]interface{}{"title": f.Name, "value": f.Value})
+	}
+
+	body := []map[string]interface{}{
+		{"type": "TextBlock", "text": m.Title, "weight": "Bolder", "size": "Medium", "wrap": true},
+		{"type": "TextBlock", "text": m.Text, "wrap": true},
+	}
+
+	if len(facts) > 0 {
+		body = append(body, map[string]interface{}{"type": "FactSet", "facts": facts})
+	}
+
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+
+	if len(m.URL) > 0 {
+		card["actions"] = []map[string]interface{}{{"type": "Action.OpenUrl", "title": "View details", "url": m.URL}}
+	}
+
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	}
+}
+
+func discordPayload(m *webhookMessage, e WebhookEvent) map[string]interface{} {
+	// Discord allows up to 25 fields per embed
+	fields := []map[string]interface{}{}
+
+	for _, f := range m.Fields[:min(len(m.Fields), 25)] {
+		fields = append(fields, map[string]interface{}{"name": f.Name, "value": f.Value, "inline": len(f.Value) < 40})
+	}
+
+	embed := map[string]interface{}{
+		"title":       m.Title,
+		"description": m.Text,
+		"color":       m.Color,
+		"fields":      fields,
+		"timestamp":   e.CreatedAt,
+	}
+
+	if len(m.URL) > 0 {
+		embed["url"] = m.URL
+	}
+
+	return map[string]interface{}{
+		"embeds": []map[string]interface{}{embed},
+		// Prevents mentions in the reported values from pinging anyone
+		"allowed_mentions": map[string]interface{}{"parse": []string{}},
+	}
+}
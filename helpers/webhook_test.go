@@ -0,0 +1,141 @@
+package helpers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/models"
+)
+
+type webhookTestRequest struct {
+	Header http.Header
+	Body   []byte
+}
+
+// Server answering with the given statuses in order, the last one is repeated
+func newWebhookTestServer(t *testing.T, statuses ...int) (*httptest.Server, *[]webhookTestRequest) {
+	t.Helper()
+
+	requests := []webhookTestRequest{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, webhookTestRequest{Header: r.Header.Clone(), Body: body})
+		w.WriteHeader(statuses[min(len(requests), len(statuses))-1])
+		_, _ = w.Write([]byte("received"))
+	}))
+
+	client := webhookClient
+	SetWebhookHTTPClient(srv.Client())
+
+	t.Cleanup(func() {
+		srv.Close()
+		SetWebhookHTTPClient(client)
+	})
+
+	return srv, &requests
+}
+
+func TestSendWebhookRequestSignature(t *testing.T) {
+	srv, requests := newWebhookTestServer(t, http.StatusOK)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	webhookNow = func() time.Time { return now }
+	t.Cleanup(func() { webhookNow = time.Now })
+
+	w := &models.Webhook{URL: srv.URL, Kind: WebhookJSON, Secret: "whsec_test"}
+	e, err := NewWebhookEvent(WebhookPing, map[string]string{"message": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"event":"ping"}`)
+	delivery := &models.WebhookDelivery{}
+
+	if err := sendWebhookRequest(context.Background(), w, e, body, delivery); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*requests))
+	}
+
+	req := (*requests)[0]
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	if got := req.Header.Get("X-CSP-Reporter-Timestamp"); got != timestamp {
+		t.Errorf("timestamp header: got %q, want %q", got, timestamp)
+	}
+
+	if got := req.Header.Get("X-CSP-Reporter-Signature"); got != want {
+		t.Errorf("signature header: got %q, want %q", got, want)
+	}
+
+	if got := req.Header.Get("X-CSP-Reporter-Delivery"); got != e.ID.String() {
+		t.Errorf("delivery header: got %q, want %q", got, e.ID)
+	}
+
+	if string(req.Body) != string(body) {
+		t.Errorf("body: got %s, want %s", req.Body, body)
+	}
+
+	if delivery.StatusCode == nil || *delivery.StatusCode != http.StatusOK {
+		t.Errorf("delivery status: got %v, want %d", delivery.StatusCode, http.StatusOK)
+	}
+}
+
+func TestSendWebhookRequestStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		fails  bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNoContent, false},
+		{http.StatusFound, true},
+		{http.StatusNotFound, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+
+	for _, tt := range tests {
+		srv, _ := newWebhookTestServer(t, tt.status)
+		w := &models.Webhook{URL: srv.URL, Kind: WebhookJSON, Secret: "whsec_test"}
+		delivery := &models.WebhookDelivery{}
+
+		err := sendWebhookRequest(context.Background(), w, WebhookEvent{Event: WebhookPing}, []byte("{}"), delivery)
+
+		if (err != nil) != tt.fails {
+			t.Errorf("status %d: got error %v, want failure %v", tt.status, err, tt.fails)
+		}
+
+		if delivery.StatusCode == nil || *delivery.StatusCode != tt.status {
+			t.Errorf("status %d: delivery status %v", tt.status, delivery.StatusCode)
+		}
+	}
+}
+
+func TestWebhookClientRejectsPrivateAddresses(t *testing.T) {
+	t.Setenv("APP_DEBUG", "false")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	w := &models.Webhook{URL: srv.URL, Kind: WebhookJSON, Secret: "whsec_test"}
+
+	if err := sendWebhookRequest(context.Background(), w, WebhookEvent{Event: WebhookPing}, []byte("{}"), &models.WebhookDelivery{}); err == nil {
+		t.Fatal("A loopback address must not be reachable.")
+	}
+}
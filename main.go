@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/commands"
+	"alfredoramos.mx/csp-reporter/helpers"
 	"alfredoramos.mx/csp-reporter/routes"
 	"alfredoramos.mx/csp-reporter/tasks"
 	"alfredoramos.mx/csp-reporter/utils"
@@ -47,6 +49,17 @@ func main() {
 		}
 	}()
 
+	// Command line
+	if len(os.Args) > 1 {
+		if err := commands.Run(os.Args[1:]); err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Command error: %v", err))
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	// Setup app
 	app := fiber.New(fiber.Config{
 		StrictRouting: true,
@@ -73,6 +86,21 @@ func main() {
 	// Setup routes
 	routes.SetupRoutes(app)
 
+	// Check routes and policies match
+	check, err := helpers.CheckRoutePolicies(app.GetRoutes(true))
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Could not check policies: %v", err))
+		os.Exit(1)
+	}
+
+	check.Log()
+
+	if !check.OK() && utils.StrictPolicyCheck() && !utils.IsDebug() {
+		slog.Error("Routes and policies do not match, run the policies:check command for details")
+		os.Exit(1)
+	}
+
 	// Asynq server
 	go func() {
 		queue := tasks.AsynqServer()
@@ -21,6 +21,11 @@ import (
 
 func ValidateAccessToken() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		// Already validated by AuthProtected
+		if helpers.GetRequestAPIToken(c) != nil {
+			return c.Next()
+		}
+
 		accessJWE := c.Locals(utils.AccessTokenContextKey()).(string)
 
 		if len(accessJWE) < 1 || len(c.Get("Authorization")) <= 7 {
@@ -119,6 +124,12 @@ func ValidateAccessToken() fiber.Handler {
 
 func ValidateRefreshToken() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if helpers.GetRequestAPIToken(c) != nil {
+			return c.Status(fiber.StatusForbidden).JSON(&fiber.Map{
+				"error": []string{"API tokens cannot be refreshed."},
+			})
+		}
+
 		accessJWE := c.Locals(utils.AccessTokenContextKey()).(string)
 
 		if len(accessJWE) < 1 || len(c.Get("Authorization")) <= 7 {
@@ -275,6 +286,22 @@ func AuthProtected() fiber.Handler {
 
 		tokenStr := c.Get("Authorization")[7:]
 
+		if helpers.IsAPIToken(tokenStr) {
+			token, err := helpers.GetAPIToken(tokenStr)
+			if err != nil || !helpers.UserExists(token.UserID, token.User.Email) {
+				return c.Status(fiber.StatusForbidden).JSON(&fiber.Map{"error": []string{"Invalid or expired access token."}})
+			}
+
+			if err := helpers.TouchAPIToken(token, c.IP()); err != nil {
+				sentry.CaptureException(err)
+				slog.Error(fmt.Sprintf("Could not update API token last use: %v", err))
+			}
+
+			helpers.SetRequestAPIToken(c, token)
+
+			return c.Next()
+		}
+
 		jwe, err := jose.ParseEncryptedCompact(
 			tokenStr,
 			[]jose.KeyAlgorithm{jose.ECDH_ES_A256KW},
@@ -323,10 +350,31 @@ func CheckPermissions() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		id := helpers.GetUserID(c)
 
-		if helpers.HasPermission(id, c.Path(), c.Method()) {
+		siteID, err := helpers.ResolvePermissionSite(c)
+		if err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not resolve the site of the request: %v", err))
+
+			return c.Status(fiber.StatusInternalServerError).JSON(&fiber.Map{
+				"error": []string{"Could not check permissions."},
+			})
+		}
+
+		scope, err := helpers.GetPermittedSites(c, siteID, c.Path(), c.Method())
+		if err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("User roles error: %v", err))
+		}
+
+		if scope != nil && (siteID == nil || scope.Allows(*siteID)) {
+			// List endpoints only show the sites allowed here
+			helpers.SetRequestSiteScope(c, scope)
+
 			return c.Next()
 		}
 
+		helpers.RecordPermissionDenied(c, id)
+
 		return c.Status(fiber.StatusForbidden).JSON(&fiber.Map{
 			"error": []string{"You are not allowed to access this resource."},
 		})
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AlertRule struct {
+	ID                 uuid.UUID      `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	SiteID             uuid.UUID      `gorm:"not null;index" json:"site_id"`
+	Site               Site           `json:"site"`
+	Name               string         `gorm:"size:255;not null;check:name <> ''" json:"name"`
+	Kind               string         `gorm:"size:20;not null" json:"kind"`
+	EffectiveDirective *string        `gorm:"size:100" json:"effective_directive"`
+	Threshold          int64          `gorm:"not null;default:0;check:threshold >= 0" json:"threshold"`
+	WindowMinutes      int            `gorm:"not null;default:60;check:window_minutes > 0" json:"window_minutes"`
+	MinCount           int64          `gorm:"not null;default:0;check:min_count >= 0" json:"min_count"`
+	CooldownMinutes    int            `gorm:"not null;default:60;check:cooldown_minutes >= 0" json:"cooldown_minutes"`
+	Enabled            *bool          `gorm:"not null;default:true" json:"enabled"`
+	LastEvaluatedAt    *time.Time     `json:"last_evaluated_at"`
+	LastFiredAt        *time.Time     `json:"last_fired_at"`
+	CreatedByID        uuid.UUID      `gorm:"type:uuid;not null" json:"-"`
+	CreatedBy          User           `gorm:"foreignKey:CreatedByID" json:"-"`
+	CreatedAt          time.Time      `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+	UpdatedAt          time.Time      `gorm:"not null;default:clock_timestamp()" json:"updated_at"`
+	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (ar AlertRule) GetID() uuid.UUID {
+	return ar.ID
+}
+
+func (ar AlertRule) GetCreatedAt() time.Time {
+	return ar.CreatedAt
+}
+
+type AlertEvent struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	RuleID    uuid.UUID `gorm:"not null;index" json:"rule_id"`
+	Rule      AlertRule `json:"-"`
+	SiteID    uuid.UUID `gorm:"not null;index" json:"site_id"`
+	Site      Site      `json:"-"`
+	Kind      string    `gorm:"size:20;not null" json:"kind"`
+	Value     float64   `gorm:"not null;default:0" json:"value"`
+	Baseline  *float64  `json:"baseline"`
+	Message   string    `gorm:"type:text;not null" json:"message"`
+	CreatedAt time.Time `gorm:"not null;default:clock_timestamp();index" json:"created_at"`
+}
+
+func (ae AlertEvent) GetID() uuid.UUID {
+	return ae.ID
+}
+
+func (ae AlertEvent) GetCreatedAt() time.Time {
+	return ae.CreatedAt
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Role names the token is limited to, all the roles of the user when empty
+type APITokenRoles []string
+
+func (r *APITokenRoles) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	case nil:
+		*r = APITokenRoles{}
+		return nil
+	default:
+		return errors.New("Invalid API token roles value.")
+	}
+}
+
+func (r APITokenRoles) Value() (driver.Value, error) {
+	if r == nil {
+		r = APITokenRoles{}
+	}
+
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(raw), nil
+}
+
+type APIToken struct {
+	ID         uuid.UUID      `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	UserID     uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	User       User           `json:"-"`
+	Name       string         `gorm:"size:100;not null" json:"name"`
+	Prefix     string         `gorm:"size:20;not null" json:"prefix"`
+	TokenHash  string         `gorm:"size:64;not null;unique" json:"-"`
+	Roles      APITokenRoles  `gorm:"type:jsonb;not null;default:'[]'" json:"roles"`
+	ExpiresAt  time.Time      `gorm:"not null" json:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at"`
+	LastUsedIP *string        `gorm:"size:45" json:"last_used_ip"`
+	RevokedAt  *time.Time     `json:"revoked_at"`
+	CreatedAt  time.Time      `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+	UpdatedAt  time.Time      `gorm:"not null;default:clock_timestamp()" json:"-"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (h APIToken) GetID() uuid.UUID {
+	return h.ID
+}
+
+func (h APIToken) GetCreatedAt() time.Time {
+	return h.CreatedAt
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Append-only, rows cannot be updated nor deleted
+type AuditEvent struct {
+	ID         uuid.UUID       `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	Sequence   int64           `gorm:"not null;uniqueIndex" json:"sequence"`
+	ActorID    *uuid.UUID      `gorm:"type:uuid;index" json:"actor_id"`
+	Actor      *User           `gorm:"foreignKey:ActorID" json:"actor,omitempty"`
+	Action     string          `gorm:"size:100;not null;index" json:"action"`
+	TargetType string          `gorm:"size:50;not null;default:'';index:idx_audit_events_target" json:"target_type"`
+	TargetID   string          `gorm:"size:255;not null;default:'';index:idx_audit_events_target" json:"target_id"`
+	IP         string          `gorm:"size:45;not null;default:''" json:"ip"`
+	UserAgent  string          `gorm:"size:500;not null;default:''" json:"user_agent"`
+	RequestID  string          `gorm:"size:100;not null;default:''" json:"request_id"`
+	Before     json.RawMessage `gorm:"type:jsonb" json:"before"`
+	After      json.RawMessage `gorm:"type:jsonb" json:"after"`
+	PrevHash   string          `gorm:"size:64;not null" json:"prev_hash"`
+	Hash       string          `gorm:"size:64;not null;unique" json:"hash"`
+	CreatedAt  time.Time       `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+}
+
+func (h AuditEvent) GetID() uuid.UUID {
+	return h.ID
+}
+
+func (h AuditEvent) GetCreatedAt() time.Time {
+	return h.CreatedAt
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CasbinRule struct {
+	ID        uuid.UUID      `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	Ptype     string         `gorm:"size:10;not null;uniqueIndex:idx_casbin_rules_rule" json:"ptype"`
+	V0        string         `gorm:"size:255;not null;default:'';uniqueIndex:idx_casbin_rules_rule" json:"v0"`
+	V1        string         `gorm:"size:255;not null;default:'';uniqueIndex:idx_casbin_rules_rule" json:"v1"`
+	V2        string         `gorm:"size:255;not null;default:'';uniqueIndex:idx_casbin_rules_rule" json:"v2"`
+	V3        string         `gorm:"size:255;not null;default:'';uniqueIndex:idx_casbin_rules_rule" json:"v3"`
+	V4        string         `gorm:"size:255;not null;default:'';uniqueIndex:idx_casbin_rules_rule" json:"v4"`
+	V5        string         `gorm:"size:255;not null;default:'';uniqueIndex:idx_casbin_rules_rule" json:"v5"`
+	CreatedAt time.Time      `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+	UpdatedAt time.Time      `gorm:"not null;default:clock_timestamp()" json:"-"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func NewCasbinRule(ptype string, rule []string) CasbinRule {
+	r := CasbinRule{Ptype: ptype}
+	values := []*string{&r.V0, &r.V1, &r.V2, &r.V3, &r.V4, &r.V5}
+
+	for i, v := range rule {
+		if i >= len(values) {
+			break
+		}
+
+		*values[i] = v
+	}
+
+	return r
+}
+
+// Rule values without the trailing empty ones
+func (h CasbinRule) Rule() []string {
+	rule := []string{h.V0, h.V1, h.V2, h.V3, h.V4, h.V5}
+
+	for len(rule) > 0 && len(rule[len(rule)-1]) < 1 {
+		rule = rule[:len(rule)-1]
+	}
+
+	return rule
+}
+
+// Conditions matching the rule exactly, empty values included
+func (h CasbinRule) Fields() map[string]any {
+	return map[string]any{
+		"ptype": h.Ptype,
+		"v0":    h.V0,
+		"v1":    h.V1,
+		"v2":    h.V2,
+		"v3":    h.V3,
+		"v4":    h.V4,
+		"v5":    h.V5,
+	}
+}
+
+func (h CasbinRule) GetID() uuid.UUID {
+	return h.ID
+}
+
+func (h CasbinRule) GetCreatedAt() time.Time {
+	return h.CreatedAt
+}
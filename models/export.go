@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Export struct {
+	ID          uuid.UUID       `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	UserID      uuid.UUID       `gorm:"not null;index" json:"user_id"`
+	User        User            `json:"-"`
+	Format      string          `gorm:"size:10;not null" json:"format"`
+	Filters     json.RawMessage `gorm:"type:jsonb;not null;default:'{}'" json:"filters"`
+	Status      string          `gorm:"size:20;not null;default:pending;index" json:"status"`
+	Storage     string          `gorm:"size:20;not null" json:"storage"`
+	Path        *string         `gorm:"type:text" json:"-"`
+	Rows        int64           `gorm:"not null;default:0;check:rows >= 0" json:"rows"`
+	Size        int64           `gorm:"not null;default:0;check:size >= 0" json:"size"`
+	Error       *string         `gorm:"type:text" json:"error"`
+	StartedAt   *time.Time      `json:"started_at"`
+	CompletedAt *time.Time      `json:"completed_at"`
+	ExpiresAt   *time.Time      `gorm:"index" json:"expires_at"`
+	CreatedAt   time.Time       `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+	UpdatedAt   time.Time       `gorm:"not null;default:clock_timestamp()" json:"-"`
+}
+
+func (e Export) GetID() uuid.UUID {
+	return e.ID
+}
+
+func (e Export) GetCreatedAt() time.Time {
+	return e.CreatedAt
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Overrides the default frequency of an event type for a channel
+type NotificationPreference struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"-"`
+	UserID    uuid.UUID `gorm:"not null;uniqueIndex:idx_notification_preferences_key,priority:1" json:"-"`
+	User      User      `json:"-"`
+	EventType string    `gorm:"size:50;not null;uniqueIndex:idx_notification_preferences_key,priority:2" json:"event"`
+	Channel   string    `gorm:"size:20;not null;uniqueIndex:idx_notification_preferences_key,priority:3" json:"channel"`
+	Frequency string    `gorm:"size:20;not null" json:"frequency"`
+	CreatedAt time.Time `gorm:"not null;default:clock_timestamp()" json:"-"`
+	UpdatedAt time.Time `gorm:"not null;default:clock_timestamp()" json:"updated_at"`
+}
+
+type SiteSubscription struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID `gorm:"not null;uniqueIndex:idx_site_subscriptions_key,priority:1" json:"-"`
+	User      User      `json:"-"`
+	SiteID    uuid.UUID `gorm:"not null;uniqueIndex:idx_site_subscriptions_key,priority:2;index" json:"site_id"`
+	Site      Site      `json:"site"`
+	CreatedAt time.Time `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+}
+
+func (ss SiteSubscription) GetID() uuid.UUID {
+	return ss.ID
+}
+
+func (ss SiteSubscription) GetCreatedAt() time.Time {
+	return ss.CreatedAt
+}
+
+// In-app notification
+type Notification struct {
+	ID        uuid.UUID  `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID  `gorm:"not null;index:idx_notifications_user_created_at,priority:1" json:"-"`
+	User      User       `json:"-"`
+	SiteID    *uuid.UUID `gorm:"type:uuid" json:"site_id"`
+	EventType string     `gorm:"size:50;not null" json:"event"`
+	Title     string     `gorm:"size:255;not null" json:"title"`
+	Body      string     `gorm:"type:text;not null" json:"body"`
+	URL       *string    `gorm:"size:2048" json:"url"`
+	ReadAt    *time.Time `json:"read_at"`
+	CreatedAt time.Time  `gorm:"not null;default:clock_timestamp();index:idx_notifications_user_created_at,priority:2" json:"created_at"`
+}
+
+func (n Notification) GetID() uuid.UUID {
+	return n.ID
+}
+
+func (n Notification) GetCreatedAt() time.Time {
+	return n.CreatedAt
+}
+
+// Notification waiting to be sent in the next digest
+type NotificationDigestItem struct {
+	ID        uuid.UUID  `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID  `gorm:"not null;index:idx_notification_digest_items_pending,priority:1" json:"user_id"`
+	User      User       `json:"-"`
+	Channel   string     `gorm:"size:20;not null;index:idx_notification_digest_items_pending,priority:2" json:"channel"`
+	SiteID    *uuid.UUID `gorm:"type:uuid" json:"site_id"`
+	EventType string     `gorm:"size:50;not null" json:"event"`
+	Title     string     `gorm:"size:255;not null" json:"title"`
+	Body      string     `gorm:"type:text;not null" json:"body"`
+	URL       *string    `gorm:"size:2048" json:"url"`
+	SentAt    *time.Time `gorm:"index:idx_notification_digest_items_pending,priority:3" json:"sent_at"`
+	CreatedAt time.Time  `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+}
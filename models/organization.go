@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Organization struct {
+	ID        uuid.UUID      `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	Name      string         `gorm:"size:255;not null;check:name <> ''" json:"name"`
+	Slug      string         `gorm:"size:100;not null;unique;check:slug <> ''" json:"slug"`
+	CreatedAt time.Time      `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+	UpdatedAt time.Time      `gorm:"not null;default:clock_timestamp()" json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (o Organization) GetID() uuid.UUID {
+	return o.ID
+}
+
+func (o Organization) GetCreatedAt() time.Time {
+	return o.CreatedAt
+}
+
+type OrganizationMember struct {
+	ID             uuid.UUID    `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	OrganizationID uuid.UUID    `gorm:"not null;uniqueIndex:idx_organization_members_key,priority:1" json:"organization_id"`
+	Organization   Organization `json:"-"`
+	UserID         uuid.UUID    `gorm:"not null;uniqueIndex:idx_organization_members_key,priority:2;index" json:"user_id"`
+	User           User         `json:"user"`
+	Role           string       `gorm:"size:20;not null" json:"role"`
+	CreatedAt      time.Time    `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+	UpdatedAt      time.Time    `gorm:"not null;default:clock_timestamp()" json:"updated_at"`
+}
+
+func (om OrganizationMember) GetID() uuid.UUID {
+	return om.ID
+}
+
+func (om OrganizationMember) GetCreatedAt() time.Time {
+	return om.CreatedAt
+}
+
+type OrganizationInvitation struct {
+	ID             uuid.UUID    `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	OrganizationID uuid.UUID    `gorm:"not null;index" json:"organization_id"`
+	Organization   Organization `json:"organization"`
+	Email          string       `gorm:"size:100;not null" json:"email"`
+	Role           string       `gorm:"size:20;not null" json:"role"`
+	TokenHash      string       `gorm:"size:64;not null;unique" json:"-"`
+	InvitedByID    uuid.UUID    `gorm:"type:uuid;not null" json:"-"`
+	InvitedBy      User         `gorm:"foreignKey:InvitedByID" json:"-"`
+	AcceptedAt     *time.Time   `json:"accepted_at"`
+	ExpiresAt      time.Time    `gorm:"not null" json:"expires_at"`
+	CreatedAt      time.Time    `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+}
+
+func (oi OrganizationInvitation) GetID() uuid.UUID {
+	return oi.ID
+}
+
+func (oi OrganizationInvitation) GetCreatedAt() time.Time {
+	return oi.CreatedAt
+}
@@ -3,29 +3,43 @@ package models
 import (
 	"time"
 
+	"alfredoramos.mx/csp-reporter/utils"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type Report struct {
-	ID                 uuid.UUID      `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
-	SiteID             uuid.UUID      `gorm:"not null" json:"site_id"`
-	Site               Site           `json:"site"`
-	BlockedURI         string         `gorm:"type:text;not null" json:"blocked_uri"`
-	Disposition        string         `gorm:"size:100;not null" json:"disposition"`
-	DocumentURI        string         `gorm:"type:text;not null" json:"document_uri"`
-	EffectiveDirective string         `gorm:"size:100;not null" json:"effective_directive"`
-	OriginalPolicy     string         `gorm:"type:text;not null" json:"original_policy"`
-	Referrer           *string        `gorm:"type:text" json:"referrer"`
-	StatusCode         int            `gorm:"not null;check:status_code >= 0" json:"status_code"`
-	ViolatedDirective  string         `gorm:"size:100;not null" json:"violated_directive"`
-	ScriptSample       *string        `gorm:"size:50" json:"script_sample"`
-	SourceFile         *string        `gorm:"type:text" json:"source_file"`
-	LineNumber         *int64         `gorm:"check:line_number >= 0" json:"line_number"`
-	ColumnNumber       *int64         `gorm:"check:column_number >= 0" json:"column_number"`
-	CreatedAt          time.Time      `gorm:"not null;default:clock_timestamp()" json:"created_at"`
-	UpdatedAt          time.Time      `gorm:"not null;default:clock_timestamp()" json:"-"`
-	DeletedAt          gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+	ID                 uuid.UUID         `gorm:"primaryKey;type:uuid;not null;default:gen_random_uuid()" json:"id"`
+	SiteID             uuid.UUID         `gorm:"not null;index:idx_reports_site_created_at,priority:1" json:"site_id"`
+	Site               Site              `json:"site"`
+	GroupID            *uuid.UUID        `gorm:"type:uuid;index" json:"group_id"`
+	Group              *ViolationGroup   `gorm:"foreignKey:GroupID" json:"-"`
+	BlockedURI         string            `gorm:"type:text;not null" json:"blocked_uri"`
+	BlockedHost        *string           `gorm:"size:255" json:"blocked_host"`
+	Disposition        string            `gorm:"size:100;not null" json:"disposition"`
+	DocumentURI        string            `gorm:"type:text;not null" json:"document_uri"`
+	DocumentPath       *string           `gorm:"type:text" json:"document_path"`
+	EffectiveDirective string            `gorm:"size:100;not null" json:"effective_directive"`
+	OriginalPolicy     string            `gorm:"type:text;not null" json:"original_policy"`
+	Referrer           *string           `gorm:"type:text" json:"referrer"`
+	StatusCode         int               `gorm:"not null;check:status_code >= 0" json:"status_code"`
+	ViolatedDirective  string            `gorm:"size:100;not null" json:"violated_directive"`
+	ScriptSample       *string           `gorm:"size:50" json:"script_sample"`
+	SourceFile         *string           `gorm:"type:text" json:"source_file"`
+	LineNumber         *int64            `gorm:"check:line_number >= 0" json:"line_number"`
+	ColumnNumber       *int64            `gorm:"check:column_number >= 0" json:"column_number"`
+	UserAgent          *string           `gorm:"type:text" json:"user_agent"`
+	Browser            *string           `gorm:"size:50" json:"browser"`
+	CreatedAt          time.Time         `gorm:"primaryKey;not null;default:clock_timestamp();index:idx_reports_site_created_at,priority:2" json:"created_at"`
+	UpdatedAt          time.Time         `gorm:"not null;default:clock_timestamp()" json:"-"`
+	DeletedAt          gorm.DeletedAt    `gorm:"index" json:"deleted_at"`
+	DeletedByID        *uuid.UUID        `gorm:"type:uuid" json:"-"`
+	DeletedBy          *User             `gorm:"foreignKey:DeletedByID" json:"-"`
+	RestoredByID       *uuid.UUID        `gorm:"type:uuid" json:"-"`
+	RestoredBy         *User             `gorm:"foreignKey:RestoredByID" json:"-"`
+	Quarantined        bool              `gorm:"not null;default:false;index" json:"quarantined"`
+	Rank               *float64          `gorm:"->;-:migration" json:"rank,omitempty"`
+	Highlights         map[string]string `gorm:"-" json:"highlights,omitempty"`
 }
 
 func (r Report) GetID() uuid.UUID {
@@ -35,3 +49,34 @@ func (r Report) GetID() uuid.UUID {
 func (r Report) GetCreatedAt() time.Time {
 	return r.CreatedAt
 }
+
+func (r Report) GetRank() float64 {
+	if r.Rank == nil {
+		return 0
+	}
+
+	return *r.Rank
+}
+
+func (r *Report) Highlight(q string) {
+	terms := utils.SearchTerms(q)
+	fields := map[string]*string{
+		"blocked_uri":     &r.BlockedURI,
+		"document_uri":    &r.DocumentURI,
+		"source_file":     r.SourceFile,
+		"script_sample":   r.ScriptSample,
+		"original_policy": &r.OriginalPolicy,
+	}
+
+	r.Highlights = map[string]string{}
+
+	for k, v := range fields {
+		if v == nil {
+			continue
+		}
+
+		if h, ok := utils.HighlightMatches(*v, terms); ok {
+			r.Highlights[k] = h
+		}
+	}
+}
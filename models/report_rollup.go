@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ReportRollup struct {
+	Bucket             time.Time `gorm:"primaryKey;not null" json:"bucket"`
+	SiteID             uuid.UUID `gorm:"primaryKey;type:uuid;not null" json:"site_id"`
+	Site               Site      `json:"-"`
+	EffectiveDirective string    `gorm:"primaryKey;size:100;not null" json:"effective_directive"`
+	BlockedHost        string    `gorm:"primaryKey;size:255;not null;default:''" json:"blocked_host"`
+	Disposition        string    `gorm:"primaryKey;size:100;not null" json:"disposition"`
+	Count              int64     `gorm:"not null;default:0;check:count >= 0" json:"count"`
+	DistinctDocuments  int64     `gorm:"not null;default:0;check:distinct_documents >= 0" json:"distinct_documents"`
+	UpdatedAt          time.Time `gorm:"not null;default:clock_timestamp()" json:"-"`
+}
+
+type HourlyReportRollup struct {
+	ReportRollup `gorm:"embedded"`
+}
+
+type DailyReportRollup struct {
+	ReportRollup `gorm:"embedded"`
+}
@@ -1,17 +1,87 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// Per-site settings, stored as a JSON object
+type SiteSettings struct {
+	// Reports of these directives or blocked hosts are discarded
+	IgnoredDirectives   []string `json:"ignored_directives"`
+	IgnoredBlockedHosts []string `json:"ignored_blocked_hosts"`
+}
+
+func (ss *SiteSettings) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, ss)
+	case string:
+		return json.Unmarshal([]byte(v), ss)
+	case nil:
+		*ss = SiteSettings{}
+		return nil
+	default:
+		return errors.New("Invalid site settings value.")
+	}
+}
+
+func (ss SiteSettings) Value() (driver.Value, error) {
+	if ss.IgnoredDirectives == nil {
+		ss.IgnoredDirectives = []string{}
+	}
+
+	if ss.IgnoredBlockedHosts == nil {
+		ss.IgnoredBlockedHosts = []string{}
+	}
+
+	raw, err := json.Marshal(ss)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(raw), nil
+}
+
 type Site struct {
-	ID        uuid.UUID      `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
-	Title     *string        `gorm:"size:255" json:"title"`
-	Domain    string         `gorm:"not null;size:255;unique;check:domain <> ''" json:"domain"`
-	CreatedAt time.Time      `gorm:"not null;default:clock_timestamp()" json:"-"`
-	UpdatedAt time.Time      `gorm:"not null;default:clock_timestamp()" json:"-"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+	ID                   uuid.UUID      `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	OrganizationID       *uuid.UUID     `gorm:"type:uuid;index" json:"organization_id"`
+	Organization         *Organization  `json:"-"`
+	Title                *string        `gorm:"size:255" json:"title"`
+	Domain               string         `gorm:"not null;size:255;unique;check:domain <> ''" json:"domain"`
+	Domains              []SiteDomain   `json:"domains,omitempty"`
+	Settings             SiteSettings   `gorm:"type:jsonb;not null;default:'{}'" json:"settings"`
+	VerificationToken    string         `gorm:"size:64;not null;default:''" json:"verification_token"`
+	VerificationMethod   *string        `gorm:"size:10" json:"verification_method"`
+	VerifiedAt           *time.Time     `json:"verified_at"`
+	LastVerificationAt   *time.Time     `json:"last_verification_at"`
+	VerificationError    *string        `gorm:"type:text" json:"verification_error"`
+	VerificationFailures int            `gorm:"not null;default:0" json:"verification_failures"`
+	CreatedAt            time.Time      `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+	UpdatedAt            time.Time      `gorm:"not null;default:clock_timestamp()" json:"updated_at"`
+	DeletedAt            gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+	ReportCount          *int64         `gorm:"->;-:migration" json:"report_count,omitempty"`
+	LastReportAt         *time.Time     `gorm:"->;-:migration" json:"last_report_at,omitempty"`
+}
+
+func (s Site) GetID() uuid.UUID {
+	return s.ID
+}
+
+func (s Site) GetCreatedAt() time.Time {
+	return s.CreatedAt
+}
+
+// Additional domain whose reports belong to the site
+type SiteDomain struct {
+	ID        uuid.UUID `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"-"`
+	SiteID    uuid.UUID `gorm:"not null;index" json:"-"`
+	Site      Site      `json:"-"`
+	Domain    string    `gorm:"not null;size:255;unique;check:domain <> ''" json:"domain"`
+	CreatedAt time.Time `gorm:"not null;default:clock_timestamp()" json:"-"`
 }
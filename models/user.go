@@ -19,7 +19,8 @@ type User struct {
 	Active             *bool          `gorm:"not null;default:false" json:"active"`
 	LastLogin          *time.Time     `json:"-"`
 	LastPasswordChange *time.Time     `json:"-"`
-	MustChangePassword *bool          `gorm:"default:false" json:"-"`
+	MustChangePassword *bool          `gorm:"default:false" json:"must_change_password"`
+	Roles              []UserRole     `json:"roles,omitempty"`
 	CreatedAt          time.Time      `gorm:"not null;default:clock_timestamp()" json:"-"`
 	UpdatedAt          time.Time      `gorm:"not null;default:clock_timestamp()" json:"-"`
 	DeletedAt          gorm.DeletedAt `gorm:"index" json:"deleted_at"`
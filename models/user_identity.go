@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Account of the user at an external identity provider
+type UserIdentity struct {
+	ID          uuid.UUID      `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	UserID      uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	User        User           `json:"-"`
+	Issuer      string         `gorm:"size:255;not null;uniqueIndex:idx_user_identities_subject,priority:1" json:"issuer"`
+	Subject     string         `gorm:"size:255;not null;uniqueIndex:idx_user_identities_subject,priority:2" json:"subject"`
+	Email       string         `gorm:"size:100;not null" json:"email"`
+	LastLoginAt *time.Time     `json:"last_login_at"`
+	CreatedAt   time.Time      `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+	UpdatedAt   time.Time      `gorm:"not null;default:clock_timestamp()" json:"-"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+}
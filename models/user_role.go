@@ -10,9 +10,11 @@ import (
 type UserRole struct {
 	ID          uuid.UUID      `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
 	UserID      uuid.UUID      `gorm:"not null" json:"user_id"`
-	User        User           `json:"user"`
+	User        User           `json:"-"`
 	RoleID      uuid.UUID      `gorm:"not null" json:"role_id"`
 	Role        Role           `json:"role"`
+	SiteID      *uuid.UUID     `gorm:"type:uuid;index" json:"site_id"`
+	Site        *Site          `json:"site,omitempty"`
 	CreatedAt   time.Time      `gorm:"not null;default:clock_timestamp()" json:"-"`
 	UpdatedAt   time.Time      `gorm:"not null;default:clock_timestamp()" json:"-"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
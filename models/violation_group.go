@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Reports of the same site, directive and blocked host
+type ViolationGroup struct {
+	ID                 uuid.UUID  `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	SiteID             uuid.UUID  `gorm:"not null;uniqueIndex:idx_violation_groups_key,priority:1" json:"site_id"`
+	Site               Site       `json:"site"`
+	EffectiveDirective string     `gorm:"size:100;not null;uniqueIndex:idx_violation_groups_key,priority:2" json:"effective_directive"`
+	BlockedHost        string     `gorm:"size:255;not null;default:'';uniqueIndex:idx_violation_groups_key,priority:3" json:"blocked_host"`
+	Status             string     `gorm:"size:20;not null;default:new;index" json:"status"`
+	AssigneeID         *uuid.UUID `gorm:"type:uuid;index" json:"assignee_id"`
+	Assignee           *User      `gorm:"foreignKey:AssigneeID" json:"assignee,omitempty"`
+	Count              int64      `gorm:"not null;default:0;check:count >= 0" json:"count"`
+	FirstSeenAt        time.Time  `gorm:"not null;default:clock_timestamp()" json:"first_seen_at"`
+	LastSeenAt         time.Time  `gorm:"not null;default:clock_timestamp();index" json:"last_seen_at"`
+	ResolvedAt         *time.Time `json:"resolved_at"`
+	ResolvedByID       *uuid.UUID `gorm:"type:uuid" json:"resolved_by_id"`
+	ResolvedBy         *User      `gorm:"foreignKey:ResolvedByID" json:"-"`
+	ReopenedAt         *time.Time `json:"reopened_at"`
+	CreatedAt          time.Time  `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+	UpdatedAt          time.Time  `gorm:"not null;default:clock_timestamp()" json:"updated_at"`
+}
+
+func (vg ViolationGroup) GetID() uuid.UUID {
+	return vg.ID
+}
+
+func (vg ViolationGroup) GetCreatedAt() time.Time {
+	return vg.CreatedAt
+}
+
+type ViolationGroupComment struct {
+	ID        uuid.UUID      `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	GroupID   uuid.UUID      `gorm:"not null;index" json:"group_id"`
+	Group     ViolationGroup `json:"-"`
+	AuthorID  uuid.UUID      `gorm:"not null" json:"author_id"`
+	Author    User           `json:"author"`
+	Body      string         `gorm:"type:text;not null;check:body <> ''" json:"body"`
+	CreatedAt time.Time      `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+	UpdatedAt time.Time      `gorm:"not null;default:clock_timestamp()" json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (vgc ViolationGroupComment) GetID() uuid.UUID {
+	return vgc.ID
+}
+
+func (vgc ViolationGroupComment) GetCreatedAt() time.Time {
+	return vgc.CreatedAt
+}
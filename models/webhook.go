@@ -0,0 +1,94 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// List of event types, stored as a JSON array
+type WebhookEvents []string
+
+func (we *WebhookEvents) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, we)
+	case string:
+		return json.Unmarshal([]byte(v), we)
+	case nil:
+		*we = WebhookEvents{}
+		return nil
+	default:
+		return errors.New("Invalid webhook events value.")
+	}
+}
+
+func (we WebhookEvents) Value() (driver.Value, error) {
+	if we == nil {
+		we = WebhookEvents{}
+	}
+
+	raw, err := json.Marshal(we)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(raw), nil
+}
+
+type Webhook struct {
+	ID             uuid.UUID      `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	UserID         *uuid.UUID     `gorm:"type:uuid;index" json:"user_id"`
+	User           *User          `gorm:"foreignKey:UserID" json:"-"`
+	Name           string         `gorm:"size:255;not null;check:name <> ''" json:"name"`
+	URL            string         `gorm:"size:2048;not null;check:url <> ''" json:"url"`
+	Kind           string         `gorm:"size:20;not null;default:json" json:"kind"`
+	Secret         string         `gorm:"size:255;not null" json:"-"`
+	Events         WebhookEvents  `gorm:"type:jsonb;not null;default:'[]'" json:"events"`
+	Enabled        *bool          `gorm:"not null;default:true" json:"enabled"`
+	FailureCount   int            `gorm:"not null;default:0" json:"failure_count"`
+	DisabledAt     *time.Time     `json:"disabled_at"`
+	LastDeliveryAt *time.Time     `json:"last_delivery_at"`
+	CreatedByID    uuid.UUID      `gorm:"type:uuid;not null" json:"-"`
+	CreatedBy      User           `gorm:"foreignKey:CreatedByID" json:"-"`
+	CreatedAt      time.Time      `gorm:"not null;default:clock_timestamp()" json:"created_at"`
+	UpdatedAt      time.Time      `gorm:"not null;default:clock_timestamp()" json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (w Webhook) GetID() uuid.UUID {
+	return w.ID
+}
+
+func (w Webhook) GetCreatedAt() time.Time {
+	return w.CreatedAt
+}
+
+// Each delivery attempt of an event
+type WebhookDelivery struct {
+	ID           uuid.UUID       `gorm:"primaryKey;type:uuid;not null;unique;default:gen_random_uuid()" json:"id"`
+	WebhookID    uuid.UUID       `gorm:"not null;index" json:"webhook_id"`
+	Webhook      Webhook         `json:"-"`
+	EventID      uuid.UUID       `gorm:"type:uuid;not null;index" json:"event_id"`
+	Event        string          `gorm:"size:100;not null" json:"event"`
+	Payload      json.RawMessage `gorm:"type:jsonb;not null" json:"payload"`
+	Attempt      int             `gorm:"not null;default:1" json:"attempt"`
+	Success      bool            `gorm:"not null;default:false" json:"success"`
+	StatusCode   *int            `json:"status_code"`
+	ResponseBody *string         `gorm:"type:text" json:"response_body"`
+	Error        *string         `gorm:"type:text" json:"error"`
+	Duration     int64           `gorm:"not null;default:0" json:"duration_ms"`
+	CreatedAt    time.Time       `gorm:"not null;default:clock_timestamp();index" json:"created_at"`
+}
+
+func (wd WebhookDelivery) GetID() uuid.UUID {
+	return wd.ID
+}
+
+func (wd WebhookDelivery) GetCreatedAt() time.Time {
+	return wd.CreatedAt
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"alfredoramos.mx/csp-reporter/controllers"
+	"alfredoramos.mx/csp-reporter/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterAPITokenRoutes(g fiber.Router) {
+	// Private
+	g.Use(middlewares.AuthProtected(), middlewares.ValidateAccessToken(), middlewares.CheckPermissions())
+	g.Get("/all", controllers.GetAllAPITokens).Name("api.tokens.index")
+	g.Post("/", controllers.PostAPIToken).Name("api.tokens.add")
+	g.Delete("/:id<guid>", controllers.DeleteAPIToken).Name("api.tokens.delete")
+}
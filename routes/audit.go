@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"alfredoramos.mx/csp-reporter/controllers"
+	"alfredoramos.mx/csp-reporter/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterAuditRoutes(g fiber.Router) {
+	// Private
+	g.Use(middlewares.AuthProtected(), middlewares.ValidateAccessToken(), middlewares.CheckPermissions())
+	g.Get("/events/all", controllers.GetAllAuditEvents).Name("api.audit.events.index")
+	g.Get("/events/:id<guid>", controllers.GetAuditEvent).Name("api.audit.events.show")
+	g.Get("/verify", controllers.VerifyAuditEvents).Name("api.audit.verify")
+}
@@ -11,15 +11,17 @@ func RegisterAuthRoutes(g fiber.Router) {
 	g.Use(middlewares.AuthLimiter())
 
 	// Public
-	g.Post("/login", middlewares.CaptchaProtected(), controllers.AuthLogin)
-	g.Post("/register", middlewares.CaptchaProtected(), controllers.AuthRegister)
-	g.Post("/recover", middlewares.CaptchaProtected(), controllers.AuthRecover)
-	g.Post("/recover/validate", controllers.AuthRecoverValidate) // Without captcha protection
-	g.Patch("/recover/update", middlewares.CaptchaProtected(), controllers.AuthRecoverUpdate)
+	g.Post("/login", middlewares.CaptchaProtected(), controllers.AuthLogin).Name("api.auth.login")
+	g.Post("/register", middlewares.CaptchaProtected(), controllers.AuthRegister).Name("api.auth.register")
+	g.Post("/recover", middlewares.CaptchaProtected(), controllers.AuthRecover).Name("api.auth.recover")
+	g.Post("/recover/validate", controllers.AuthRecoverValidate).Name("api.auth.recover.validate") // Without captcha protection
+	g.Patch("/recover/update", middlewares.CaptchaProtected(), controllers.AuthRecoverUpdate).Name("api.auth.recover.update")
+	g.Get("/oidc/login", controllers.AuthOIDCLogin).Name("api.auth.oidc.login")
+	g.Post("/oidc/callback", controllers.AuthOIDCCallback).Name("api.auth.oidc.callback")
 
 	// Private
 	g.Use(middlewares.AuthProtected(), middlewares.ValidateAccessToken(), middlewares.CheckPermissions())
-	g.Post("/check", controllers.AuthCheck)
-	g.Post("/logout", controllers.AuthLogout)
-	g.Patch("/refresh", middlewares.ValidateRefreshToken(), controllers.AuthRefresh)
+	g.Post("/check", controllers.AuthCheck).Name("api.auth.check")
+	g.Post("/logout", controllers.AuthLogout).Name("api.auth.logout")
+	g.Patch("/refresh", middlewares.ValidateRefreshToken(), controllers.AuthRefresh).Name("api.auth.refresh")
 }
@@ -9,8 +9,29 @@ import (
 func RegisterCSPReportRoutes(g fiber.Router) {
 	// Public
 	g.Post("/reports/add", controllers.PostCSPReport).Name("api.csp.reports.add")
+	g.Get("/exports/:id<guid>/download", controllers.DownloadCSPExport).Name("api.csp.exports.download")
 
 	// Private
 	g.Use(middlewares.AuthProtected(), middlewares.ValidateAccessToken(), middlewares.CheckPermissions())
 	g.Get("/reports/all", controllers.GetAllCSPReports).Name("api.csp.reports.index")
+	g.Get("/reports/stats", controllers.GetCSPReportStats).Name("api.csp.reports.stats")
+	g.Post("/reports/export", controllers.PostCSPReportExport).Name("api.csp.reports.export")
+	g.Post("/reports/bulk", controllers.BulkCSPReports).Name("api.csp.reports.bulk")
+	g.Get("/reports/:id<guid>", controllers.GetCSPReport).Name("api.csp.reports.show")
+	g.Delete("/reports/:id<guid>", controllers.DeleteCSPReport).Name("api.csp.reports.delete")
+	g.Patch("/reports/:id<guid>/restore", controllers.RestoreCSPReport).Name("api.csp.reports.restore")
+	g.Delete("/reports/:id<guid>/purge", controllers.PurgeCSPReport).Name("api.csp.reports.purge")
+	g.Get("/exports/all", controllers.GetAllCSPExports).Name("api.csp.exports.index")
+	g.Get("/exports/:id<guid>", controllers.GetCSPExport).Name("api.csp.exports.show")
+	g.Get("/groups/all", controllers.GetAllViolationGroups).Name("api.csp.groups.index")
+	g.Get("/groups/:id<guid>", controllers.GetViolationGroup).Name("api.csp.groups.show")
+	g.Patch("/groups/:id<guid>", controllers.PatchViolationGroup).Name("api.csp.groups.update")
+	g.Get("/groups/:id<guid>/comments", controllers.GetAllViolationGroupComments).Name("api.csp.groups.comments.index")
+	g.Post("/groups/:id<guid>/comments", controllers.PostViolationGroupComment).Name("api.csp.groups.comments.add")
+	g.Get("/alerts/rules/all", controllers.GetAllAlertRules).Name("api.csp.alerts.rules.index")
+	g.Post("/alerts/rules", controllers.PostAlertRule).Name("api.csp.alerts.rules.add")
+	g.Get("/alerts/rules/:id<guid>", controllers.GetAlertRule).Name("api.csp.alerts.rules.show")
+	g.Patch("/alerts/rules/:id<guid>", controllers.PatchAlertRule).Name("api.csp.alerts.rules.update")
+	g.Delete("/alerts/rules/:id<guid>", controllers.DeleteAlertRule).Name("api.csp.alerts.rules.delete")
+	g.Get("/alerts/events/all", controllers.GetAllAlertEvents).Name("api.csp.alerts.events.index")
 }
@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"os"
+	"testing"
+
+	"alfredoramos.mx/csp-reporter/testutil"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(testutil.Main(m))
+}
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"alfredoramos.mx/csp-reporter/controllers"
+	"alfredoramos.mx/csp-reporter/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterNotificationRoutes(g fiber.Router) {
+	// Private
+	g.Use(middlewares.AuthProtected(), middlewares.ValidateAccessToken(), middlewares.CheckPermissions())
+	g.Get("/all", controllers.GetAllNotifications).Name("api.notifications.index")
+	g.Post("/read", controllers.ReadAllNotifications).Name("api.notifications.read_all")
+	g.Patch("/:id<guid>/read", controllers.ReadNotification).Name("api.notifications.read")
+	g.Get("/preferences", controllers.GetNotificationPreferences).Name("api.notifications.preferences.show")
+	g.Put("/preferences", controllers.PutNotificationPreferences).Name("api.notifications.preferences.update")
+	g.Get("/subscriptions/all", controllers.GetAllSiteSubscriptions).Name("api.notifications.subscriptions.index")
+	g.Put("/subscriptions/:id<guid>", controllers.PutSiteSubscription).Name("api.notifications.subscriptions.add")
+	g.Delete("/subscriptions/:id<guid>", controllers.DeleteSiteSubscription).Name("api.notifications.subscriptions.delete")
+	g.Get("/webhooks/all", controllers.GetAllPersonalWebhooks).Name("api.notifications.webhooks.index")
+	g.Post("/webhooks", controllers.PostPersonalWebhook).Name("api.notifications.webhooks.add")
+	g.Delete("/webhooks/:id<guid>", controllers.DeletePersonalWebhook).Name("api.notifications.webhooks.delete")
+}
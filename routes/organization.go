@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"alfredoramos.mx/csp-reporter/controllers"
+	"alfredoramos.mx/csp-reporter/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterOrganizationRoutes(g fiber.Router) {
+	// Private
+	g.Use(middlewares.AuthProtected(), middlewares.ValidateAccessToken(), middlewares.CheckPermissions())
+	g.Get("/all", controllers.GetAllOrganizations).Name("api.organizations.index")
+	g.Post("/", controllers.PostOrganization).Name("api.organizations.add")
+	g.Post("/invitations/accept", controllers.AcceptOrganizationInvitation).Name("api.organizations.invitations.accept")
+	g.Get("/:id<guid>", controllers.GetOrganization).Name("api.organizations.show")
+	g.Patch("/:id<guid>", controllers.PatchOrganization).Name("api.organizations.update")
+	g.Delete("/:id<guid>", controllers.DeleteOrganization).Name("api.organizations.delete")
+	g.Get("/:id<guid>/members", controllers.GetAllOrganizationMembers).Name("api.organizations.members.index")
+	g.Patch("/:id<guid>/members/:user_id<guid>", controllers.PatchOrganizationMember).Name("api.organizations.members.update")
+	g.Delete("/:id<guid>/members/:user_id<guid>", controllers.DeleteOrganizationMember).Name("api.organizations.members.delete")
+	g.Get("/:id<guid>/invitations", controllers.GetAllOrganizationInvitations).Name("api.organizations.invitations.index")
+	g.Post("/:id<guid>/invitations", controllers.PostOrganizationInvitation).Name("api.organizations.invitations.add")
+	g.Delete("/:id<guid>/invitations/:invitation_id<guid>", controllers.DeleteOrganizationInvitation).Name("api.organizations.invitations.delete")
+}
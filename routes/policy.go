@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"alfredoramos.mx/csp-reporter/controllers"
+	"alfredoramos.mx/csp-reporter/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterPolicyRoutes(g fiber.Router) {
+	// Private
+	g.Use(middlewares.AuthProtected(), middlewares.ValidateAccessToken(), middlewares.CheckPermissions())
+	g.Get("/all", controllers.GetAllPolicies).Name("api.policies.index")
+	g.Post("/", controllers.PostPolicy).Name("api.policies.add")
+	g.Post("/groupings", controllers.PostGroupingPolicy).Name("api.policies.groupings.add")
+	g.Delete("/:id<guid>", controllers.DeletePolicy).Name("api.policies.delete")
+}
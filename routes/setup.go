@@ -5,8 +5,10 @@ import (
 	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"alfredoramos.mx/csp-reporter/helpers"
 	"alfredoramos.mx/csp-reporter/utils"
 	"github.com/getsentry/sentry-go"
 	sentryfiber "github.com/getsentry/sentry-go/fiber"
@@ -62,6 +64,10 @@ func SetupRoutes(app *fiber.App) {
 		Session:           session.New(sessionConfig),
 		SessionKey:        "csrf.token",
 		CookieSameSite:    "Strict",
+		// Browsers do not send API tokens on their own, so their requests cannot be forged
+		Next: func(c *fiber.Ctx) bool {
+			return helpers.IsAPIToken(strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "))
+		},
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			sentry.CaptureException(err)
 			slog.Error(fmt.Sprintf("CSRF error: %v", err))
@@ -123,12 +129,36 @@ func SetupRoutes(app *fiber.App) {
 	// Auth
 	RegisterAuthRoutes(v1.Group("/auth"))
 
+	// API tokens
+	RegisterAPITokenRoutes(v1.Group("/tokens"))
+
+	// Organizations
+	RegisterOrganizationRoutes(v1.Group("/organizations"))
+
+	// Sites
+	RegisterSiteRoutes(v1.Group("/sites"))
+
 	// CSP Report
 	RegisterCSPReportRoutes(v1.Group("/csp"))
 
+	// Users
+	RegisterUserRoutes(v1.Group("/users"))
+
+	// Policies
+	RegisterPolicyRoutes(v1.Group("/policies"))
+
 	// User activations
 	RegisterUserActivationRoutes(v1.Group("/activations"))
 
+	// Audit log
+	RegisterAuditRoutes(v1.Group("/audit"))
+
+	// Webhooks
+	RegisterWebhookRoutes(v1.Group("/webhooks"))
+
+	// Notifications
+	RegisterNotificationRoutes(v1.Group("/notifications"))
+
 	// Health check
 	RegisterHealthCheckRoutes(api)
 
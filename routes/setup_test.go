@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"path/filepath"
+	"testing"
+
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/testutil"
+	"github.com/casbin/casbin/v2"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Every named route has a default policy and every default policy has a route
+func TestDefaultPoliciesMatchRoutes(t *testing.T) {
+	app := fiber.New()
+	SetupRoutes(app)
+
+	dir := filepath.Join(testutil.RootDir(), "casbin")
+
+	e, err := casbin.NewEnforcer(filepath.Join(dir, "model.conf"), filepath.Join(dir, "policy.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := e.GetPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := helpers.MatchRoutePolicies(app.GetRoutes(true), policies)
+
+	for _, r := range check.UncoveredRoutes {
+		t.Errorf("Route without policy: %s", r)
+	}
+
+	for _, p := range check.UnusedPolicies {
+		t.Errorf("Policy without route: %s", p)
+	}
+}
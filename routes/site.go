@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"alfredoramos.mx/csp-reporter/controllers"
+	"alfredoramos.mx/csp-reporter/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterSiteRoutes(g fiber.Router) {
+	// Private
+	g.Use(middlewares.AuthProtected(), middlewares.ValidateAccessToken(), middlewares.CheckPermissions())
+	g.Get("/all", controllers.GetAllSites).Name("api.sites.index")
+	g.Post("/", controllers.PostSite).Name("api.sites.add")
+	g.Get("/:id<guid>", controllers.GetSite).Name("api.sites.show")
+	g.Patch("/:id<guid>", controllers.PatchSite).Name("api.sites.update")
+	g.Delete("/:id<guid>", controllers.DeleteSite).Name("api.sites.delete")
+	g.Patch("/:id<guid>/restore", controllers.RestoreSite).Name("api.sites.restore")
+	g.Get("/:id<guid>/verification", controllers.GetSiteVerification).Name("api.sites.verification.show")
+	g.Post("/:id<guid>/verify", controllers.VerifySite).Name("api.sites.verify")
+}
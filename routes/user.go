@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"alfredoramos.mx/csp-reporter/controllers"
+	"alfredoramos.mx/csp-reporter/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterUserRoutes(g fiber.Router) {
+	// Private
+	g.Use(middlewares.AuthProtected(), middlewares.ValidateAccessToken(), middlewares.CheckPermissions())
+	g.Get("/all", controllers.GetAllUsers).Name("api.users.index")
+	g.Get("/:id<guid>", controllers.GetUser).Name("api.users.show")
+	g.Post("/:id<guid>/roles", controllers.PostUserRole).Name("api.users.roles.add")
+	g.Delete("/:id<guid>/roles/:role_id<guid>", controllers.DeleteUserRole).Name("api.users.roles.delete")
+	g.Patch("/:id<guid>/deactivate", controllers.DeactivateUser).Name("api.users.deactivate")
+	g.Patch("/:id<guid>/reactivate", controllers.ReactivateUser).Name("api.users.reactivate")
+	g.Post("/:id<guid>/password/reset", controllers.ResetUserPassword).Name("api.users.password.reset")
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"alfredoramos.mx/csp-reporter/controllers"
+	"alfredoramos.mx/csp-reporter/middlewares"
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterWebhookRoutes(g fiber.Router) {
+	// Private
+	g.Use(middlewares.AuthProtected(), middlewares.ValidateAccessToken(), middlewares.CheckPermissions())
+	g.Get("/all", controllers.GetAllWebhooks).Name("api.webhooks.index")
+	g.Post("/", controllers.PostWebhook).Name("api.webhooks.add")
+	g.Get("/:id<guid>", controllers.GetWebhook).Name("api.webhooks.show")
+	g.Patch("/:id<guid>", controllers.PatchWebhook).Name("api.webhooks.update")
+	g.Delete("/:id<guid>", controllers.DeleteWebhook).Name("api.webhooks.delete")
+	g.Post("/:id<guid>/test", controllers.TestWebhook).Name("api.webhooks.test")
+	g.Get("/:id<guid>/deliveries", controllers.GetAllWebhookDeliveries).Name("api.webhooks.deliveries.index")
+}
@@ -0,0 +1,66 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/hibiken/asynq"
+)
+
+const (
+	TaskAlertsEvaluate string = "alerts:evaluate"
+)
+
+func HandleAlertsEvaluateTask(ctx context.Context, t *asynq.Task) error { //nolint:unused
+	events, err := helpers.EvaluateAlertRules(ctx, time.Now().In(utils.DefaultLocation()))
+	if err != nil {
+		sentry.CaptureException(err)
+		return fmt.Errorf("Could not evaluate alert rules: %w", err)
+	}
+
+	for _, e := range events {
+		//nolint:contextcheck
+		if err := Notify(Notification{
+			Content: helpers.NotificationContent{
+				Event:  helpers.WebhookAlertFired,
+				SiteID: e.SiteID,
+				Title:  fmt.Sprintf("Alert: %s", e.Rule.Name),
+				Body:   e.Message,
+				URL:    helpers.AppURL("/alerts/rules/" + e.RuleID.String()),
+			},
+			Email: helpers.EmailOpts{
+				Subject:      fmt.Sprintf("Alert: %s", e.Rule.Name),
+				TemplateName: "alert_fired",
+			},
+			EmailData: map[string]interface{}{
+				"RuleName":     e.Rule.Name,
+				"RuleKind":     e.Kind,
+				"SiteTitle":    e.Site.Title,
+				"SiteDomain":   e.Site.Domain,
+				"AlertMessage": e.Message,
+				"FiredAt":      e.CreatedAt.In(utils.DefaultLocation()).Format("2006-01-02 15:04:05 -07:00"),
+			},
+			WebhookData: map[string]interface{}{
+				"alert": e,
+				"rule":  e.Rule,
+			},
+			Fallback: true,
+		}); err != nil {
+			slog.Error(fmt.Sprintf("Error sending notifications: %v", err))
+		}
+
+		if err := DispatchWebhookEvent(helpers.WebhookAlertFired, map[string]interface{}{
+			"alert": e,
+			"rule":  e.Rule,
+		}); err != nil {
+			slog.Error(fmt.Sprintf("Error dispatching webhook event: %v", err))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+)
+
+const (
+	TaskReportExport   string = "reports:export"
+	TaskExportsCleanup string = "reports:exports:cleanup"
+)
+
+type ReportExportPayload struct {
+	ID uuid.UUID `json:"id"`
+	// Absolute URL of the download endpoint
+	DownloadURL string `json:"download_url"`
+}
+
+func HandleReportExportTask(ctx context.Context, t *asynq.Task) error { //nolint:unused
+	p := ReportExportPayload{}
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("Could not decode payload: %w: %w", err, asynq.SkipRetry)
+	}
+
+	export, err := helpers.RunExport(ctx, p.ID)
+	if err != nil {
+		sentry.CaptureException(err)
+		return fmt.Errorf("Could not export reports: %w: %w", err, asynq.SkipRetry)
+	}
+
+	user := &models.User{}
+	if err := app.DB().Where(&models.User{ID: export.UserID}).First(&user).Error; err != nil {
+		sentry.CaptureException(err)
+		return fmt.Errorf("Could not get export requester: %w: %w", err, asynq.SkipRetry)
+	}
+
+	token, err := helpers.NewExportDownloadToken(export)
+	if err != nil {
+		sentry.CaptureException(err)
+		return fmt.Errorf("Could not create download token: %w: %w", err, asynq.SkipRetry)
+	}
+
+	downloadURL := fmt.Sprintf("%s?token=%s", p.DownloadURL, url.QueryEscape(token))
+
+	//nolint:contextcheck
+	if err := NewEmail(
+		helpers.EmailOpts{
+			Subject:      "Your reports export is ready",
+			TemplateName: "report_export",
+			ToList:       []string{user.Email},
+		},
+		map[string]interface{}{
+			"UserName":    user.GetFullName(),
+			"Format":      export.Format,
+			"Rows":        export.Rows,
+			"DownloadURL": downloadURL,
+			"ExpiresAt":   export.ExpiresAt.In(utils.DefaultLocation()).Format("2006-01-02 15:04 MST"),
+		},
+	); err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Error sending email: %v", err))
+	}
+
+	return nil
+}
+
+func HandleExportsCleanupTask(ctx context.Context, t *asynq.Task) error { //nolint:unused
+	if err := helpers.PurgeExpiredExports(ctx); err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	return nil
+}
+
+func NewReportExport(id uuid.UUID, downloadURL string) error {
+	payload, err := json.Marshal(ReportExportPayload{ID: id, DownloadURL: downloadURL})
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	info, err := AsynqClient().Enqueue(
+		asynq.NewTask(TaskReportExport, payload),
+		asynq.Queue("low"),
+		asynq.MaxRetry(0),
+		asynq.Timeout(time.Hour),
+		asynq.Retention(24*time.Hour),
+	)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Could not enqueue task: %v", err))
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Enqueued tasks: [%s] %s", info.ID, info.Queue))
+
+	return nil
+}
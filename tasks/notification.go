@@ -0,0 +1,176 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+)
+
+const (
+	TaskNotificationsDigest string = "notifications:digest"
+)
+
+// Items listed in a digest, the rest are only counted
+const maxDigestItems int = 50
+
+type Notification struct {
+	Content helpers.NotificationContent
+	// Subject and template of the immediate email
+	Email     helpers.EmailOpts
+	EmailData map[string]interface{}
+	// Payload sent to personal webhooks
+	WebhookData any
+	// Send the email to the internal staff when nobody is subscribed to the site
+	Fallback bool
+}
+
+// Delivers the notification to the users subscribed to the site, according to their preferences
+func Notify(n Notification) error {
+	recipients, err := helpers.GetNotificationRecipients(n.Content.Event, n.Content.SiteID)
+	if err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Could not get notification recipients: %v", err))
+		return err
+	}
+
+	if len(recipients) < 1 {
+		if !n.Fallback {
+			return nil
+		}
+
+		opts := n.Email
+		opts.IsInternal = true
+		opts.ToList = []string{utils.InternalStaffEmail()}
+
+		return NewEmail(opts, n.emailData(""))
+	}
+
+	for _, r := range recipients {
+		for channel, frequency := range r.Frequencies {
+			switch frequency {
+			case helpers.NotificationImmediate:
+				err = notifyNow(n, r, channel)
+			case helpers.NotificationDigest:
+				err = helpers.QueueNotificationDigest(r.UserID, channel, n.Content)
+			default:
+				continue
+			}
+
+			if err != nil {
+				sentry.CaptureException(err)
+				slog.Error(fmt.Sprintf("Could not notify user %s via %s: %v", r.UserID, channel, err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Template data, including the content of the notification
+func (n Notification) emailData(userName string) map[string]interface{} {
+	data := maps.Clone(n.EmailData)
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+
+	data["Title"] = n.Content.Title
+	data["Body"] = n.Content.Body
+	data["URL"] = n.Content.URL
+	data["UserName"] = userName
+
+	return data
+}
+
+func notifyNow(n Notification, r helpers.NotificationRecipient, channel string) error {
+	switch channel {
+	case helpers.NotificationEmail:
+		opts := n.Email
+		opts.IsInternal = true
+		opts.SkipBCC = true
+		opts.ToList = []string{r.Email}
+
+		return NewEmail(opts, n.emailData(r.Name))
+	case helpers.NotificationInApp:
+		return helpers.CreateInAppNotification(r.UserID, n.Content)
+	case helpers.NotificationWebhook:
+		return DispatchUserWebhookEvent(r.UserID, n.Content.Event, n.WebhookData)
+	default:
+		return fmt.Errorf("Invalid notification channel '%s'.", channel)
+	}
+}
+
+func HandleNotificationsDigestTask(ctx context.Context, t *asynq.Task) error { //nolint:unused
+	digests, err := helpers.GetPendingDigests(ctx)
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	for _, d := range digests {
+		payload := d.Payload(maxDigestItems)
+		// Template data goes through JSON, keys must match the template
+		items := []map[string]interface{}{}
+
+		for _, item := range payload.Items {
+			items = append(items, map[string]interface{}{"Title": item.Title, "Body": item.Body, "URL": item.URL})
+		}
+		switch d.Channel {
+		case helpers.NotificationEmail:
+			//nolint:contextcheck
+			err = NewEmail(
+				helpers.EmailOpts{
+					Subject:      "Notification digest",
+					TemplateName: "notification_digest",
+					IsInternal:   true,
+					SkipBCC:      true,
+					ToList:       []string{d.User.Email},
+				},
+				map[string]interface{}{
+					"UserName":   d.User.GetFullName(),
+					"Count":      payload.Count,
+					"Items":      payload.Items,
+					"More":       payload.Count - len(payload.Items),
+					"ViewURL":    helpers.AppURL("/notifications"),
+					"DigestDate": time.Now().In(utils.DefaultLocation()).Format("2006-01-02"),
+				},
+			)
+		case helpers.NotificationInApp:
+			err = helpers.CreateInAppNotification(d.User.ID, helpers.NotificationContent{
+				Event: helpers.WebhookNotificationDigest,
+				Title: fmt.Sprintf("%d new notifications", payload.Count),
+				Body:  fmt.Sprintf("You have %d notifications since the last digest.", payload.Count),
+				URL:   helpers.AppURL("/notifications"),
+			})
+		case helpers.NotificationWebhook:
+			err = DispatchUserWebhookEvent(d.User.ID, helpers.WebhookNotificationDigest, payload)
+		default:
+			continue
+		}
+
+		if err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not send %s digest to user %s: %v", d.Channel, d.User.ID, err))
+			continue
+		}
+
+		if err := helpers.MarkDigestSent(ctx, d); err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not mark digest as sent: %v", err))
+		}
+	}
+
+	return nil
+}
+
+// Queues the delivery of the event to the enabled personal webhooks of the user
+func DispatchUserWebhookEvent(userID uuid.UUID, event string, data any) error {
+	return dispatchWebhookEvent(event, data, "user_id = ?", userID)
+}
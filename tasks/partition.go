@@ -0,0 +1,25 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/hibiken/asynq"
+)
+
+const (
+	TaskReportPartitions string = "reports:partitions"
+)
+
+func HandleReportPartitionsTask(ctx context.Context, t *asynq.Task) error { //nolint:unused
+	if err := app.MaintainReportPartitions(time.Now().In(utils.DefaultLocation())); err != nil {
+		sentry.CaptureException(err)
+		return fmt.Errorf("Could not maintain reports partitions: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/utils"
+	"github.com/getsentry/sentry-go"
+	"github.com/hibiken/asynq"
+)
+
+const (
+	TaskHourlyRollups   string = "reports:rollups:hourly"
+	TaskDailyRollups    string = "reports:rollups:daily"
+	TaskRollupRecompute string = "reports:rollups:recompute"
+)
+
+type RollupRecomputePayload struct {
+	Granularity string    `json:"granularity"`
+	Bucket      time.Time `json:"bucket"`
+}
+
+func recomputeRecentRollups(granularity string, now time.Time) error {
+	current, _ := helpers.RollupBucket(granularity, now)
+	previous, _ := helpers.RollupBucket(granularity, current.Add(-time.Second))
+
+	// The previous bucket is included to account for late reports
+	for _, bucket := range []time.Time{previous, current} {
+		if err := helpers.RecomputeRollup(granularity, bucket); err != nil {
+			sentry.CaptureException(err)
+			return fmt.Errorf("Could not recompute %s rollup for %s: %w", granularity, bucket.Format(time.RFC3339), err)
+		}
+	}
+
+	return nil
+}
+
+func HandleHourlyRollupsTask(ctx context.Context, t *asynq.Task) error { //nolint:unused
+	return recomputeRecentRollups(helpers.RollupHourly, time.Now().In(utils.DefaultLocation()))
+}
+
+func HandleDailyRollupsTask(ctx context.Context, t *asynq.Task) error { //nolint:unused
+	return recomputeRecentRollups(helpers.RollupDaily, time.Now().In(utils.DefaultLocation()))
+}
+
+func HandleRollupRecomputeTask(ctx context.Context, t *asynq.Task) error { //nolint:unused
+	p := RollupRecomputePayload{}
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("Could not decode payload: %w: %w", err, asynq.SkipRetry)
+	}
+
+	if !slices.Contains(helpers.RollupGranularities(), p.Granularity) {
+		return fmt.Errorf("Invalid rollup granularity '%s': %w", p.Granularity, asynq.SkipRetry)
+	}
+
+	if err := helpers.RecomputeRollup(p.Granularity, p.Bucket); err != nil {
+		sentry.CaptureException(err)
+		return fmt.Errorf("Could not recompute rollup: %w", err)
+	}
+
+	return nil
+}
+
+func NewRollupRecompute(bucket time.Time) error {
+	for _, granularity := range helpers.RollupGranularities() {
+		start, _ := helpers.RollupBucket(granularity, bucket)
+
+		payload, err := json.Marshal(RollupRecomputePayload{Granularity: granularity, Bucket: start})
+		if err != nil {
+			sentry.CaptureException(err)
+			return err
+		}
+
+		// Unique while pending so bulk changes recompute each bucket only once
+		info, err := AsynqClient().Enqueue(
+			asynq.NewTask(TaskRollupRecompute, payload),
+			asynq.MaxRetry(3),
+			asynq.ProcessIn(30*time.Second),
+			asynq.Unique(time.Minute),
+			asynq.Queue("low"),
+		)
+		if err != nil && !errors.Is(err, asynq.ErrDuplicateTask) {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not enqueue task: %v", err))
+			return err
+		}
+
+		if info != nil {
+			slog.Info(fmt.Sprintf("Enqueued tasks: [%s] %s", info.ID, info.Queue))
+		}
+	}
+
+	return nil
+}
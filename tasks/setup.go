@@ -65,6 +65,13 @@ func AsynqServer() *asynq.Server {
 					"default":  3,
 					"low":      1,
 				},
+				RetryDelayFunc: func(n int, err error, t *asynq.Task) time.Duration {
+					if t.Type() == TaskWebhookDelivery {
+						return webhookRetryDelay(n)
+					}
+
+					return asynq.DefaultRetryDelayFunc(n, err, t)
+				},
 			},
 		)
 	})
@@ -76,6 +83,16 @@ func AsynqServeMux() *asynq.ServeMux {
 	onceServeMux.Do(func() {
 		serveMux = asynq.NewServeMux()
 		serveMux.HandleFunc(TaskEmailDelivery, HandleEmailDeliveryTask)
+		serveMux.HandleFunc(TaskReportPartitions, HandleReportPartitionsTask)
+		serveMux.HandleFunc(TaskHourlyRollups, HandleHourlyRollupsTask)
+		serveMux.HandleFunc(TaskDailyRollups, HandleDailyRollupsTask)
+		serveMux.HandleFunc(TaskRollupRecompute, HandleRollupRecomputeTask)
+		serveMux.HandleFunc(TaskReportExport, HandleReportExportTask)
+		serveMux.HandleFunc(TaskExportsCleanup, HandleExportsCleanupTask)
+		serveMux.HandleFunc(TaskAlertsEvaluate, HandleAlertsEvaluateTask)
+		serveMux.HandleFunc(TaskWebhookDelivery, HandleWebhookDeliveryTask)
+		serveMux.HandleFunc(TaskNotificationsDigest, HandleNotificationsDigestTask)
+		serveMux.HandleFunc(TaskSitesVerify, HandleSitesVerifyTask)
 	})
 
 	return serveMux
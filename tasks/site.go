@@ -0,0 +1,56 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/hibiken/asynq"
+)
+
+const (
+	TaskSitesVerify string = "sites:verify"
+)
+
+// Verifies pending sites and re-verifies the rest, sites trusted by the configuration are skipped
+func HandleSitesVerifyTask(ctx context.Context, t *asynq.Task) error { //nolint:unused
+	sites := []models.Site{}
+	if err := app.DB().WithContext(ctx).
+		Where("verification_method IS NULL OR verification_method <> ?", helpers.SiteVerificationConfig).
+		Preload("Domains").
+		Find(&sites).Error; err != nil {
+		sentry.CaptureException(err)
+		return fmt.Errorf("Could not get sites: %w", err)
+	}
+
+	for i := range sites {
+		site := &sites[i]
+		wasVerified := site.VerifiedAt != nil
+
+		result, err := helpers.VerifySite(ctx, site)
+		if err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not verify site %s: %v", site.ID, err))
+			continue
+		}
+
+		switch {
+		case result.Verified && !wasVerified:
+			slog.Info(fmt.Sprintf("Site %s (%s) has been verified", site.ID, site.Domain))
+		case !result.Verified && wasVerified && site.VerifiedAt == nil:
+			slog.Warn(fmt.Sprintf("Site %s (%s) is no longer verified: %s", site.ID, site.Domain, result.Error))
+		}
+
+		for _, bucket := range result.Buckets {
+			if err := NewRollupRecompute(bucket); err != nil {
+				slog.Error(fmt.Sprintf("Could not queue rollup recompute: %v", err))
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"gorm.io/gorm"
+)
+
+const (
+	TaskWebhookDelivery string = "webhooks:delivery"
+)
+
+const (
+	webhookMaxRetry      int           = 8
+	webhookRetryBase     time.Duration = 30 * time.Second
+	webhookRetryMaxDelay time.Duration = 6 * time.Hour
+)
+
+type WebhookDeliveryPayload struct {
+	WebhookID uuid.UUID            `json:"webhook_id"`
+	Event     helpers.WebhookEvent `json:"event"`
+}
+
+// Exponential backoff with jitter: 30s, 1m, 2m, 4m... up to 6h
+func webhookRetryDelay(n int) time.Duration {
+	delay := webhookRetryMaxDelay
+
+	if n < 20 {
+		delay = min(webhookRetryBase<<n, webhookRetryMaxDelay)
+	}
+
+	return delay + rand.N(delay/10+1) //nolint:gosec
+}
+
+func HandleWebhookDeliveryTask(ctx context.Context, t *asynq.Task) error { //nolint:unused
+	p := WebhookDeliveryPayload{}
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("Could not decode payload: %w: %w", err, asynq.SkipRetry)
+	}
+
+	enabled := true
+	webhook := &models.Webhook{}
+	if err := app.DB().Where(&models.Webhook{ID: p.WebhookID, Enabled: &enabled}).First(&webhook).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("The webhook %s does not exist or is disabled: %w", p.WebhookID, asynq.SkipRetry)
+		}
+
+		return fmt.Errorf("Could not get webhook: %w", err)
+	}
+
+	retried, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+
+	if _, err := helpers.DeliverWebhook(ctx, webhook, p.Event, retried+1); err != nil {
+		// Only the last attempt counts as a failed event
+		if retried >= maxRetry {
+			disabled, err := helpers.RecordWebhookFailure(webhook.ID)
+			if err != nil {
+				sentry.CaptureException(err)
+				slog.Error(fmt.Sprintf("Could not record webhook failure: %v", err))
+			}
+
+			if disabled {
+				slog.Warn(fmt.Sprintf("Webhook %s has been disabled after too many failures", webhook.ID))
+			}
+		}
+
+		return fmt.Errorf("Could not deliver webhook event: %w", err)
+	}
+
+	if webhook.FailureCount > 0 {
+		if err := helpers.RecordWebhookSuccess(webhook.ID); err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not reset webhook failures: %v", err))
+		}
+	}
+
+	return nil
+}
+
+// Queues the delivery of the event to every enabled webhook subscribed to it
+func DispatchWebhookEvent(event string, data any) error {
+	return dispatchWebhookEvent(event, data, "user_id IS NULL AND events @> ?::jsonb", models.WebhookEvents{event})
+}
+
+func dispatchWebhookEvent(event string, data any, query string, args ...interface{}) error {
+	ids := []uuid.UUID{}
+	enabled := true
+
+	if err := app.DB().Model(&models.Webhook{}).
+		Where(&models.Webhook{Enabled: &enabled}).
+		Where(query, args...).
+		Pluck("id", &ids).Error; err != nil {
+		sentry.CaptureException(err)
+		slog.Error(fmt.Sprintf("Could not get webhooks: %v", err))
+		return err
+	}
+
+	if len(ids) < 1 {
+		return nil
+	}
+
+	e, err := helpers.NewWebhookEvent(event, data)
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	for _, id := range ids {
+		payload, err := json.Marshal(WebhookDeliveryPayload{WebhookID: id, Event: e})
+		if err != nil {
+			sentry.CaptureException(err)
+			return err
+		}
+
+		info, err := AsynqClient().Enqueue(
+			asynq.NewTask(TaskWebhookDelivery, payload),
+			asynq.MaxRetry(webhookMaxRetry),
+			asynq.Timeout(time.Minute),
+			asynq.Retention(24*time.Hour),
+		)
+		if err != nil {
+			sentry.CaptureException(err)
+			slog.Error(fmt.Sprintf("Could not enqueue task: %v", err))
+			return err
+		}
+
+		slog.Info(fmt.Sprintf("Enqueued tasks: [%s] %s", info.ID, info.Queue))
+	}
+
+	return nil
+}
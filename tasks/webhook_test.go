@@ -0,0 +1,110 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/helpers"
+	"alfredoramos.mx/csp-reporter/models"
+	"alfredoramos.mx/csp-reporter/testutil"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+)
+
+func TestWebhookRetryDelay(t *testing.T) {
+	prev := time.Duration(0)
+
+	for n := range 25 {
+		delay := webhookRetryDelay(n)
+		base := min(webhookRetryBase<<min(n, 20), webhookRetryMaxDelay)
+
+		if delay < base || delay > base+base/10 {
+			t.Errorf("retry %d: got %s, want between %s and %s", n, delay, base, base+base/10)
+		}
+
+		if base < prev {
+			t.Errorf("retry %d: the delay decreased", n)
+		}
+
+		prev = base
+	}
+}
+
+// Server errors are retried, the next attempt is delivered
+func TestHandleWebhookDeliveryTaskRetries(t *testing.T) {
+	db := testutil.DB(t)
+
+	// Allows the loopback address of the test server
+	t.Setenv("APP_DEBUG", "true")
+
+	statuses := []int{http.StatusInternalServerError, http.StatusOK}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(statuses[min(requests, len(statuses)-1)])
+		requests++
+	}))
+	defer srv.Close()
+
+	user := &models.User{Email: fmt.Sprintf("%s@example.com", uuid.NewString()), Password: "-"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	webhook := &models.Webhook{
+		Name:        "Test",
+		URL:         srv.URL,
+		Kind:        helpers.WebhookJSON,
+		Secret:      "whsec_test",
+		Events:      models.WebhookEvents{helpers.WebhookPing},
+		CreatedByID: user.ID,
+	}
+	if err := db.Create(&webhook).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Where(&models.WebhookDelivery{WebhookID: webhook.ID}).Delete(&models.WebhookDelivery{})
+		db.Unscoped().Delete(&webhook)
+		db.Unscoped().Delete(&user)
+	})
+
+	e, err := helpers.NewWebhookEvent(helpers.WebhookPing, map[string]string{"message": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(WebhookDeliveryPayload{WebhookID: webhook.ID, Event: e})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task := asynq.NewTask(TaskWebhookDelivery, payload)
+
+	err = HandleWebhookDeliveryTask(context.Background(), task)
+	if err == nil {
+		t.Fatal("A server error must fail the delivery.")
+	}
+
+	if errors.Is(err, asynq.SkipRetry) {
+		t.Fatalf("A server error must be retried, got %v", err)
+	}
+
+	if err := HandleWebhookDeliveryTask(context.Background(), task); err != nil {
+		t.Fatalf("The retry must be delivered, got %v", err)
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	if err := db.Where(&models.WebhookDelivery{WebhookID: webhook.ID}).Order("created_at").Find(&deliveries).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries) != 2 || deliveries[0].Success || !deliveries[1].Success {
+		t.Fatalf("got %d deliveries, want a failed one followed by a successful one", len(deliveries))
+	}
+}
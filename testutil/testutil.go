@@ -0,0 +1,125 @@
+// Package testutil prepares the keys, configuration and database the tests need.
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"alfredoramos.mx/csp-reporter/app"
+	"github.com/go-jose/go-jose/v4"
+	"gorm.io/gorm"
+)
+
+// Directory of the repository, where the Casbin and template files are
+func RootDir() string {
+	_, file, _, _ := runtime.Caller(0)
+
+	return filepath.Dir(filepath.Dir(file))
+}
+
+func writeKey(dir string, name string, key jose.JSONWebKey) error {
+	raw, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, name), raw, 0o600)
+}
+
+func writeKeys(dir string) error {
+	_, sigKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	encKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	sig := jose.JSONWebKey{Key: sigKey, KeyID: "test-sig", Algorithm: string(jose.EdDSA), Use: "sig"}
+	enc := jose.JSONWebKey{Key: encKey, KeyID: "test-enc", Algorithm: string(jose.ECDH_ES_A256KW), Use: "enc"}
+
+	keys := map[string]jose.JSONWebKey{
+		"signing-private.json":    sig,
+		"signing-public.json":     sig.Public(),
+		"encryption-private.json": enc,
+		"encryption-public.json":  enc.Public(),
+	}
+
+	for name, key := range keys {
+		if err := writeKey(dir, name, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Runs the tests from a temporary directory with newly generated keys,
+// the Casbin and template files are linked from the repository.
+func Main(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "csp-reporter-test-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create test directory: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "keys"), 0o700); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create keys directory: %v\n", err)
+		return 1
+	}
+
+	if err := writeKeys(filepath.Join(dir, "keys")); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not generate keys: %v\n", err)
+		return 1
+	}
+
+	for _, name := range []string{"casbin", "templates"} {
+		if err := os.Symlink(filepath.Join(RootDir(), name), filepath.Join(dir, name)); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not link %s: %v\n", name, err)
+			return 1
+		}
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not change directory: %v\n", err)
+		return 1
+	}
+
+	defaults := map[string]string{
+		"APP_DOMAIN":        "https://csp.example.com",
+		"COOKIE_SECRET_KEY": "dGVzdC1jb29raWUtc2VjcmV0LWtleS0zMi1ieXRlcyE=",
+		"SENTRY_DSN":        "",
+		"TZ":                "UTC",
+	}
+
+	for k, v := range defaults {
+		if _, ok := os.LookupEnv(k); !ok {
+			os.Setenv(k, v)
+		}
+	}
+
+	return m.Run()
+}
+
+// PostgreSQL configured like the app, tests using it are skipped when DB_HOST is not set.
+// They also need Redis (REDIS_HOST), as the helpers invalidate cached data.
+func DB(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	if len(os.Getenv("DB_HOST")) < 1 {
+		t.Skip("DB_HOST is not set, skipping database test")
+	}
+
+	return app.DB()
+}
@@ -1,8 +1,6 @@
 package utils
 
 import (
-	"encoding/base64"
-	"encoding/json"
 	"mime/multipart"
 	"net/url"
 	"os"
@@ -28,12 +26,18 @@ const (
 	defaultPageSize int = 50
 )
 
-func CreateCursor(id uuid.UUID, createdAt time.Time, pointsNext bool) Cursor {
-	return Cursor{
+func CreateCursor(id uuid.UUID, createdAt time.Time, pointsNext bool, filters string) Cursor {
+	cur := Cursor{
 		"id":          id,
 		"created_at":  createdAt,
 		"points_next": pointsNext,
 	}
+
+	if len(filters) > 0 {
+		cur["filters"] = filters
+	}
+
+	return cur
 }
 
 func GeneratePager(next Cursor, prev Cursor, routeName string, ctx *fiber.Ctx) PaginationInfo {
@@ -47,38 +51,34 @@ func GeneratePager(next Cursor, prev Cursor, routeName string, ctx *fiber.Ctx) P
 	}
 
 	return PaginationInfo{
-		NextCursor: CursorAbsoluteURL(encodeCursor(next), routeName, routeParams, ctx),
-		PrevCursor: CursorAbsoluteURL(encodeCursor(prev), routeName, routeParams, ctx),
+		NextCursor: CursorAbsoluteURL(encodeCursor(next, routeName), routeName, routeParams, ctx),
+		PrevCursor: CursorAbsoluteURL(encodeCursor(prev, routeName), routeName, routeParams, ctx),
 	}
 }
 
-func encodeCursor(cursor Cursor) *string {
+func cursorAudience(routeName string) string {
+	return "cursor:" + routeName
+}
+
+// Cursors are signed and bound to the route they were created for
+func encodeCursor(cursor Cursor, routeName string) *string {
 	if len(cursor) == 0 {
 		return nil
 	}
 
-	serializedCursor, err := json.Marshal(cursor)
+	encodedCursor, err := NewSignedToken(cursorAudience(routeName), cursor, CursorExpiration())
 	if err != nil {
 		sentry.CaptureException(err)
 		return nil
 	}
 
-	encodedCursor := base64.RawStdEncoding.EncodeToString(serializedCursor)
-
 	return &encodedCursor
 }
 
-func DecodeCursor(cursor string) (Cursor, error) {
-	decodedCursor, err := base64.RawStdEncoding.DecodeString(cursor)
-	if err != nil {
-		sentry.CaptureException(err)
-		return nil, err
-	}
-
+func DecodeCursor(cursor string, routeName string) (Cursor, error) {
 	cur := Cursor{}
 
-	if err := json.Unmarshal(decodedCursor, &cur); err != nil {
-		sentry.CaptureException(err)
+	if err := ParseSignedToken(cursor, cursorAudience(routeName), &cur); err != nil {
 		return nil, err
 	}
 
@@ -152,10 +152,10 @@ func CursorAbsoluteURL(cur *string, n string, p fiber.Map, c *fiber.Ctx) *string
 	params := url.Values{}
 
 	for key, value := range c.Queries() {
-		params.Set(key, url.QueryEscape(value))
+		params.Set(key, value)
 	}
 
-	params.Set("cursor", url.QueryEscape(*cur))
+	params.Set("cursor", *cur)
 
 	// Append cursor query
 	u.RawQuery = params.Encode()
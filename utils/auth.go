@@ -14,6 +14,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"alfredoramos.mx/csp-reporter/jwt"
 	"github.com/ccojocar/zxcvbn-go"
@@ -53,6 +54,11 @@ type CustomJwtClaims struct {
 	User UserClaimData `json:"user,omitempty"`
 }
 
+type SignedTokenClaims struct {
+	jose_jwt.Claims
+	Data json.RawMessage `json:"data"`
+}
+
 func (c CustomJwtClaims) Validate() error {
 	if !IsValidIssuer(c.Issuer) {
 		return errors.New("The issuer is invalid.")
@@ -142,6 +148,70 @@ func ParseJWEClaims(token string) (*CustomJwtClaims, error) {
 	return claims, nil
 }
 
+// Signs arbitrary data that is only valid for the given audience
+func NewSignedToken(audience string, data any, exp time.Duration) (string, error) {
+	issuer, err := GetJwtIssuer()
+	if err != nil {
+		sentry.CaptureException(err)
+		return "", fmt.Errorf("Invalid token issuer '%s': %w", issuer, err)
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		sentry.CaptureException(err)
+		return "", err
+	}
+
+	now := time.Now().In(DefaultLocation())
+
+	claims := &SignedTokenClaims{
+		Claims: jose_jwt.Claims{
+			Issuer:    issuer,
+			Audience:  jose_jwt.Audience{audience},
+			IssuedAt:  jose_jwt.NewNumericDate(now),
+			NotBefore: jose_jwt.NewNumericDate(now),
+			Expiry:    jose_jwt.NewNumericDate(now.Add(exp)),
+		},
+		Data: raw,
+	}
+
+	token, err := jose_jwt.Signed(jwt.Signer()).Claims(claims).Serialize()
+	if err != nil {
+		sentry.CaptureException(err)
+		return "", fmt.Errorf("Error generating signed token: %w", err)
+	}
+
+	return token, nil
+}
+
+func ParseSignedToken(token string, audience string, data any) error {
+	parsed, err := jose_jwt.ParseSigned(token, []jose.SignatureAlgorithm{jose.SignatureAlgorithm(jwt.SigningKeys().Private.Algorithm)})
+	if err != nil {
+		return err
+	}
+
+	claims := &SignedTokenClaims{}
+	if err := parsed.Claims(jwt.SigningKeys().Public, claims); err != nil {
+		return err
+	}
+
+	issuer, err := GetJwtIssuer()
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if err := claims.Validate(jose_jwt.Expected{
+		Issuer:      issuer,
+		AnyAudience: jose_jwt.Audience{audience},
+		Time:        time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(claims.Data, data)
+}
+
 func NewArgon2Config() Argon2Config {
 	return Argon2Config{
 		Memory:      64 * 1024,
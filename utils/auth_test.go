@@ -0,0 +1,106 @@
+package utils_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"alfredoramos.mx/csp-reporter/utils"
+	jose_jwt "github.com/go-jose/go-jose/v4/jwt"
+)
+
+type signedTestData struct {
+	ID    string `json:"id"`
+	Count int    `json:"count"`
+}
+
+func TestSignedTokenRoundTrip(t *testing.T) {
+	data := signedTestData{ID: "abc", Count: 3}
+
+	token, err := utils.NewSignedToken("test:audience", data, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := signedTestData{}
+	if err := utils.ParseSignedToken(token, "test:audience", &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got != data {
+		t.Errorf("got %+v, want %+v", got, data)
+	}
+}
+
+func TestSignedTokenRejected(t *testing.T) {
+	valid, err := utils.NewSignedToken("test:audience", signedTestData{ID: "abc"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Past the default leeway of one minute
+	expired, err := utils.NewSignedToken("test:audience", signedTestData{ID: "abc"}, -2*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + parts[1] + "x." + parts[2]
+
+	tests := []struct {
+		name     string
+		token    string
+		audience string
+		want     error
+	}{
+		{"expired", expired, "test:audience", jose_jwt.ErrExpired},
+		{"audience mismatch", valid, "test:other", jose_jwt.ErrInvalidAudience},
+		{"tampered", tampered, "test:audience", nil},
+		{"malformed", "not-a-token", "test:audience", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := utils.ParseSignedToken(tt.token, tt.audience, &signedTestData{})
+
+			if err == nil {
+				t.Fatal("The token was accepted.")
+			}
+
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedTokenIssuerMismatch(t *testing.T) {
+	t.Setenv("APP_DOMAIN", "https://example.com")
+
+	token, err := utils.NewSignedToken("test:audience", signedTestData{ID: "abc"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("APP_DOMAIN", "https://example.org")
+
+	if err := utils.ParseSignedToken(token, "test:audience", &signedTestData{}); !errors.Is(err, jose_jwt.ErrInvalidIssuer) {
+		t.Errorf("got %v, want %v", err, jose_jwt.ErrInvalidIssuer)
+	}
+}
+
+func TestCursorBoundToRoute(t *testing.T) {
+	token, err := utils.NewSignedToken("cursor:api.csp.reports.index", utils.Cursor{"id": "abc"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := utils.DecodeCursor(token, "api.csp.reports.index"); err != nil {
+		t.Errorf("The cursor of the route was rejected: %v", err)
+	}
+
+	if _, err := utils.DecodeCursor(token, "api.users.index"); err == nil {
+		t.Error("The cursor of another route was accepted.")
+	}
+}
@@ -4,15 +4,22 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"html"
 	"math/big"
 	"net/url"
+	"regexp"
+	"slices"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/gofiber/fiber/v2"
 	"golang.org/x/net/publicsuffix"
 )
 
+const highlightSnippetSize int = 200
+
 func AddError(m fiber.Map, k string, v string) fiber.Map {
 	if _, ok := m[k]; !ok {
 		m[k] = []string{v}
@@ -92,3 +99,175 @@ func ToStringPtr(s string) *string {
 
 	return &s
 }
+
+func GetURIHost(u string) *string {
+	u = strings.TrimSpace(u)
+
+	if len(u) < 1 {
+		return nil
+	}
+
+	p, err := url.Parse(u)
+	if err != nil {
+		sentry.CaptureException(err)
+		return nil
+	}
+
+	host := strings.ToLower(p.Hostname())
+
+	// Keywords such as 'inline', 'eval' or schemes like 'data:' and 'blob:'
+	if len(host) < 1 {
+		host = strings.ToLower(p.Scheme)
+	}
+
+	if len(host) < 1 {
+		host = strings.ToLower(p.Path)
+	}
+
+	if len(host) > 255 {
+		host = host[:255]
+	}
+
+	return ToStringPtr(host)
+}
+
+func GetURIPath(u string) *string {
+	p, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		sentry.CaptureException(err)
+		return nil
+	}
+
+	if len(p.Host) < 1 {
+		return nil
+	}
+
+	if len(p.Path) < 1 {
+		return ToStringPtr("/")
+	}
+
+	return ToStringPtr(p.Path)
+}
+
+func GetBrowserName(ua string) *string {
+	ua = strings.TrimSpace(ua)
+
+	if len(ua) < 1 {
+		return nil
+	}
+
+	// Order matters, most browsers include the tokens of the ones they are based on
+	browsers := []struct {
+		Token string
+		Name  string
+	}{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"SamsungBrowser/", "Samsung Internet"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	}
+
+	for _, b := range browsers {
+		if strings.Contains(ua, b.Token) {
+			return &b.Name
+		}
+	}
+
+	return ToStringPtr("Other")
+}
+
+func SearchTerms(q string) []string {
+	terms := []string{}
+	q = strings.ToLower(strings.TrimSpace(q))
+
+	if len(q) > 0 {
+		terms = append(terms, q)
+	}
+
+	for _, t := range strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if len([]rune(t)) > 1 && !slices.Contains(terms, t) {
+			terms = append(terms, t)
+		}
+	}
+
+	// Longest terms are matched first
+	slices.SortStableFunc(terms, func(a, b string) int {
+		return len(b) - len(a)
+	})
+
+	return terms
+}
+
+// Wraps the matching terms in <mark> tags and escapes the rest of the text.
+// Long texts are cut down to the surroundings of the first match.
+func HighlightMatches(text string, terms []string) (string, bool) {
+	if len(terms) < 1 {
+		return "", false
+	}
+
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = regexp.QuoteMeta(t)
+	}
+
+	re, err := regexp.Compile("(?i)" + strings.Join(quoted, "|"))
+	if err != nil {
+		sentry.CaptureException(err)
+		return "", false
+	}
+
+	matches := re.FindAllStringIndex(text, -1)
+	if len(matches) < 1 {
+		return "", false
+	}
+
+	start, end := 0, len(text)
+
+	if end > highlightSnippetSize {
+		start = max(matches[0][0]-highlightSnippetSize/4, 0)
+		end = min(start+highlightSnippetSize, len(text))
+
+		// Avoid cutting multi-byte characters
+		for start > 0 && !utf8.RuneStart(text[start]) {
+			start--
+		}
+
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+	}
+
+	var b strings.Builder
+
+	if start > 0 {
+		b.WriteString("…")
+	}
+
+	pos := start
+
+	for _, m := range matches {
+		if m[0] < pos || m[1] > end {
+			continue
+		}
+
+		b.WriteString(html.EscapeString(text[pos:m[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[m[0]:m[1]]))
+		b.WriteString("</mark>")
+		pos = m[1]
+	}
+
+	b.WriteString(html.EscapeString(text[pos:end]))
+
+	if end < len(text) {
+		b.WriteString("…")
+	}
+
+	return b.String(), true
+}
@@ -3,7 +3,10 @@ package utils
 import (
 	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 	_ "time/tzdata"
 
@@ -11,12 +14,32 @@ import (
 )
 
 const (
-	minAccessTokenExpiration      int64 = 1
-	defaultAccessTokenExpiration  int64 = 1
-	maxAccessTokenExpiration      int64 = 2
-	minRefreshTokenExpiration     int64 = 1
-	defaultRefreshTokenExpiration int64 = 6
-	maxRefreshTokenExpiration     int64 = 12
+	minAccessTokenExpiration       int64 = 1
+	defaultAccessTokenExpiration   int64 = 1
+	maxAccessTokenExpiration       int64 = 2
+	minRefreshTokenExpiration      int64 = 1
+	defaultRefreshTokenExpiration  int64 = 6
+	maxRefreshTokenExpiration      int64 = 12
+	minReportsPartitionPremake     int   = 1
+	defaultReportsPartitionPremake int   = 3
+	maxReportsPartitionPremake     int   = 24
+	minCursorExpiration            int64 = 5
+	defaultCursorExpiration        int64 = 60
+	maxCursorExpiration            int64 = 1440
+	minExportExpiration            int64 = 1
+	defaultExportExpiration        int64 = 24
+	maxExportExpiration            int64 = 168
+	minWebhookFailureLimit         int   = 1
+	defaultWebhookFailureLimit     int   = 10
+	maxWebhookFailureLimit         int   = 100
+	minWebhookTimeout              int64 = 1
+	defaultWebhookTimeout          int64 = 10
+	maxWebhookTimeout              int64 = 30
+)
+
+const (
+	SiteUnverifiedReject     string = "reject"
+	SiteUnverifiedQuarantine string = "quarantine"
 )
 
 func IsDebug() bool {
@@ -128,3 +151,329 @@ func EmailLang() string {
 
 	return l
 }
+
+func ReportsPartitionPremake() int {
+	months, err := strconv.Atoi(os.Getenv("REPORTS_PARTITION_PREMAKE"))
+	if err != nil {
+		sentry.CaptureException(err)
+		months = defaultReportsPartitionPremake
+	}
+
+	if months < minReportsPartitionPremake {
+		months = minReportsPartitionPremake
+	}
+
+	if months > maxReportsPartitionPremake {
+		months = maxReportsPartitionPremake
+	}
+
+	return months
+}
+
+func ReportsRetentionMonths() int {
+	months, err := strconv.Atoi(os.Getenv("REPORTS_RETENTION_MONTHS"))
+	if err != nil {
+		sentry.CaptureException(err)
+		months = 0
+	}
+
+	if months < 0 {
+		months = 0
+	}
+
+	return months
+}
+
+func DropDetachedPartitions() bool {
+	drop, err := strconv.ParseBool(os.Getenv("REPORTS_PARTITION_DROP"))
+	if err != nil {
+		sentry.CaptureException(err)
+		drop = false
+	}
+
+	return drop
+}
+
+// Whether the server must not start when routes and policies do not match
+func StrictPolicyCheck() bool {
+	strict, err := strconv.ParseBool(os.Getenv("POLICY_CHECK_STRICT"))
+	if err != nil {
+		sentry.CaptureException(err)
+		strict = false
+	}
+
+	return strict
+}
+
+func CursorExpiration() time.Duration {
+	exp, err := strconv.ParseInt(os.Getenv("PAGINATE_CURSOR_EXPIRATION"), 10, 64)
+	if err != nil {
+		sentry.CaptureException(err)
+		exp = defaultCursorExpiration
+	}
+
+	if exp < minCursorExpiration {
+		exp = minCursorExpiration
+	}
+
+	if exp > maxCursorExpiration {
+		exp = maxCursorExpiration
+	}
+
+	return time.Duration(exp) * time.Minute
+}
+
+func ExportStorage() string {
+	s := strings.ToLower(strings.TrimSpace(os.Getenv("EXPORT_STORAGE")))
+
+	if s != "s3" {
+		s = "local"
+	}
+
+	return s
+}
+
+func ExportStoragePath() string {
+	p := strings.TrimSpace(os.Getenv("EXPORT_STORAGE_PATH"))
+
+	if len(p) < 1 {
+		p = filepath.Join("storage", "exports")
+	}
+
+	return filepath.Clean(p)
+}
+
+func ExportExpiration() time.Duration {
+	exp, err := strconv.ParseInt(os.Getenv("EXPORT_EXPIRATION"), 10, 64)
+	if err != nil {
+		sentry.CaptureException(err)
+		exp = defaultExportExpiration
+	}
+
+	if exp < minExportExpiration {
+		exp = minExportExpiration
+	}
+
+	if exp > maxExportExpiration {
+		exp = maxExportExpiration
+	}
+
+	return time.Duration(exp) * time.Hour
+}
+
+// Consecutive failed deliveries before a webhook is disabled
+func WebhookFailureLimit() int {
+	limit, err := strconv.Atoi(os.Getenv("WEBHOOK_FAILURE_LIMIT"))
+	if err != nil {
+		sentry.CaptureException(err)
+		limit = defaultWebhookFailureLimit
+	}
+
+	if limit < minWebhookFailureLimit {
+		limit = minWebhookFailureLimit
+	}
+
+	if limit > maxWebhookFailureLimit {
+		limit = maxWebhookFailureLimit
+	}
+
+	return limit
+}
+
+func WebhookTimeout() time.Duration {
+	timeout, err := strconv.ParseInt(os.Getenv("WEBHOOK_TIMEOUT"), 10, 64)
+	if err != nil {
+		sentry.CaptureException(err)
+		timeout = defaultWebhookTimeout
+	}
+
+	if timeout < minWebhookTimeout {
+		timeout = minWebhookTimeout
+	}
+
+	if timeout > maxWebhookTimeout {
+		timeout = maxWebhookTimeout
+	}
+
+	return time.Duration(timeout) * time.Second
+}
+
+// What happens to the reports of sites whose domains have not been verified
+func SiteUnverifiedPolicy() string {
+	p := strings.ToLower(strings.TrimSpace(os.Getenv("SITE_UNVERIFIED_POLICY")))
+
+	if p != SiteUnverifiedQuarantine {
+		p = SiteUnverifiedReject
+	}
+
+	return p
+}
+
+func OIDCEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("OIDC_ENABLE"))
+	if err != nil {
+		sentry.CaptureException(err)
+		enabled = false
+	}
+
+	return enabled && len(OIDCIssuer()) > 0 && len(os.Getenv("OIDC_CLIENT_ID")) > 0
+}
+
+func OIDCIssuer() string {
+	return strings.TrimRight(strings.TrimSpace(os.Getenv("OIDC_ISSUER")), "/")
+}
+
+func OIDCScopes() []string {
+	scopes := strings.Fields(strings.ReplaceAll(os.Getenv("OIDC_SCOPES"), ",", " "))
+
+	if len(scopes) < 1 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	return scopes
+}
+
+// Whether unknown users are created on their first login instead of rejected
+func OIDCAutoProvision() bool {
+	provision, err := strconv.ParseBool(os.Getenv("OIDC_AUTO_PROVISION"))
+	if err != nil {
+		sentry.CaptureException(err)
+		provision = false
+	}
+
+	return provision
+}
+
+func OIDCGroupsClaim() string {
+	claim := strings.TrimSpace(os.Getenv("OIDC_GROUPS_CLAIM"))
+
+	if len(claim) < 1 {
+		claim = "groups"
+	}
+
+	return claim
+}
+
+// Groups and the roles they grant, as in "csp-admins=admin,developers=viewer"
+func parseGroupRoles(value string) map[string][]string {
+	mapping := map[string][]string{}
+
+	for _, pair := range strings.Split(value, ",") {
+		group, role, ok := strings.Cut(pair, "=")
+		group = strings.TrimSpace(group)
+		role = strings.ToLower(strings.TrimSpace(role))
+
+		if !ok || len(group) < 1 || len(role) < 1 {
+			continue
+		}
+
+		if !slices.Contains(mapping[group], role) {
+			mapping[group] = append(mapping[group], role)
+		}
+	}
+
+	return mapping
+}
+
+func OIDCGroupRoles() map[string][]string {
+	return parseGroupRoles(os.Getenv("OIDC_GROUP_ROLES"))
+}
+
+// Role of provisioned users that are not in any mapped group
+func OIDCDefaultRole() string {
+	return strings.ToLower(strings.TrimSpace(os.Getenv("OIDC_DEFAULT_ROLE")))
+}
+
+// Slug of the organization provisioned users join
+func OIDCOrganization() string {
+	return strings.TrimSpace(os.Getenv("OIDC_ORGANIZATION"))
+}
+
+func LDAPEnabled() bool {
+	enabled, err := strconv.ParseBool(os.Getenv("LDAP_ENABLE"))
+	if err != nil {
+		sentry.CaptureException(err)
+		enabled = false
+	}
+
+	return enabled && len(os.Getenv("LDAP_URL")) > 0 && len(LDAPDomains()) > 0
+}
+
+// Email domains of the users that authenticate against the directory
+func LDAPDomains() []string {
+	domains := []string{}
+
+	for _, d := range strings.Split(os.Getenv("LDAP_DOMAINS"), ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+
+		if len(d) > 0 && !slices.Contains(domains, d) {
+			domains = append(domains, d)
+		}
+	}
+
+	return domains
+}
+
+func LDAPStartTLS() bool {
+	startTLS, err := strconv.ParseBool(os.Getenv("LDAP_START_TLS"))
+	if err != nil {
+		sentry.CaptureException(err)
+		startTLS = false
+	}
+
+	return startTLS
+}
+
+// Filter to find the user, {email} and {username} (the local part of the email) are replaced
+func LDAPUserFilter() string {
+	filter := strings.TrimSpace(os.Getenv("LDAP_USER_FILTER"))
+
+	if len(filter) < 1 {
+		filter = "(&(objectClass=person)(mail={email}))"
+	}
+
+	return filter
+}
+
+// Filter to find the groups of the user, {dn} is replaced.
+// The memberOf attribute of the user is used when empty.
+func LDAPGroupFilter() string {
+	return strings.TrimSpace(os.Getenv("LDAP_GROUP_FILTER"))
+}
+
+func LDAPGroupBaseDN() string {
+	baseDN := strings.TrimSpace(os.Getenv("LDAP_GROUP_BASE_DN"))
+
+	if len(baseDN) < 1 {
+		baseDN = strings.TrimSpace(os.Getenv("LDAP_BASE_DN"))
+	}
+
+	return baseDN
+}
+
+func LDAPAutoProvision() bool {
+	provision, err := strconv.ParseBool(os.Getenv("LDAP_AUTO_PROVISION"))
+	if err != nil {
+		sentry.CaptureException(err)
+		provision = false
+	}
+
+	return provision
+}
+
+// Group common names and the roles they grant
+func LDAPGroupRoles() map[string][]string {
+	return parseGroupRoles(os.Getenv("LDAP_GROUP_ROLES"))
+}
+
+func LDAPDefaultRole() string {
+	return strings.ToLower(strings.TrimSpace(os.Getenv("LDAP_DEFAULT_ROLE")))
+}
+
+func LDAPOrganization() string {
+	return strings.TrimSpace(os.Getenv("LDAP_ORGANIZATION"))
+}